@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Policies for picking one archive out of several with the same chapter number
+const (
+	dedupePreferNewest  = "prefer-newest"
+	dedupePreferLargest = "prefer-largest"
+	dedupePreferGroup   = "prefer-group"
+)
+
+// chapterRange is an inclusive range of integer chapter numbers
+type chapterRange struct {
+	From int
+	To   int
+}
+
+func (r chapterRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// chapterAnalysis is the result of checking the resolved chapters before merging
+type chapterAnalysis struct {
+	Missing    []chapterRange  // gaps in the integer chapter sequence
+	Duplicates [][]chapterFile // archives sharing the same chapter number and volume
+	NoChapter  []chapterFile   // archives without a resolved chapter number
+}
+
+func (a chapterAnalysis) hasIssues() bool {
+	return len(a.Missing) > 0 || len(a.Duplicates) > 0 || len(a.NoChapter) > 0
+}
+
+// dedupePolicy describes how to choose between duplicate chapters, see parseDedupePolicy.
+// Groups is a priority list of scanlation groups, it is applied before Kind.
+type dedupePolicy struct {
+	Kind   string
+	Groups []string
+}
+
+// chapterInteger returns the integer part of a chapter number, e.g. 15 for "0015.5"
+func chapterInteger(chapter string) (int, bool) {
+	n, err := strconv.Atoi(strings.SplitN(chapter, ".", 2)[0])
+	return n, err == nil
+}
+
+// sameChapter reports whether two archives are the same chapter: equal chapter numbers and equal volumes. An archive
+// without a volume is only the same chapter as another without one, since series may restart chapter numbers every
+// volume.
+func sameChapter(a chapterFile, b chapterFile) bool {
+	return compareChapterNumbers(a.Chapter, b.Chapter) == 0 && sameVolume(a, b)
+}
+
+// sameVolume reports whether two archives have equal volumes, or both have none
+func sameVolume(a chapterFile, b chapterFile) bool {
+	if a.Volume == "" || b.Volume == "" {
+		return a.Volume == b.Volume
+	}
+	return compareChapterNumbers(a.Volume, b.Volume) == 0
+}
+
+// restartsNumbering reports whether any volume starts at or below the last integer chapter of the volume before it.
+// The files are expected to be sorted with sortChapterFiles.
+func restartsNumbering(files []chapterFile) bool {
+	var previous *chapterFile
+	var lastInteger int
+	haveInteger := false
+	for i := range files {
+		f := files[i]
+		if f.Chapter == "" || f.Volume == "" {
+			continue
+		}
+		n, ok := chapterInteger(f.Chapter)
+		if !ok {
+			continue
+		}
+		if previous != nil && !sameVolume(*previous, f) && haveInteger && n <= lastInteger {
+			return true
+		}
+		previous = &files[i]
+		lastInteger, haveInteger = n, true
+	}
+	return false
+}
+
+// chapterLabel names the chapter of an archive for reports, with its volume if it has one
+func chapterLabel(f chapterFile) string {
+	if f.Volume == "" {
+		return f.Chapter
+	}
+	return fmt.Sprintf("%s of volume %s", f.Chapter, f.Volume)
+}
+
+// analyzeChapters reports gaps, duplicates and archives without a chapter number.
+// The files are expected to be sorted with sortChapterFiles. When a volume restarts the chapter numbers, every volume,
+// and the archives without one, is checked for gaps on its own; otherwise the numbers run on across volumes.
+func analyzeChapters(files []chapterFile) chapterAnalysis {
+	var result chapterAnalysis
+
+	restarts := restartsNumbering(files)
+	var previous *chapterFile
+	var duplicates []chapterFile
+	var lastInteger int
+	haveInteger := false
+	for i := range files {
+		f := files[i]
+		if f.Chapter == "" {
+			result.NoChapter = append(result.NoChapter, f)
+			continue
+		}
+
+		// Sorted input means duplicates are adjacent
+		if previous != nil && sameChapter(*previous, f) {
+			if len(duplicates) == 0 {
+				duplicates = append(duplicates, *previous)
+			}
+			duplicates = append(duplicates, f)
+		} else if len(duplicates) > 0 {
+			result.Duplicates = append(result.Duplicates, duplicates)
+			duplicates = nil
+		}
+		newVolume := previous != nil && !sameVolume(*previous, f)
+		previous = &files[i]
+
+		n, ok := chapterInteger(f.Chapter)
+		if !ok {
+			continue
+		}
+		if newVolume && restarts {
+			haveInteger = false
+		}
+		if haveInteger && n > lastInteger+1 {
+			result.Missing = append(result.Missing, chapterRange{lastInteger + 1, n - 1})
+		}
+		if !haveInteger || n > lastInteger {
+			lastInteger = n
+		}
+		haveInteger = true
+	}
+	if len(duplicates) > 0 {
+		result.Duplicates = append(result.Duplicates, duplicates)
+	}
+
+	return result
+}
+
+// printChapterAnalysis writes a human-readable report of the analysis, nothing if there are no issues
+func printChapterAnalysis(w io.Writer, a chapterAnalysis) {
+	if len(a.Missing) > 0 {
+		missing := make([]string, len(a.Missing))
+		for i, r := range a.Missing {
+			missing[i] = r.String()
+		}
+		fmt.Fprintf(w, "Missing chapters: %s\n", strings.Join(missing, ", "))
+	}
+	for _, dups := range a.Duplicates {
+		fmt.Fprintf(w, "Duplicate chapter %s:\n", chapterLabel(dups[0]))
+		for _, f := range dups {
+			fmt.Fprintf(w, "  %s\n", filepath.Base(f.Path))
+		}
+	}
+	if len(a.NoChapter) > 0 {
+		fmt.Fprintln(w, "Files without a chapter number:")
+		for _, f := range a.NoChapter {
+			fmt.Fprintf(w, "  %s\n", filepath.Base(f.Path))
+		}
+	}
+}
+
+// parseDedupePolicy parses the --dedupe flag value (prefer-newest, prefer-largest or prefer-group=X)
+// and the comma-separated --group-priority list. Empty values for both mean no deduplication.
+func parseDedupePolicy(value string, groupPriority string) (*dedupePolicy, error) {
+	var groups []string
+	for _, group := range strings.Split(groupPriority, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	kind, group, hasGroup := strings.Cut(value, "=")
+	switch {
+	case value == "" && len(groups) == 0:
+		return nil, nil
+	case value == "":
+		return &dedupePolicy{Groups: groups}, nil
+	case kind == dedupePreferNewest && !hasGroup, kind == dedupePreferLargest && !hasGroup:
+		return &dedupePolicy{Kind: kind, Groups: groups}, nil
+	case kind == dedupePreferGroup && strings.TrimSpace(group) != "":
+		return &dedupePolicy{Kind: kind, Groups: append([]string{strings.TrimSpace(group)}, groups...)}, nil
+	}
+	return nil, fmt.Errorf("invalid dedupe policy %q, expected %s, %s or %s=<group>",
+		value, dedupePreferNewest, dedupePreferLargest, dedupePreferGroup)
+}
+
+// pick returns the index of the preferred archive among duplicates.
+// Ties are resolved in favour of the first archive.
+func (p dedupePolicy) pick(dups []chapterFile) int {
+	best := 0
+	for i := 1; i < len(dups); i++ {
+		rank, bestRank := p.groupRank(dups[i]), p.groupRank(dups[best])
+		if rank != bestRank {
+			if rank < bestRank {
+				best = i
+			}
+			continue
+		}
+		switch p.Kind {
+		case dedupePreferNewest:
+			if dups[i].ModTime.After(dups[best].ModTime) {
+				best = i
+			}
+		case dedupePreferLargest:
+			if dups[i].Size > dups[best].Size {
+				best = i
+			}
+		}
+	}
+	return best
+}
+
+// groupRank returns the position of the file's group in the priority list, len(Groups) if it isn't listed.
+// Files without a known group are matched by their filename.
+func (p dedupePolicy) groupRank(f chapterFile) int {
+	haystack := f.Group
+	if haystack == "" {
+		haystack = filepath.Base(f.Path)
+	}
+	haystack = strings.ToLower(haystack)
+	for i, group := range p.Groups {
+		if strings.Contains(haystack, strings.ToLower(group)) {
+			return i
+		}
+	}
+	return len(p.Groups)
+}
+
+// dedupeChapters keeps one archive per duplicate chapter according to the policy.
+// Returns the remaining files, in their original order, and the dropped ones.
+func dedupeChapters(files []chapterFile, duplicates [][]chapterFile, policy dedupePolicy) ([]chapterFile, []chapterFile) {
+	drop := make(map[string]bool)
+	var dropped []chapterFile
+	for _, dups := range duplicates {
+		keep := policy.pick(dups)
+		for i, f := range dups {
+			if i != keep {
+				drop[f.Path] = true
+				dropped = append(dropped, f)
+			}
+		}
+	}
+
+	kept := make([]chapterFile, 0, len(files)-len(dropped))
+	for _, f := range files {
+		if !drop[f.Path] {
+			kept = append(kept, f)
+		}
+	}
+	return kept, dropped
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helper function to build sorted chapter files from chapter numbers ("" means no chapter)
+func chaptersFromNumbers(numbers ...string) []chapterFile {
+	var files []chapterFile
+	for i, n := range numbers {
+		f := resolveChapter(string(rune('a'+i))+".cbz", ComicInfo{Number: n})
+		files = append(files, f)
+	}
+	sortChapterFiles(files)
+	return files
+}
+
+func TestAnalyzeChapters(t *testing.T) {
+	testCases := []struct {
+		numbers            []string
+		expectedMissing    string
+		expectedDuplicates []string
+		expectedNoChapter  int
+		description        string
+	}{
+		{[]string{"1", "2", "3"}, "", nil, 0, "Complete sequence has no issues"},
+		{[]string{"1", "2", "2.5", "3"}, "", nil, 0, "Decimal chapters are not gaps"},
+		{[]string{"1", "3"}, "2", nil, 0, "Single missing chapter"},
+		{[]string{"10", "11", "15", "17"}, "12-14, 16", nil, 0, "Missing ranges"},
+		{[]string{"1", "1.5", "3"}, "2", nil, 0, "Decimal chapter does not fill the gap"},
+		{[]string{"1", "2", "2", "3"}, "", []string{"2"}, 0, "Duplicate chapter"},
+		{[]string{"1", "02", "2", "2", "3", "3"}, "", []string{"02", "3"}, 0, "Several duplicate chapters, leading zeros are equal"},
+		{[]string{"1", "2", "", ""}, "", nil, 2, "Files without chapter"},
+		{[]string{"", ""}, "", nil, 2, "Only files without chapter"},
+	}
+
+	for _, tc := range testCases {
+		result := analyzeChapters(chaptersFromNumbers(tc.numbers...))
+
+		missing := make([]string, len(result.Missing))
+		for i, r := range result.Missing {
+			missing[i] = r.String()
+		}
+		if strings.Join(missing, ", ") != tc.expectedMissing {
+			t.Errorf("Test '%s': Expected missing '%s', got '%s'", tc.description, tc.expectedMissing, strings.Join(missing, ", "))
+		}
+
+		if len(result.Duplicates) != len(tc.expectedDuplicates) {
+			t.Errorf("Test '%s': Expected %d duplicates, got %d", tc.description, len(tc.expectedDuplicates), len(result.Duplicates))
+		} else {
+			for i, dups := range result.Duplicates {
+				if compareChapterNumbers(dups[0].Chapter, tc.expectedDuplicates[i]) != 0 {
+					t.Errorf("Test '%s': Expected duplicate chapter %s, got %s", tc.description, tc.expectedDuplicates[i], dups[0].Chapter)
+				}
+			}
+		}
+
+		if len(result.NoChapter) != tc.expectedNoChapter {
+			t.Errorf("Test '%s': Expected %d files without chapter, got %d", tc.description, tc.expectedNoChapter, len(result.NoChapter))
+		}
+
+		expectedIssues := tc.expectedMissing != "" || len(tc.expectedDuplicates) > 0 || tc.expectedNoChapter > 0
+		if result.hasIssues() != expectedIssues {
+			t.Errorf("Test '%s': Expected hasIssues %v, got %v", tc.description, expectedIssues, result.hasIssues())
+		}
+	}
+}
+
+func TestAnalyzeChaptersVolumes(t *testing.T) {
+	files := []chapterFile{
+		resolveChapter("a.cbz", ComicInfo{Volume: "1", Number: "1"}),
+		resolveChapter("b.cbz", ComicInfo{Volume: "1", Number: "2"}),
+		resolveChapter("c.cbz", ComicInfo{Volume: "2", Number: "1"}),
+		resolveChapter("d.cbz", ComicInfo{Volume: "2", Number: "3"}),
+		resolveChapter("e.cbz", ComicInfo{Volume: "2", Number: "3"}),
+	}
+	sortChapterFiles(files)
+	result := analyzeChapters(files)
+
+	if len(result.Duplicates) != 1 || len(result.Duplicates[0]) != 2 || result.Duplicates[0][0].Path != "d.cbz" {
+		t.Errorf("Expected only chapter 3 of volume 2 to be a duplicate, got %v", result.Duplicates)
+	}
+	if len(result.Missing) != 1 || result.Missing[0].String() != "2" {
+		t.Errorf("Expected chapter 2 to be missing from volume 2, got %v", result.Missing)
+	}
+
+	var buf bytes.Buffer
+	printChapterAnalysis(&buf, result)
+	if !strings.Contains(buf.String(), "Duplicate chapter 3 of volume 2") {
+		t.Errorf("Expected the report to name the volume, got:\n%s", buf.String())
+	}
+}
+
+func TestAnalyzeChaptersVolumeless(t *testing.T) {
+	testCases := []struct {
+		description        string
+		infos              []ComicInfo
+		expectedMissing    string
+		expectedDuplicates int
+	}{
+		{"volumeless chapters after per-volume numbering", []ComicInfo{
+			{Volume: "1", Number: "1"}, {Volume: "1", Number: "2"},
+			{Volume: "2", Number: "1"}, {Volume: "2", Number: "2"},
+			{Number: "2"}, {Number: "3"},
+		}, "", 0},
+		{"gap within the volumeless chapters", []ComicInfo{
+			{Volume: "1", Number: "1"}, {Volume: "2", Number: "1"}, {Number: "5"}, {Number: "7"},
+		}, "6", 0},
+		{"numbers running on across volumes", []ComicInfo{
+			{Volume: "1", Number: "1"}, {Volume: "1", Number: "2"}, {Volume: "2", Number: "4"}, {Number: "6"},
+		}, "3, 5", 0},
+		{"no volumes at all", []ComicInfo{{Number: "1"}, {Number: "1"}, {Number: "3"}}, "2", 1},
+	}
+
+	for _, tc := range testCases {
+		var files []chapterFile
+		for i, info := range tc.infos {
+			files = append(files, resolveChapter(string(rune('a'+i))+".cbz", info))
+		}
+		sortChapterFiles(files)
+		result := analyzeChapters(files)
+
+		missing := make([]string, len(result.Missing))
+		for i, r := range result.Missing {
+			missing[i] = r.String()
+		}
+		if strings.Join(missing, ", ") != tc.expectedMissing {
+			t.Errorf("Test '%s': Expected missing '%s', got '%s'", tc.description, tc.expectedMissing, strings.Join(missing, ", "))
+		}
+		if len(result.Duplicates) != tc.expectedDuplicates {
+			t.Errorf("Test '%s': Expected %d duplicates, got %v", tc.description, tc.expectedDuplicates, result.Duplicates)
+		}
+	}
+}
+
+func TestParseDedupePolicy(t *testing.T) {
+	testCases := []struct {
+		value          string
+		groupPriority  string
+		expectedKind   string
+		expectedGroups string
+		expectError    bool
+		description    string
+	}{
+		{"", "", "", "", false, "Empty values disable deduplication"},
+		{"prefer-newest", "", dedupePreferNewest, "", false, "Newest"},
+		{"prefer-largest", "", dedupePreferLargest, "", false, "Largest"},
+		{"prefer-group=Super Scans", "", dedupePreferGroup, "Super Scans", false, "Group"},
+		{"", "Alpha, Beta ,,Gamma", "", "Alpha|Beta|Gamma", false, "Group priority alone enables deduplication"},
+		{"prefer-largest", "Alpha,Beta", dedupePreferLargest, "Alpha|Beta", false, "Group priority with a tie-breaker"},
+		{"prefer-group=Gamma", "Alpha", dedupePreferGroup, "Gamma|Alpha", false, "prefer-group goes before the priority list"},
+		{"prefer-group=", "", "", "", true, "Group without a name"},
+		{"prefer-group", "", "", "", true, "Group without '='"},
+		{"prefer-newest=x", "", "", "", true, "Newest with a value"},
+		{"prefer-oldest", "Alpha", "", "", true, "Unknown policy"},
+	}
+
+	for _, tc := range testCases {
+		policy, err := parseDedupePolicy(tc.value, tc.groupPriority)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("Test '%s': Expected an error for '%s'", tc.description, tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test '%s': Unexpected error %v", tc.description, err)
+			continue
+		}
+		if tc.value == "" && tc.groupPriority == "" {
+			if policy != nil {
+				t.Errorf("Test '%s': Expected no policy, got %+v", tc.description, policy)
+			}
+			continue
+		}
+		if policy.Kind != tc.expectedKind || strings.Join(policy.Groups, "|") != tc.expectedGroups {
+			t.Errorf("Test '%s': Expected %s/%s, got %s/%s", tc.description,
+				tc.expectedKind, tc.expectedGroups, policy.Kind, strings.Join(policy.Groups, "|"))
+		}
+	}
+}
+
+func TestDedupeChapters(t *testing.T) {
+	now := time.Now()
+	files := []chapterFile{
+		{Path: "Ch.001 [Alpha].cbz", Chapter: "1", Group: "Alpha", Size: 10, ModTime: now},
+		{Path: "Ch.002 [Alpha].cbz", Chapter: "2", Group: "Alpha", Size: 10, ModTime: now},
+		{Path: "Ch.002 [Beta].cbz", Chapter: "2", Group: "Beta", Size: 30, ModTime: now.Add(-time.Hour)},
+		{Path: "Ch.002 [Gamma].cbz", Chapter: "2", Group: "Gamma & Delta", Size: 20, ModTime: now.Add(time.Hour)},
+		{Path: "Ch.003 [Beta].cbz", Chapter: "3", Group: "Beta", Size: 10, ModTime: now},
+	}
+
+	testCases := []struct {
+		policy       dedupePolicy
+		expectedKept string
+		description  string
+	}{
+		{dedupePolicy{Kind: dedupePreferNewest}, "Ch.002 [Gamma].cbz", "Newest file is kept"},
+		{dedupePolicy{Kind: dedupePreferLargest}, "Ch.002 [Beta].cbz", "Largest file is kept"},
+		{dedupePolicy{Kind: dedupePreferGroup, Groups: []string{"beta"}}, "Ch.002 [Beta].cbz", "Group match is case-insensitive"},
+		{dedupePolicy{Kind: dedupePreferGroup, Groups: []string{"Delta"}}, "Ch.002 [Gamma].cbz", "One of several groups matches"},
+		{dedupePolicy{Kind: dedupePreferGroup, Groups: []string{"Epsilon"}}, "Ch.002 [Alpha].cbz", "First file is kept if no group matches"},
+		{dedupePolicy{Groups: []string{"Epsilon", "Beta", "Alpha"}}, "Ch.002 [Beta].cbz", "Earlier groups in the priority list win"},
+		{dedupePolicy{Kind: dedupePreferNewest, Groups: []string{"Alpha", "Beta"}}, "Ch.002 [Alpha].cbz", "Group priority goes before the tie-breaker"},
+		{dedupePolicy{Kind: dedupePreferNewest, Groups: []string{"Zeta"}}, "Ch.002 [Gamma].cbz", "Tie-breaker is used when no group matches"},
+	}
+
+	for _, tc := range testCases {
+		kept, dropped := dedupeChapters(files, analyzeChapters(files).Duplicates, tc.policy)
+		if len(kept) != 3 || len(dropped) != 2 {
+			t.Errorf("Test '%s': Expected 3 kept and 2 dropped, got %d and %d", tc.description, len(kept), len(dropped))
+			continue
+		}
+		if kept[0].Path != files[0].Path || kept[1].Path != tc.expectedKept || kept[2].Path != files[4].Path {
+			t.Errorf("Test '%s': Expected %s to be kept, got %v", tc.description, tc.expectedKept, kept)
+		}
+	}
+}
+
+func TestPrintChapterAnalysis(t *testing.T) {
+	var buf bytes.Buffer
+	printChapterAnalysis(&buf, analyzeChapters(chaptersFromNumbers("1", "1", "4", "")))
+	output := buf.String()
+
+	for _, expected := range []string{"Missing chapters: 2-3", "Duplicate chapter 1", "Files without a chapter number"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected report to contain '%s', got:\n%s", expected, output)
+		}
+	}
+
+	buf.Reset()
+	printChapterAnalysis(&buf, analyzeChapters(chaptersFromNumbers("1", "2")))
+	if buf.Len() != 0 {
+		t.Errorf("Expected no report without issues, got:\n%s", buf.String())
+	}
+}
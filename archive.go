@@ -0,0 +1,440 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode"
+)
+
+const formatCBT = "cbt"
+
+// parseComicInfo parses ComicInfo.xml content
+func parseComicInfo(data []byte) (ComicInfo, error) {
+	var info ComicInfo
+	err := xml.Unmarshal(data, &info)
+	return info, err
+}
+
+// ComicInfo page types cbztools sets
+const (
+	comicPageFrontCover = "FrontCover"
+	comicPageInnerCover = "InnerCover"
+)
+
+// comicPageInfo is a <Page> of ComicInfo.xml, with the attributes kept on Page
+type comicPageInfo struct {
+	Image      int    `xml:"Image,attr"`
+	Type       string `xml:"Type,attr,omitempty"`
+	DoublePage string `xml:"DoublePage,attr,omitempty"` // a string, parsing fails on values a bool doesn't take
+}
+
+// comicInfoDocument is ComicInfo.xml as written: ComicInfo with the page list in its schema place, before the
+// elements that follow it
+type comicInfoDocument struct {
+	ComicInfo
+	Pages               []comicPageInfo `xml:"Pages>Page,omitempty"`
+	CommunityRating     string          `xml:"CommunityRating,omitempty"`
+	MainCharacterOrTeam string          `xml:"MainCharacterOrTeam,omitempty"`
+	Review              string          `xml:"Review,omitempty"`
+	GTIN                string          `xml:"GTIN,omitempty"`
+}
+
+// parseComicPages returns the <Page> elements of ComicInfo.xml, nil if there are none or it can't be parsed
+func parseComicPages(data []byte) []comicPageInfo {
+	var doc struct {
+		Pages []comicPageInfo `xml:"Pages>Page"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	return doc.Pages
+}
+
+// comicInfoXML returns the ComicInfo.xml content written into archives.
+// Pages lists every page, like ComicRack writes it, but only if some page has a type or is a double page.
+func comicInfoXML(book *Book) ([]byte, error) {
+	doc := comicInfoDocument{ComicInfo: book.Info, CommunityRating: book.Info.CommunityRating,
+		MainCharacterOrTeam: book.Info.MainCharacterOrTeam, Review: book.Info.Review, GTIN: book.Info.GTIN}
+	for _, page := range book.Pages {
+		if page.Type != "" || page.DoublePage {
+			for i, page := range book.Pages {
+				info := comicPageInfo{Image: i, Type: page.Type}
+				if page.DoublePage {
+					info.DoublePage = "true"
+				}
+				doc.Pages = append(doc.Pages, info)
+			}
+			break
+		}
+	}
+	xmlBytes, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), xmlBytes...), nil
+}
+
+// naturalLess compares names with digit runs compared as numbers, so "page2" sorts before "page10"
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aNumber, bNumber := strings.TrimLeft(aDigits, "0"), strings.TrimLeft(bDigits, "0")
+			if len(aNumber) != len(bNumber) {
+				return len(aNumber) < len(bNumber)
+			}
+			if aNumber != bNumber {
+				return aNumber < bNumber
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// newArchiveBook creates a single-chapter book from the pages and metadata of an archive
+func newArchiveBook(meta metadataFiles, pages []Page, closers ...io.Closer) *Book {
+	info, source := meta.resolve()
+	if data, ok := meta.data(metaSourceComicInfo); ok {
+		for _, p := range parseComicPages(data) {
+			if p.Image >= 0 && p.Image < len(pages) {
+				pages[p.Image].Type = p.Type
+				pages[p.Image].DoublePage = p.DoublePage == "true" || p.DoublePage == "1"
+			}
+		}
+	}
+	book := &Book{Info: info, InfoSource: source, IDs: meta.ids(), Pages: pages, Chapters: []Chapter{{Title: info.Title}}, closers: closers}
+	if _, ok := meta.data(metaSourceMetronInfo); ok {
+		book.ExtraMetadata = append(book.ExtraMetadata, extraMetronInfo)
+	}
+	if _, err := parseComicBookInfo([]byte(meta.Comment)); err == nil {
+		book.ExtraMetadata = append(book.ExtraMetadata, extraComicBookInfo)
+	} else {
+		book.Comment = meta.Comment
+	}
+	return book
+}
+
+// readCBR reads a RAR comic archive. RAR files can only be read sequentially, so the pages are loaded into memory.
+// Images are taken in archive order, like for CBZ.
+func readCBR(filePath string) (*Book, error) {
+	r, err := rardecode.OpenReader(filePath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var meta metadataFiles
+	var pages []Page
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.IsDir {
+			continue
+		}
+		if err := meta.offer(header.Name, func() ([]byte, error) { return io.ReadAll(r) }); err != nil {
+			return nil, err
+		}
+		if isImageExt(strings.ToLower(path.Ext(header.Name))) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", header.Name, err)
+			}
+			pages = append(pages, newBytesPage(header.Name, data))
+		}
+	}
+	return newArchiveBook(meta, pages), nil
+}
+
+// readCB7 reads a 7z comic archive. Images are taken in archive order, like for CBZ.
+func readCB7(filePath string) (*Book, error) {
+	r, err := sevenzip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta metadataFiles
+	var pages []Page
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := meta.offer(f.Name, readOpened(f.Open)); err != nil {
+			r.Close()
+			return nil, err
+		}
+		if isImageExt(strings.ToLower(path.Ext(f.Name))) {
+			pages = append(pages, Page{Name: f.Name, open: f.Open})
+		}
+	}
+	return newArchiveBook(meta, pages, r), nil
+}
+
+// readCBT reads a tar comic archive. Tar stores files uncompressed, so pages are read from their offsets in the file
+// when needed. Images are taken in archive order, like for CBZ.
+func readCBT(filePath string) (*Book, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta metadataFiles
+	var pages, files []Page
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// The reader stops right after the header, at the start of the file's data
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		section := io.NewSectionReader(f, offset, header.Size)
+		entry := Page{Name: header.Name, open: func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(section, 0, section.Size())), nil
+		}}
+		if isImageExt(strings.ToLower(path.Ext(header.Name))) {
+			pages = append(pages, entry)
+			continue
+		}
+		if !isWrittenMetadata(header.Name) {
+			files = append(files, entry)
+		}
+		if err := meta.offer(header.Name, func() ([]byte, error) { return io.ReadAll(tr) }); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	book := newArchiveBook(meta, pages, f)
+	book.Files = files
+	return book, nil
+}
+
+// readFolder reads a directory of images, including subdirectories, with metadata sidecars found like in archives.
+// A folder has no inherent order, so the images are sorted by their paths in natural order.
+func readFolder(dir string) (*Book, error) {
+	var meta metadataFiles
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if err := meta.offer(rel, func() ([]byte, error) { return os.ReadFile(p) }); err != nil {
+			return err
+		}
+		if isImageExt(strings.ToLower(filepath.Ext(p))) {
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return naturalLess(names[i], names[j])
+	})
+
+	pages := make([]Page, 0, len(names))
+	for _, name := range names {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		pages = append(pages, Page{Name: name, open: func() (io.ReadCloser, error) {
+			return os.Open(full)
+		}})
+	}
+	return newArchiveBook(meta, pages), nil
+}
+
+// writeCBT writes the book as a tar comic archive, laid out like writeCBZ
+func writeCBT(book *Book, filePath string) (err error) {
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	tw := tar.NewWriter(out)
+	modTime := time.Now()
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	for i, page := range book.Pages {
+		data, err := page.ReadAll()
+		if err != nil {
+			return err
+		}
+		if err := writeFile(fmt.Sprintf("%05d%s", i+1, page.Ext()), data); err != nil {
+			return err
+		}
+	}
+	for _, file := range book.Files {
+		data, err := file.ReadAll()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		if err := writeFile(file.Name, data); err != nil {
+			return err
+		}
+	}
+
+	xmlBytes, err := comicInfoXML(book)
+	if err != nil {
+		return err
+	}
+	if err := writeFile("ComicInfo.xml", xmlBytes); err != nil {
+		return err
+	}
+	if book.writesMetadata(extraMetronInfo) {
+		xmlBytes, err := metronInfoXML(book)
+		if err != nil {
+			return err
+		}
+		if err := writeFile("MetronInfo.xml", xmlBytes); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// walkEntries calls fn for every file in an archive or folder, in archive order. open is only valid during
+// the call for formats that are read sequentially. Errors opening or reading an entry are left to fn,
+// walkEntries only fails if the archive itself can't be read.
+func walkEntries(filePath string, fn func(name string, open func() (io.ReadCloser, error)) error) error {
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		return filepath.WalkDir(filePath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(filePath, p)
+			return fn(filepath.ToSlash(rel), func() (io.ReadCloser, error) {
+				return os.Open(p)
+			})
+		})
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".cbz", ".epub":
+		r, err := zip.OpenReader(filePath)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			if err := fn(f.Name, f.Open); err != nil {
+				return err
+			}
+		}
+	case ".cbr":
+		r, err := rardecode.OpenReader(filePath, "")
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for {
+			header, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if header.IsDir {
+				continue
+			}
+			if err := fn(header.Name, func() (io.ReadCloser, error) { return io.NopCloser(r), nil }); err != nil {
+				return err
+			}
+		}
+	case ".cb7":
+		r, err := sevenzip.OpenReader(filePath)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			if err := fn(f.Name, f.Open); err != nil {
+				return err
+			}
+		}
+	case ".cbt":
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tr := tar.NewReader(f)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := fn(header.Name, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s has no entries", filepath.Ext(filePath))
+	}
+	return nil
+}
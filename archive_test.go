@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	testCases := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{"page2.png", "page10.png", true},
+		{"page10.png", "page2.png", false},
+		{"page002.png", "page2.png", false},
+		{"a/page9.png", "b/page1.png", true},
+		{"page.png", "page1.png", true},
+		{"page1a.png", "page1b.png", true},
+		{"same.png", "same.png", false},
+	}
+
+	for _, tc := range testCases {
+		if result := naturalLess(tc.a, tc.b); result != tc.expected {
+			t.Errorf("Expected naturalLess(%q, %q) to be %v, got %v", tc.a, tc.b, tc.expected, result)
+		}
+	}
+}
+
+// Helper function to build a RAR 4 archive with stored (uncompressed) files
+func testRAR(files map[string][]byte, order []string) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte("Rar!\x1a\x07\x00"))
+
+	writeBlock := func(body []byte) {
+		// The header CRC covers everything after the CRC field
+		binary.Write(&buf, binary.LittleEndian, uint16(crc32.ChecksumIEEE(body)))
+		buf.Write(body)
+	}
+
+	main := []byte{0x73, 0x00, 0x00, 13, 0x00, 0, 0, 0, 0, 0, 0}
+	writeBlock(main)
+
+	for _, name := range order {
+		data := files[name]
+		var header bytes.Buffer
+		header.WriteByte(0x74)
+		binary.Write(&header, binary.LittleEndian, uint16(0x8000))
+		binary.Write(&header, binary.LittleEndian, uint16(32+len(name)))
+		binary.Write(&header, binary.LittleEndian, uint32(len(data)))
+		binary.Write(&header, binary.LittleEndian, uint32(len(data)))
+		header.WriteByte(0) // MS-DOS
+		binary.Write(&header, binary.LittleEndian, crc32.ChecksumIEEE(data))
+		binary.Write(&header, binary.LittleEndian, uint32(0x21))
+		header.WriteByte(20)   // version needed
+		header.WriteByte(0x30) // stored
+		binary.Write(&header, binary.LittleEndian, uint16(len(name)))
+		binary.Write(&header, binary.LittleEndian, uint32(0x20))
+		header.WriteString(name)
+		writeBlock(header.Bytes())
+		buf.Write(data)
+	}
+
+	buf.Write([]byte{0xc4, 0x3d, 0x7b, 0x00, 0x40, 0x07, 0x00})
+	return buf.Bytes()
+}
+
+func TestReadCBR(t *testing.T) {
+	page1, _ := testPNGPage(t, "1.png", 10, 20).ReadAll()
+	page2, _ := testPNGPage(t, "2.png", 11, 21).ReadAll()
+	files := map[string][]byte{
+		"b.png":         page1,
+		"a.png":         page2,
+		"notes.txt":     []byte("ignored"),
+		"ComicInfo.xml": []byte("<ComicInfo><Series>Rar</Series><Number>3</Number></ComicInfo>"),
+	}
+	path := filepath.Join(t.TempDir(), "chapter.cbr")
+	if err := os.WriteFile(path, testRAR(files, []string{"b.png", "notes.txt", "a.png", "ComicInfo.xml"}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := readBook(path)
+	if err != nil {
+		t.Fatalf("Failed to read CBR: %v", err)
+	}
+	defer book.Close()
+
+	if len(book.Pages) != 2 || book.Pages[0].Name != "b.png" || book.Pages[1].Name != "a.png" {
+		t.Fatalf("Expected the images in archive order, got %v", book.Pages)
+	}
+	if data, _ := book.Pages[1].ReadAll(); !bytes.Equal(data, page2) {
+		t.Errorf("Expected the page content to be read")
+	}
+	if book.Info.Series != "Rar" || book.Info.Number != "3" {
+		t.Errorf("Expected ComicInfo from the archive, got %+v", book.Info)
+	}
+}
+
+func TestReadCB7(t *testing.T) {
+	book, err := readBook(filepath.Join("testdata", "pages.cb7"))
+	if err != nil {
+		t.Fatalf("Failed to read CB7: %v", err)
+	}
+	defer book.Close()
+
+	if len(book.Pages) != 2 {
+		t.Fatalf("Expected 2 pages, got %d", len(book.Pages))
+	}
+	for i, expected := range [][2]int{{3, 4}, {5, 6}} {
+		config, _, err := book.Pages[i].DecodeConfig()
+		if err != nil || config.Width != expected[0] || config.Height != expected[1] {
+			t.Errorf("Expected page %d to be %v, got %dx%d (%v)", i, expected, config.Width, config.Height, err)
+		}
+	}
+	if book.Info.Series != "Seven" || book.Info.Number != "7" {
+		t.Errorf("Expected ComicInfo from the archive, got %+v", book.Info)
+	}
+}
+
+func TestCBTRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbt")
+	book := testBook(t, ComicInfo{Series: "Tar", Number: "1"}, 2, 1)
+	if err := writeBook(book, path, formatCBT); err != nil {
+		t.Fatalf("Failed to write CBT: %v", err)
+	}
+
+	result, err := readBook(path)
+	if err != nil {
+		t.Fatalf("Failed to read CBT: %v", err)
+	}
+	defer result.Close()
+	var names []string
+	for i, page := range result.Pages {
+		names = append(names, page.Name)
+		expected, _ := book.Pages[i].ReadAll()
+		if data, _ := page.ReadAll(); !bytes.Equal(data, expected) {
+			t.Errorf("Expected page %d to be unchanged", i)
+		}
+	}
+	if expected := []string{"00001.png", "00002.png", "00003.png"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, names)
+	}
+	if result.Info.Series != "Tar" || result.Info.Number != "1" {
+		t.Errorf("Expected ComicInfo to round trip, got %+v", result.Info)
+	}
+}
+
+func TestReadFolder(t *testing.T) {
+	dir := t.TempDir()
+	page, _ := testPNGPage(t, "page.png", 10, 20).ReadAll()
+	for _, name := range []string{"page10.png", "page2.png", "sub/page1.jpg", "Thumbs.db"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), page, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "ComicInfo.xml"), []byte("<ComicInfo><Title>Folder</Title></ComicInfo>"), 0o644)
+
+	book, err := readBook(dir)
+	if err != nil {
+		t.Fatalf("Failed to read folder: %v", err)
+	}
+	var names []string
+	for _, page := range book.Pages {
+		names = append(names, page.Name)
+	}
+	if expected := []string{"page2.png", "page10.png", "sub/page1.jpg"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, names)
+	}
+	if book.Info.Title != "Folder" || book.Chapters[0].Title != "Folder" {
+		t.Errorf("Expected ComicInfo from the folder, got %+v", book.Info)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blocklistName is the blocklist file, kept next to the config file
+const blocklistName = "blocklist.json"
+
+// blocklist is the list of known junk pages, like scanlator recruitment pages, that concat removes
+type blocklist struct {
+	Entries []blocklistEntry `json:"entries"`
+}
+
+// blocklistEntry is a junk page, by both its hashes in hex. A page matches when both are within the threshold,
+// pages are removed without asking so matching errs on the side of keeping them.
+type blocklistEntry struct {
+	Label  string    `json:"label"`
+	DHash  string    `json:"dhash"`
+	PHash  string    `json:"phash"`
+	Source string    `json:"source,omitempty"` // the file and page it was added from
+	Added  time.Time `json:"added"`
+}
+
+// defaultBlocklistPath returns the blocklist next to the config file, see configPath
+func defaultBlocklistPath() string {
+	path := configPath()
+	if path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), blocklistName)
+}
+
+// formatHash writes a hash the way the blocklist stores it
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// hash returns the entry's hashes as a pageHash
+func (e blocklistEntry) hash() (pageHash, error) {
+	dhash, err := strconv.ParseUint(e.DHash, 16, 64)
+	if err != nil {
+		return pageHash{}, fmt.Errorf("invalid dhash %q", e.DHash)
+	}
+	phash, err := strconv.ParseUint(e.PHash, 16, 64)
+	if err != nil {
+		return pageHash{}, fmt.Errorf("invalid phash %q", e.PHash)
+	}
+	return pageHash{DHash: dhash, PHash: phash}, nil
+}
+
+// readBlocklist reads the blocklist file. A missing file is not an error and results in an empty blocklist.
+func readBlocklist(path string) (*blocklist, error) {
+	list := &blocklist{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, entry := range list.Entries {
+		if _, err := entry.hash(); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, i+1, err)
+		}
+	}
+	return list, nil
+}
+
+// write writes the blocklist file, creating its directory
+func (l *blocklist) write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// add adds an entry, or relabels the entry with the same hashes and returns false
+func (l *blocklist) add(entry blocklistEntry) bool {
+	for i := range l.Entries {
+		if l.Entries[i].DHash == entry.DHash && l.Entries[i].PHash == entry.PHash {
+			l.Entries[i].Label = entry.Label
+			return false
+		}
+	}
+	l.Entries = append(l.Entries, entry)
+	return true
+}
+
+// match returns the closest entry whose hashes are both within threshold of the page's
+func (l *blocklist) match(h pageHash, threshold int) (blocklistEntry, bool) {
+	best, bestDistance := -1, 0
+	for i, entry := range l.Entries {
+		e, err := entry.hash()
+		if err != nil {
+			continue
+		}
+		dDistance, pDistance := hashDistance(h.DHash, e.DHash), hashDistance(h.PHash, e.PHash)
+		if dDistance > threshold || pDistance > threshold {
+			continue
+		}
+		if best < 0 || dDistance+pDistance < bestDistance {
+			best, bestDistance = i, dDistance+pDistance
+		}
+	}
+	if best < 0 {
+		return blocklistEntry{}, false
+	}
+	return l.Entries[best], true
+}
+
+// blockedPage is a page that matches the blocklist
+type blockedPage struct {
+	Page  int // index into Book.Pages
+	Entry blocklistEntry
+}
+
+// findBlockedPages finds the pages that match the blocklist, by their hashes from hashPages. Blank pages never match,
+// nor do pages that can't be decoded, their errors are returned by page index.
+func findBlockedPages(hashes []pageHash, list *blocklist, threshold int) ([]blockedPage, map[int]error) {
+	failed := make(map[int]error)
+	if len(list.Entries) == 0 {
+		return nil, failed
+	}
+	var blocked []blockedPage
+	for i, h := range hashes {
+		if h.Err != nil {
+			failed[i] = h.Err
+			continue
+		}
+		if h.Uniform {
+			continue
+		}
+		if entry, ok := list.match(h, threshold); ok {
+			blocked = append(blocked, blockedPage{Page: i, Entry: entry})
+		}
+	}
+	return blocked, failed
+}
+
+// printBlockedPages writes the blocked pages grouped by chapter. Call it before the pages are removed, the pages are
+// numbered as they were found.
+func printBlockedPages(w io.Writer, book *Book, blocked []blockedPage) {
+	chapter := -1
+	for _, b := range blocked {
+		page := book.Pages[b.Page]
+		if page.Chapter != chapter && len(book.Chapters) > 0 {
+			chapter = page.Chapter
+			title := book.Chapters[chapter].Title
+			if title == "" {
+				title = fmt.Sprintf("Chapter %d", chapter+1)
+			}
+			fmt.Fprintf(w, "  %s:\n", title)
+		}
+		fmt.Fprintf(w, "    page %d (%s) matches %q\n", b.Page+1, page.Name, b.Entry.Label)
+	}
+}
+
+// dropBlockedPages removes the blocked pages from the book, and from its hashes if they're given, so they can be used
+// again. It returns the hashes left.
+func (b *Book) dropBlockedPages(blocked []blockedPage, hashes []pageHash) []pageHash {
+	removed := make(map[int]bool)
+	indexes := make([]int, 0, len(blocked))
+	for _, p := range blocked {
+		indexes = append(indexes, p.Page)
+		removed[p.Page] = true
+	}
+	b.removePages(indexes)
+	var kept []pageHash
+	for i, h := range hashes {
+		if !removed[i] {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+// cmdBlocklist dispatches the blocklist subcommands
+func cmdBlocklist(args []string) {
+	if len(args) == 0 {
+		fmt.Printf("cbztools blocklist v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools blocklist <command> [flags] [args]")
+		fmt.Println("Commands:")
+		fmt.Println("  add       Add a page of an archive to the blocklist")
+		fmt.Println("  list      Show the pages in the blocklist")
+		fmt.Println("  prune     Remove the blocklisted pages from archives")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "add":
+		cmdBlocklistAdd(args[1:])
+	case "list":
+		cmdBlocklistList(args[1:])
+	case "prune":
+		cmdBlocklistPrune(args[1:])
+	default:
+		fmt.Printf("Unknown blocklist command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// blocklistPathOrExit checks there is a blocklist path, there's no default if the user config directory is unknown
+func blocklistPathOrExit(path string) string {
+	if path == "" {
+		fmt.Println("No blocklist file, set one with --blocklist")
+		os.Exit(1)
+	}
+	return path
+}
+
+func cmdBlocklistAdd(args []string) {
+	cfg := loadConfigOrExit()
+	addFlags := flag.NewFlagSet("blocklist add", flag.ExitOnError)
+	label := addFlags.String("label", "", "What the page is, shown when it's removed; the file and page number by default")
+	path := addFlags.String("blocklist", cfg.get("blocklist", defaultBlocklistPath()), "The blocklist file")
+	runSilent := addFlags.Bool("s", false, "Silent mode, only print errors")
+	runVerbose := addFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	addFlags.Parse(args)
+
+	if addFlags.NArg() != 2 {
+		fmt.Printf("cbztools blocklist add v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools blocklist add [flags] <file> <page>")
+		fmt.Println("Flags:")
+		addFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	listPath := blocklistPathOrExit(*path)
+	input := addFlags.Arg(0)
+	pageNumber, err := strconv.Atoi(addFlags.Arg(1))
+	if err != nil || pageNumber < 1 {
+		fmt.Printf("Invalid page %q, pages are counted from 1\n", addFlags.Arg(1))
+		os.Exit(1)
+	}
+
+	list, err := readBlocklist(listPath)
+	if err != nil {
+		fmt.Printf("Could not read the blocklist: %v\n", err)
+		os.Exit(1)
+	}
+	book, err := readBook(input)
+	if err != nil {
+		fmt.Printf("Could not read %s: %v\n", input, err)
+		os.Exit(1)
+	}
+	defer book.Close()
+	if pageNumber > len(book.Pages) {
+		fmt.Printf("Page %d is past the last page of %s, %d\n", pageNumber, input, len(book.Pages))
+		os.Exit(1)
+	}
+	page := book.Pages[pageNumber-1]
+	data, err := page.ReadAll()
+	if err != nil {
+		fmt.Printf("Could not read page %d of %s: %v\n", pageNumber, input, err)
+		os.Exit(1)
+	}
+	h := hashImageData(data)
+	if h.Err != nil {
+		fmt.Printf("Could not decode page %d of %s: %v\n", pageNumber, input, h.Err)
+		os.Exit(1)
+	}
+	if h.Uniform {
+		fmt.Printf("Page %d of %s is blank, it can't be told apart from other blank pages\n", pageNumber, input)
+		os.Exit(1)
+	}
+
+	source := fmt.Sprintf("%s page %d (%s)", filepath.Base(input), pageNumber, page.Name)
+	if strings.TrimSpace(*label) == "" {
+		*label = source
+	}
+	entry := blocklistEntry{Label: strings.TrimSpace(*label), DHash: formatHash(h.DHash), PHash: formatHash(h.PHash), Source: source, Added: time.Now().UTC().Truncate(time.Second)}
+	if existing, ok := list.match(h, defaultDuplicateThreshold); ok {
+		printIfVerbose(fmt.Sprintf("The page already matches %q", existing.Label), runVerbose)
+	}
+	added := list.add(entry)
+	if err := list.write(listPath); err != nil {
+		fmt.Printf("Could not write the blocklist: %v\n", err)
+		os.Exit(1)
+	}
+	if added {
+		printIfNotSilent(fmt.Sprintf("Added %q to %s", entry.Label, listPath), runSilent, runVerbose)
+	} else {
+		printIfNotSilent(fmt.Sprintf("The page was already in %s, relabeled it %q", listPath, entry.Label), runSilent, runVerbose)
+	}
+}
+
+func cmdBlocklistList(args []string) {
+	cfg := loadConfigOrExit()
+	listFlags := flag.NewFlagSet("blocklist list", flag.ExitOnError)
+	path := listFlags.String("blocklist", cfg.get("blocklist", defaultBlocklistPath()), "The blocklist file")
+
+	listFlags.Parse(args)
+
+	listPath := blocklistPathOrExit(*path)
+	list, err := readBlocklist(listPath)
+	if err != nil {
+		fmt.Printf("Could not read the blocklist: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %d pages\n", listPath, len(list.Entries))
+	for _, entry := range list.Entries {
+		fmt.Printf("  %s  %s  %s\n", entry.DHash, entry.PHash, entry.Label)
+	}
+}
+
+func cmdBlocklistPrune(args []string) {
+	cfg := loadConfigOrExit()
+	pruneFlags := flag.NewFlagSet("blocklist prune", flag.ExitOnError)
+	path := pruneFlags.String("blocklist", cfg.get("blocklist", defaultBlocklistPath()), "The blocklist file")
+	threshold := pruneFlags.Int("threshold", defaultDuplicateThreshold, "Largest number of differing hash bits, out of 64, for a page to match the blocklist")
+	dryRun := pruneFlags.Bool("n", false, "Only list the blocklisted pages, don't rewrite the files")
+	runSilent := pruneFlags.Bool("s", false, "Only print files with blocklisted pages")
+	runVerbose := pruneFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	pruneFlags.Parse(args)
+
+	if pruneFlags.NArg() == 0 {
+		fmt.Printf("cbztools blocklist prune v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools blocklist prune [flags] <file...>")
+		fmt.Println("Flags:")
+		pruneFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	if *threshold < 0 || *threshold > 64 {
+		fmt.Printf("Invalid threshold %d, expected 0 to 64\n", *threshold)
+		os.Exit(1)
+	}
+	listPath := blocklistPathOrExit(*path)
+	list, err := readBlocklist(listPath)
+	if err != nil {
+		fmt.Printf("Could not read the blocklist: %v\n", err)
+		os.Exit(1)
+	}
+	if len(list.Entries) == 0 {
+		fmt.Printf("%s has no pages, add some with cbztools blocklist add\n", listPath)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, input := range pruneFlags.Args() {
+		format := outputFormat(input)
+		if !*dryRun && format == "" {
+			fmt.Printf("Can't rewrite %s, convert it to CBZ first\n", input)
+			failed++
+			continue
+		}
+		book, err := readBook(input)
+		if err != nil {
+			fmt.Printf("Could not read %s: %v\n", input, err)
+			failed++
+			continue
+		}
+		blocked, errs := findBlockedPages(hashPages(book.Pages), list, *threshold)
+		for i, err := range errs {
+			printIfVerbose(fmt.Sprintf("%s: can't hash %s: %v", input, book.describePage(i), err), runVerbose)
+		}
+		if len(blocked) == 0 {
+			book.Close()
+			printIfNotSilent(fmt.Sprintf("%s: no blocklisted pages", input), runSilent, runVerbose)
+			continue
+		}
+		fmt.Printf("%s: %d blocklisted pages\n", input, len(blocked))
+		printBlockedPages(os.Stdout, book, blocked)
+		if *dryRun {
+			book.Close()
+			continue
+		}
+
+		book.dropBlockedPages(blocked, nil)
+		book.Info.PageCount = len(book.Pages)
+		err = rewriteBook(book, input, format)
+		book.Close()
+		if err != nil {
+			fmt.Printf("Could not rewrite %s: %v\n", input, err)
+			failed++
+			continue
+		}
+		printIfNotSilent(fmt.Sprintf("Rewrote %s without them, %d pages left", input, len(book.Pages)), runSilent, runVerbose)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
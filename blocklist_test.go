@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlocklistReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", blocklistName)
+	list, err := readBlocklist(path)
+	if err != nil || len(list.Entries) != 0 {
+		t.Fatalf("Expected an empty blocklist for a missing file, got %+v, %v", list, err)
+	}
+
+	if !list.add(blocklistEntry{Label: "Recruitment", DHash: formatHash(1), PHash: formatHash(2)}) {
+		t.Error("Expected a new entry to be added")
+	}
+	if list.add(blocklistEntry{Label: "Recruitment page", DHash: formatHash(1), PHash: formatHash(2)}) {
+		t.Error("Expected an entry with the same hashes to be relabeled")
+	}
+	list.add(blocklistEntry{Label: "Credits", DHash: formatHash(1 << 40), PHash: formatHash(3)})
+	if err := list.write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := readBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 2 || result.Entries[0].Label != "Recruitment page" || result.Entries[0].DHash != "0000000000000001" {
+		t.Errorf("Expected the entries back, got %+v", result.Entries)
+	}
+
+	os.WriteFile(path, []byte(`{"entries": [{"label": "x", "dhash": "zz", "phash": "00"}]}`), 0o644)
+	if _, err := readBlocklist(path); err == nil {
+		t.Error("Expected an error for an invalid hash")
+	}
+}
+
+func TestBlocklistMatch(t *testing.T) {
+	list := &blocklist{Entries: []blocklistEntry{
+		{Label: "far", DHash: formatHash(0), PHash: formatHash(0)},
+		{Label: "near", DHash: formatHash(0xf0), PHash: formatHash(0xf00)},
+	}}
+
+	testCases := []struct {
+		description string
+		hash        pageHash
+		expected    string
+	}{
+		{"exact", pageHash{DHash: 0xf0, PHash: 0xf00}, "near"},
+		{"closest of two", pageHash{DHash: 0xf1, PHash: 0xf01}, "near"},
+		{"within the threshold", pageHash{DHash: 0x3, PHash: 0x0}, "far"},
+		{"only one hash close", pageHash{DHash: 0xf0, PHash: 0xffff0000}, ""},
+		{"no match", pageHash{DHash: 0xffff, PHash: 0xffff}, ""},
+	}
+
+	for _, tc := range testCases {
+		entry, ok := list.match(tc.hash, defaultDuplicateThreshold)
+		if ok != (tc.expected != "") || entry.Label != tc.expected {
+			t.Errorf("%s: expected %q, got %q (%v)", tc.description, tc.expected, entry.Label, ok)
+		}
+	}
+}
+
+func TestFindBlockedPages(t *testing.T) {
+	junk := testPatternPage(t, "junk.png", 3, 120, 180)
+	data, _ := junk.ReadAll()
+	h := hashImageData(data)
+	list := &blocklist{Entries: []blocklistEntry{{Label: "Recruitment", DHash: formatHash(h.DHash), PHash: formatHash(h.PHash)}}}
+
+	book := &Book{}
+	for i, seeds := range [][]int{{0, 3}, {1, 2}, {3, 0}} {
+		c := &Book{}
+		for j, seed := range seeds {
+			// Other sizes of the junk page still match
+			c.Pages = append(c.Pages, testPatternPage(t, string(rune('a'+j))+".png", seed, 100+i*30, 150+i*45))
+		}
+		book.Append(c, "Ch."+string(rune('1'+i)))
+	}
+
+	hashes := hashPages(book.Pages)
+	blocked, errs := findBlockedPages(hashes, list, defaultDuplicateThreshold)
+	if len(errs) != 0 || len(blocked) != 2 || blocked[0].Page != 1 || blocked[1].Page != 4 {
+		t.Fatalf("Expected pages 2 and 5 to be blocked, got %+v, %v", blocked, errs)
+	}
+	var report strings.Builder
+	printBlockedPages(&report, book, blocked)
+	expected := "  Ch.1:\n    page 2 (b.png) matches \"Recruitment\"\n  Ch.3:\n    page 5 (a.png) matches \"Recruitment\"\n"
+	if report.String() != expected {
+		t.Errorf("Expected the report\n%s\ngot\n%s", expected, report.String())
+	}
+
+	hashes = book.dropBlockedPages(blocked, hashes)
+	if len(book.Pages) != 4 || len(book.Chapters) != 3 || book.Chapters[2].FirstPage != 3 {
+		t.Errorf("Expected 4 pages in 3 chapters, got %d pages in %+v", len(book.Pages), book.Chapters)
+	}
+	// The hashes left are those of the pages left, so they can be used again
+	if blocked, _ := findBlockedPages(hashes, list, defaultDuplicateThreshold); len(hashes) != 4 || len(blocked) != 0 {
+		t.Errorf("Expected 4 hashes, none blocked, got %d and %+v", len(hashes), blocked)
+	}
+	if blocked, _ := findBlockedPages(hashPages(book.Pages), &blocklist{}, defaultDuplicateThreshold); len(blocked) != 0 {
+		t.Errorf("Expected nothing blocked by an empty blocklist, got %+v", blocked)
+	}
+}
+
+func TestFindBlockedPagesBlank(t *testing.T) {
+	blank := testBlankPage(t, "blank.png", 80, 120)
+	data, _ := blank.ReadAll()
+	h := hashImageData(data)
+	if !h.Uniform {
+		t.Fatal("Expected the blank page to be uniform")
+	}
+	// An entry made from a blank page, by hand, still doesn't match blank pages
+	list := &blocklist{Entries: []blocklistEntry{{Label: "Blank", DHash: formatHash(h.DHash), PHash: formatHash(h.PHash)}}}
+	book := &Book{Pages: []Page{blank, testBlankPage(t, "blank2.png", 100, 150)}}
+	if blocked, errs := findBlockedPages(hashPages(book.Pages), list, defaultDuplicateThreshold); len(blocked) != 0 || len(errs) != 0 {
+		t.Errorf("Expected no blocked pages, got %+v, %v", blocked, errs)
+	}
+}
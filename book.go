@@ -0,0 +1,432 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output formats
+const (
+	formatCBZ  = "cbz"
+	formatEPUB = "epub"
+)
+
+// Page is a single image of a book
+type Page struct {
+	Name       string // name of the image in its source, used for the extension
+	Chapter    int    // index into Book.Chapters
+	Type       string // ComicInfo page type, like comicPageFrontCover
+	DoublePage bool   // two facing pages in one image, ComicInfo's DoublePage
+	open       func() (io.ReadCloser, error)
+}
+
+// Chapter marks where a chapter starts in Book.Pages
+type Chapter struct {
+	Title     string `json:"title"`
+	FirstPage int    `json:"first_page"`
+}
+
+// Book is the format-independent model all inputs are read into and all outputs are written from
+type Book struct {
+	Info        ComicInfo
+	InfoSource  string   // where Info was read from, like metaSourceComicInfo
+	InfoSidecar bool     // Info was read from a file next to the book, not from the book itself
+	IDs         []bookID // database IDs, from MetronInfo.xml
+	// ExtraMetadata are the metadata formats writers add alongside ComicInfo.xml, like extraComicBookInfo.
+	// Archives that have them are read with them set, so rewriting an archive keeps them.
+	ExtraMetadata []string
+	Comment       string // a zip comment that isn't ComicBookInfo, kept by the CBZ writer
+	Pages         []Page
+	// Files are the other entries of the archive the book was read from, like a scanlator's credits.txt. The archive
+	// writers keep them under their names.
+	Files    []Page
+	Chapters []Chapter
+	closers  []io.Closer
+}
+
+// bookWriters maps output formats to their writers
+var bookWriters = map[string]func(book *Book, path string) error{
+	formatCBZ:  writeCBZ,
+	formatCBT:  writeCBT,
+	formatEPUB: writeEPUB,
+	formatPDF:  writePDF,
+}
+
+// bookReaders maps input file extensions to their readers
+var bookReaders = map[string]func(path string) (*Book, error){
+	".cbz":  readCBZ,
+	".cbr":  readCBR,
+	".cb7":  readCB7,
+	".cbt":  readCBT,
+	".pdf":  readPDF,
+	".epub": readEPUB,
+}
+
+// Ext returns the lowercase extension of the page image, like ".jpg"
+func (p Page) Ext() string {
+	return strings.ToLower(filepath.Ext(p.Name))
+}
+
+// Open opens the page image for reading
+func (p Page) Open() (io.ReadCloser, error) {
+	return p.open()
+}
+
+// ReadAll reads the whole page image
+func (p Page) ReadAll() ([]byte, error) {
+	rc, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// DecodeConfig returns the dimensions and format of the page image without decoding all of it
+func (p Page) DecodeConfig() (image.Config, string, error) {
+	rc, err := p.open()
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer rc.Close()
+	return image.DecodeConfig(rc)
+}
+
+// withData returns the page with its image replaced, keeping its chapter and ComicInfo attributes
+func (p Page) withData(name string, data []byte) Page {
+	p.Name, p.open = name, newBytesPage(name, data).open
+	return p
+}
+
+// newBytesPage creates a page backed by an in-memory image
+func newBytesPage(name string, data []byte) Page {
+	return Page{
+		Name: name,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}
+}
+
+// Close releases the files the book's pages are read from
+func (b *Book) Close() error {
+	var firstErr error
+	for _, c := range b.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	b.closers = nil
+	return firstErr
+}
+
+// Append adds all pages of other to the book as a new chapter with the given title.
+// The book takes over closing other's files.
+func (b *Book) Append(other *Book, title string) {
+	chapter := len(b.Chapters)
+	b.Chapters = append(b.Chapters, Chapter{Title: title, FirstPage: len(b.Pages)})
+	for _, page := range other.Pages {
+		page.Chapter = chapter
+		b.Pages = append(b.Pages, page)
+	}
+	b.closers = append(b.closers, other.closers...)
+	other.closers = nil
+}
+
+// setChapters sets the chapters of a book read from a single file, like those from a PDF outline.
+// Pages before the first chapter get a chapter titled like the book.
+func (b *Book) setChapters(chapters []Chapter) {
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].FirstPage < chapters[j].FirstPage
+	})
+	if len(chapters) == 0 || chapters[0].FirstPage != 0 {
+		chapters = append([]Chapter{{Title: b.Info.Title}}, chapters...)
+	}
+	b.Chapters = chapters
+	for c, chapter := range chapters {
+		for i := chapter.FirstPage; i < len(b.Pages); i++ {
+			b.Pages[i].Chapter = c
+		}
+	}
+}
+
+// processPages reads every page and calls fn with its data, or the error reading it. Pages are read one at a time,
+// as some archives can only be read that way, and fn runs in parallel, so it must only touch what belongs to page i.
+func processPages(pages []Page, fn func(i int, data []byte, err error)) {
+	type job struct {
+		index int
+		data  []byte
+	}
+	jobs := make(chan job, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				fn(j.index, j.data, nil)
+			}
+		}()
+	}
+	for i, page := range pages {
+		data, err := page.ReadAll()
+		if err != nil {
+			fn(i, nil, err)
+			continue
+		}
+		jobs <- job{i, data}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// removePages removes the pages at the given indexes. Chapters left without pages are dropped.
+func (b *Book) removePages(indexes []int) {
+	remove := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		remove[i] = true
+	}
+	pages := b.Pages[:0]
+	for i, page := range b.Pages {
+		if !remove[i] {
+			pages = append(pages, page)
+		}
+	}
+	b.Pages = pages
+	b.renumberChapters()
+}
+
+// renumberChapters recomputes where chapters start from the chapters of the pages, which must be in chapter order.
+// Chapters left without pages are dropped.
+func (b *Book) renumberChapters() {
+	if len(b.Chapters) == 0 {
+		return
+	}
+	var chapters []Chapter
+	index := make(map[int]int)
+	for i := range b.Pages {
+		c := b.Pages[i].Chapter
+		if _, ok := index[c]; !ok {
+			index[c] = len(chapters)
+			chapter := b.Chapters[c]
+			chapter.FirstPage = i
+			chapters = append(chapters, chapter)
+		}
+		b.Pages[i].Chapter = index[c]
+	}
+	b.Chapters = chapters
+}
+
+// isImageExt reports whether an extension (with the dot, lowercase) is a supported page image
+func isImageExt(ext string) bool {
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif"
+}
+
+// imageMediaType returns the MIME type for a page image extension
+func imageMediaType(ext string) string {
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	}
+	return "application/octet-stream"
+}
+
+// outputFormat returns the format for an output path from its extension, "" if it isn't supported
+func outputFormat(path string) string {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if _, ok := bookWriters[format]; ok {
+		return format
+	}
+	return ""
+}
+
+// isBookInput reports whether a file can be read as a book, judging by its extension
+func isBookInput(path string) bool {
+	_, ok := bookReaders[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// readBook reads an input file with the reader for its extension, or a directory of images
+func readBook(path string) (*Book, error) {
+	var book *Book
+	var err error
+	if info, statErr := os.Stat(path); statErr == nil && info.IsDir() {
+		book, err = readFolder(path)
+	} else {
+		reader, ok := bookReaders[strings.ToLower(filepath.Ext(path))]
+		if !ok {
+			return nil, fmt.Errorf("unsupported input format %q", filepath.Ext(path))
+		}
+		book, err = reader(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Without metadata of its own, a book in a Calibre library folder takes the metadata.opf next to it,
+	// and a chapter in a Mihon series folder the series details.json
+	if book.InfoSource == metaSourceNone {
+		if info, ok := readSidecarOPF(path); ok {
+			book.Info, book.InfoSource, book.InfoSidecar = info, metaSourceOPF, true
+		} else if details, ok := readMihonDetails(filepath.Dir(path)); ok && details.comicInfo() != (ComicInfo{}) {
+			book.Info, book.InfoSource, book.InfoSidecar = details.comicInfo(), metaSourceMihon, true
+		}
+		if len(book.Chapters) == 1 && book.Chapters[0].Title == "" {
+			book.Chapters[0].Title = book.Info.Title
+		}
+	}
+	return book, nil
+}
+
+// writeBook writes the book in the given format
+func writeBook(book *Book, path string, format string) error {
+	writer, ok := bookWriters[format]
+	if !ok {
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+	return writer(book, path)
+}
+
+// rewriteBook writes the book to path, which may be the file its pages are still read from. It is written next to
+// path first, then the book is closed and the file renamed over path. Metadata read from a sidecar isn't written
+// into the file, it stays in the sidecar.
+func rewriteBook(book *Book, path string, format string) error {
+	if book.InfoSidecar {
+		book.Info = ComicInfo{PageCount: book.Info.PageCount}
+		book.InfoSource, book.InfoSidecar = metaSourceNone, false
+	}
+	temp := path + ".tmp"
+	if err := writeBook(book, temp, format); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	book.Close()
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// readCBZ reads a CBZ archive. Images are taken in the order they were added to the zip file (!)
+func readCBZ(path string) (*Book, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	meta := metadataFiles{Comment: r.Comment}
+	var pages, files []Page
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := meta.offer(f.Name, readOpened(f.Open)); err != nil {
+			r.Close()
+			return nil, err
+		}
+		if isImageExt(strings.ToLower(filepath.Ext(f.Name))) {
+			pages = append(pages, Page{Name: f.Name, open: f.Open})
+		} else if !isWrittenMetadata(f.Name) {
+			files = append(files, Page{Name: f.Name, open: f.Open})
+		}
+	}
+	book := newArchiveBook(meta, pages, r)
+	book.Files = files
+	return book, nil
+}
+
+// writeCBZ writes the book as a CBZ archive, with pages named by their index and a ComicInfo.xml
+func writeCBZ(book *Book, path string) (err error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	zw := zip.NewWriter(out)
+	copyEntry := func(name string, page Page) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		rc, err := page.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(w, rc)
+		return err
+	}
+	for i, page := range book.Pages {
+		if err := copyEntry(fmt.Sprintf("%05d%s", i+1, page.Ext()), page); err != nil {
+			return err
+		}
+	}
+	for _, file := range book.Files {
+		if err := copyEntry(file.Name, file); err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+
+	xmlBytes, err := comicInfoXML(book)
+	if err != nil {
+		return err
+	}
+	w, err := zw.Create("ComicInfo.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(xmlBytes); err != nil {
+		return err
+	}
+
+	if book.writesMetadata(extraMetronInfo) {
+		xmlBytes, err := metronInfoXML(book)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create("MetronInfo.xml")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(xmlBytes); err != nil {
+			return err
+		}
+	}
+	if book.writesMetadata(extraComicBookInfo) {
+		comment, err := comicBookInfoJSON(book.Info, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := zw.SetComment(string(comment)); err != nil {
+			return err
+		}
+	} else if book.Comment != "" {
+		if err := zw.SetComment(book.Comment); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
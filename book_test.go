@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Helper function to create an in-memory PNG page of the given size
+func testPNGPage(t *testing.T, name string, width int, height int) Page {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{255, 0, 0, 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return newBytesPage(name, buf.Bytes())
+}
+
+// Helper function to create a book with the given number of pages per chapter
+func testBook(t *testing.T, info ComicInfo, chapterPages ...int) *Book {
+	book := &Book{Info: info}
+	for i, pages := range chapterPages {
+		chapter := &Book{}
+		for j := 0; j < pages; j++ {
+			chapter.Pages = append(chapter.Pages, testPNGPage(t, "page.png", 40+i, 60+j))
+		}
+		book.Append(chapter, "")
+	}
+	return book
+}
+
+func TestBookAppend(t *testing.T) {
+	book := testBook(t, ComicInfo{}, 2, 3, 1)
+
+	if len(book.Pages) != 6 || len(book.Chapters) != 3 {
+		t.Fatalf("Expected 6 pages in 3 chapters, got %d pages in %d chapters", len(book.Pages), len(book.Chapters))
+	}
+	expectedFirstPages := []int{0, 2, 5}
+	for i, chapter := range book.Chapters {
+		if chapter.FirstPage != expectedFirstPages[i] {
+			t.Errorf("Expected chapter %d to start at page %d, got %d", i, expectedFirstPages[i], chapter.FirstPage)
+		}
+	}
+	expectedChapters := []int{0, 0, 1, 1, 1, 2}
+	for i, page := range book.Pages {
+		if page.Chapter != expectedChapters[i] {
+			t.Errorf("Expected page %d to be in chapter %d, got %d", i, expectedChapters[i], page.Chapter)
+		}
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"out.cbz", formatCBZ},
+		{"dir/Out.EPUB", formatEPUB},
+		{"out.zip", ""},
+		{"out", ""},
+	}
+
+	for _, tc := range testCases {
+		if result := outputFormat(tc.path); result != tc.expected {
+			t.Errorf("Expected format '%s' for '%s', got '%s'", tc.expected, tc.path, result)
+		}
+	}
+}
+
+func TestCBZRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.cbz")
+	book := testBook(t, ComicInfo{Title: "Title", Series: "Series", Number: "3", PageCount: 3}, 3)
+	if err := writeCBZ(book, path); err != nil {
+		t.Fatalf("Failed to write CBZ: %v", err)
+	}
+
+	read, err := readCBZ(path)
+	if err != nil {
+		t.Fatalf("Failed to read CBZ: %v", err)
+	}
+	defer read.Close()
+
+	if read.Info.Title != "Title" || read.Info.Series != "Series" || read.Info.Number != "3" || read.Info.PageCount != 3 {
+		t.Errorf("ComicInfo was not preserved, got %+v", read.Info)
+	}
+	if len(read.Pages) != 3 {
+		t.Fatalf("Expected 3 pages, got %d", len(read.Pages))
+	}
+	for i, page := range read.Pages {
+		config, format, err := page.DecodeConfig()
+		if err != nil || format != "png" || config.Height != 60+i {
+			t.Errorf("Page %d (%s) was not preserved: %+v, %s, %v", i, page.Name, config, format, err)
+		}
+	}
+}
+
+func TestRewriteBook(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.cbz")
+	if err := writeCBZ(testBook(t, ComicInfo{}, 3), path); err != nil {
+		t.Fatal(err)
+	}
+	book, err := readCBZ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+
+	// The pages are still read from the file being replaced
+	book.removePages([]int{0})
+	if err := rewriteBook(book, path, formatCBZ); err != nil {
+		t.Fatalf("Failed to rewrite the book: %v", err)
+	}
+	read, err := readCBZ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer read.Close()
+	if len(read.Pages) != 2 {
+		t.Errorf("Expected 2 pages, got %d", len(read.Pages))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the book to be left, got %d files", len(entries))
+	}
+}
+
+// writeTestZip writes a zip with the named entries in order, with the given contents or, for those without, a PNG
+func writeTestZip(t *testing.T, path string, comment string, names []string, contents map[string]string) {
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := []byte(contents[name]), contents[name] != ""
+		if !ok {
+			data, _ = testPNGPage(t, name, 40, 60).ReadAll()
+		}
+		w.Write(data)
+	}
+	zw.SetComment(comment)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewriteBookKeepsEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	writeTestZip(t, path, "Not ComicBookInfo", []string{"a.png", "b.png", "credits.txt", "MetronInfo.xml", "ComicInfo.xml"}, map[string]string{
+		"credits.txt":    "Scanned by someone",
+		"MetronInfo.xml": `<MetronInfo><Series><Name>Series</Name></Series></MetronInfo>`,
+		"ComicInfo.xml": `<ComicInfo><Series>Series</Series><AlternateSeries>Crossover</AlternateSeries><Format>TBP</Format>` +
+			`<SeriesGroup>Group</SeriesGroup><CommunityRating>4.5</CommunityRating><Review>Good</Review></ComicInfo>`,
+	})
+
+	book, err := readCBZ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	book.removePages([]int{0})
+	if err := rewriteBook(book, path, formatCBZ); err != nil {
+		t.Fatalf("Failed to rewrite the book: %v", err)
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != "00001.png credits.txt ComicInfo.xml MetronInfo.xml" || r.Comment != "Not ComicBookInfo" {
+		t.Errorf("Expected the other files and the comment to be kept, got %v and %q", names, r.Comment)
+	}
+	read, err := readCBZ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer read.Close()
+	info := read.Info
+	if info.AlternateSeries != "Crossover" || info.Format != "TBP" || info.SeriesGroup != "Group" || info.CommunityRating != "4.5" ||
+		info.Review != "Good" {
+		t.Errorf("Expected the ComicInfo fields to be kept, got %+v", info)
+	}
+}
+
+func TestRewriteBookSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.cbz")
+	writeTestZip(t, path, "", []string{"a.png", "b.png"}, nil)
+	opf := `<package xmlns="http://www.idpf.org/2007/opf"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<dc:title>From Calibre</dc:title></metadata></package>`
+	if err := os.WriteFile(filepath.Join(dir, calibreOPFName), []byte(opf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := readBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	if book.Info.Title != "From Calibre" || !book.InfoSidecar {
+		t.Fatalf("Expected the metadata.opf to be read, got %+v", book.Info)
+	}
+	if err := rewriteBook(book, path, formatCBZ); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readCBZ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer read.Close()
+	if read.Info.Title != "" {
+		t.Errorf("Expected the sidecar's metadata to stay out of the file, got %q", read.Info.Title)
+	}
+}
+
+func TestRemovePages(t *testing.T) {
+	testCases := []struct {
+		description        string
+		remove             []int
+		expectedFirstPages []int
+	}{
+		{"nothing", nil, []int{0, 2, 5}},
+		{"from the middle chapter", []int{2, 4}, []int{0, 2, 3}},
+		{"a whole chapter", []int{0, 1}, []int{0, 3}},
+		{"the last page", []int{5}, []int{0, 2}},
+	}
+
+	for _, tc := range testCases {
+		book := testBook(t, ComicInfo{}, 2, 3, 1)
+		book.removePages(tc.remove)
+		if len(book.Pages) != 6-len(tc.remove) || len(book.Chapters) != len(tc.expectedFirstPages) {
+			t.Errorf("%s: expected %d pages in %d chapters, got %d in %d", tc.description,
+				6-len(tc.remove), len(tc.expectedFirstPages), len(book.Pages), len(book.Chapters))
+			continue
+		}
+		for i, chapter := range book.Chapters {
+			if chapter.FirstPage != tc.expectedFirstPages[i] {
+				t.Errorf("%s: expected chapter %d to start at page %d, got %d", tc.description, i, tc.expectedFirstPages[i], chapter.FirstPage)
+			}
+		}
+		for i, page := range book.Pages {
+			if book.Chapters[page.Chapter].FirstPage > i {
+				t.Errorf("%s: page %d is before the start of its chapter %d", tc.description, i, page.Chapter)
+			}
+		}
+	}
+}
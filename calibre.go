@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Names of the files Calibre keeps next to every book in its library
+const (
+	calibreOPFName   = "metadata.opf"
+	calibreCoverName = "cover.jpg"
+)
+
+// parseOPF parses a Calibre metadata.opf, or any other OPF package document, into ComicInfo
+func parseOPF(data []byte) (ComicInfo, error) {
+	var pkg opfPackage
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return ComicInfo{}, err
+	}
+	return pkg.Metadata.comicInfo(), nil
+}
+
+// readSidecarOPF reads the metadata.opf next to a file, like Calibre keeps it in its library folders
+func readSidecarOPF(path string) (ComicInfo, bool) {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(path), calibreOPFName))
+	if err != nil {
+		return ComicInfo{}, false
+	}
+	info, err := parseOPF(data)
+	if err != nil || info == (ComicInfo{}) {
+		return ComicInfo{}, false
+	}
+	return info, true
+}
+
+// calibreOPFTemplate is an OPF 2.0 package document the way Calibre writes metadata.opf
+var calibreOPFTemplate = template.Must(template.New("calibre").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:identifier opf:scheme="uuid" id="uuid_id">{{x .Identifier}}</dc:identifier>
+    <dc:title>{{x .Title}}</dc:title>
+{{- range .Creators}}
+    <dc:creator opf:role="{{.Role}}">{{x .Name}}</dc:creator>
+{{- end}}
+{{- with .Publisher}}
+    <dc:publisher>{{x .}}</dc:publisher>
+{{- end}}
+{{- with .Description}}
+    <dc:description>{{x .}}</dc:description>
+{{- end}}
+{{- with .Date}}
+    <dc:date>{{x .}}</dc:date>
+{{- end}}
+    <dc:language>{{x .Language}}</dc:language>
+{{- range .Subjects}}
+    <dc:subject>{{x .}}</dc:subject>
+{{- end}}
+{{- with .Series}}
+    <meta name="calibre:series" content="{{x .}}"/>
+{{- with $.SeriesPosition}}
+    <meta name="calibre:series_index" content="{{x .}}"/>
+{{- end}}
+{{- end}}
+  </metadata>
+  <guide>
+    <reference type="cover" title="Cover" href="` + calibreCoverName + `"/>
+  </guide>
+</package>
+`))
+
+// calibreOPF returns the metadata.opf for the book, the counterpart of parseOPF
+func calibreOPF(book *Book) ([]byte, error) {
+	data := newEPUBMetadata(book.Info, len(book.Pages))
+	// Calibre identifies books by a bare UUID
+	data.Identifier = strings.TrimPrefix(data.Identifier, "urn:uuid:")
+	var buf bytes.Buffer
+	if err := calibreOPFTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCalibreFiles writes metadata.opf and, from the front cover, cover.jpg into dir
+func writeCalibreFiles(book *Book, dir string) error {
+	opf, err := calibreOPF(book)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, calibreOPFName), opf, 0o644); err != nil {
+		return err
+	}
+	if len(book.Pages) == 0 {
+		return nil
+	}
+	page, err := book.frontCover()
+	if err != nil {
+		return err
+	}
+	cover, err := coverImage(page, 0)
+	if err != nil {
+		return fmt.Errorf("cover %s: %w", page.Name, err)
+	}
+	return os.WriteFile(filepath.Join(dir, calibreCoverName), cover, 0o644)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCalibreOPF = `<?xml version='1.0' encoding='utf-8'?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:identifier opf:scheme="calibre" id="calibre_id">12</dc:identifier>
+    <dc:title>Title</dc:title>
+    <dc:creator opf:file-as="A" opf:role="aut">A</dc:creator>
+    <dc:creator opf:role="ill">B</dc:creator>
+    <dc:date>2019-12-31T23:00:00+00:00</dc:date>
+    <dc:publisher>Pub</dc:publisher>
+    <dc:language>jpn</dc:language>
+    <dc:subject>Action</dc:subject>
+    <meta name="calibre:series" content="Series"/>
+    <meta name="calibre:series_index" content="3.0"/>
+  </metadata>
+  <guide><reference type="cover" title="Cover" href="cover.jpg"/></guide>
+</package>`
+
+func TestParseOPF(t *testing.T) {
+	info, err := parseOPF([]byte(testCalibreOPF))
+	if err != nil {
+		t.Fatalf("Failed to parse metadata.opf: %v", err)
+	}
+	expected := ComicInfo{Title: "Title", Series: "Series", Volume: "3", Year: 2019, Writer: "A", Penciller: "B",
+		Publisher: "Pub", Genre: "Action", LanguageISO: "jpn"}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+}
+
+func TestCalibreOPFRoundTrip(t *testing.T) {
+	info := ComicInfo{Title: "Title", Series: "Series & Co", Volume: "2", Summary: "Summary", Year: 2020, Writer: "A",
+		Penciller: "B", Colorist: "C", Publisher: "Pub", Genre: "Action, Drama", LanguageISO: "en"}
+	data, err := calibreOPF(&Book{Info: info})
+	if err != nil {
+		t.Fatalf("Failed to write metadata.opf: %v", err)
+	}
+	for _, expected := range []string{`<meta name="calibre:series" content="Series &amp; Co"/>`, `<meta name="calibre:series_index" content="2"/>`,
+		`<dc:creator opf:role="clr">C</dc:creator>`, `href="cover.jpg"`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected metadata.opf to contain %s, got\n%s", expected, data)
+		}
+	}
+
+	result, err := parseOPF(data)
+	if err != nil {
+		t.Fatalf("Failed to parse the written metadata.opf: %v", err)
+	}
+	if result != info {
+		t.Errorf("Expected %+v to round trip, got %+v", info, result)
+	}
+}
+
+func TestReadSidecarOPF(t *testing.T) {
+	page, _ := testPNGPage(t, "001.png", 10, 20).ReadAll()
+	writeCBZ := func(path string, comicInfo string) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, _ := zw.Create("001.png")
+		w.Write(page)
+		if comicInfo != "" {
+			w, _ = zw.Create("ComicInfo.xml")
+			w.Write([]byte(comicInfo))
+		}
+		zw.Close()
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		description string
+		comicInfo   string
+		series      string
+		source      string
+	}{
+		{
+			description: "archive without metadata takes the sidecar",
+			series:      "Series",
+			source:      metaSourceOPF,
+		},
+		{
+			description: "ComicInfo.xml wins over the sidecar",
+			comicInfo:   "<ComicInfo><Series>Own</Series></ComicInfo>",
+			series:      "Own",
+			source:      metaSourceComicInfo,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "book.cbz")
+			writeCBZ(path, tc.comicInfo)
+			if err := os.WriteFile(filepath.Join(dir, calibreOPFName), []byte(testCalibreOPF), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			book, err := readBook(path)
+			if err != nil {
+				t.Fatalf("Failed to read CBZ: %v", err)
+			}
+			defer book.Close()
+			if book.Info.Series != tc.series || book.InfoSource != tc.source {
+				t.Errorf("Expected series %q from %s, got %q from %s", tc.series, tc.source, book.Info.Series, book.InfoSource)
+			}
+		})
+	}
+
+	// Inside a chapter folder, metadata.opf is read like any other metadata file
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "001.png"), page, 0o644)
+	os.WriteFile(filepath.Join(dir, calibreOPFName), []byte(testCalibreOPF), 0o644)
+	book, err := readBook(dir)
+	if err != nil {
+		t.Fatalf("Failed to read folder: %v", err)
+	}
+	defer book.Close()
+	if book.Info.Series != "Series" || book.InfoSource != metaSourceOPF || len(book.Pages) != 1 {
+		t.Errorf("Expected the folder's metadata.opf and one page, got %q from %s with %d pages", book.Info.Series, book.InfoSource, len(book.Pages))
+	}
+}
+
+func TestWriteCalibreFiles(t *testing.T) {
+	dir := t.TempDir()
+	book := testBook(t, ComicInfo{Series: "Series", Volume: "1"}, 2)
+	book.Pages[1].Type = comicPageFrontCover
+	if err := writeCalibreFiles(book, dir); err != nil {
+		t.Fatalf("Failed to write Calibre files: %v", err)
+	}
+
+	info, ok := readSidecarOPF(filepath.Join(dir, "book.cbz"))
+	if !ok || info.Series != "Series" || info.Volume != "1" {
+		t.Errorf("Expected the written metadata.opf to be read back, got %+v", info)
+	}
+	cover, err := os.ReadFile(filepath.Join(dir, calibreCoverName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The PNG page marked FrontCover is converted
+	if config, format, err := image.DecodeConfig(bytes.NewReader(cover)); err != nil || format != "jpeg" || config.Height != 61 {
+		t.Errorf("Expected a JPEG of the second page, got %s %dx%d, %v", format, config.Width, config.Height, err)
+	}
+}
@@ -1,15 +1,12 @@
 package main
 
 import (
-	"archive/zip"
 	"encoding/xml"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -23,12 +20,53 @@ var (
 	GitCommit = "unknown"
 )
 
-// ComicInfo structure for metadata
+// ComicInfo structure for metadata, with the fields in schema order
 type ComicInfo struct {
-	XMLName   xml.Name `xml:"ComicInfo"`
-	Title     string   `xml:"Title"`
-	Series    string   `xml:"Series"`
-	PageCount int      `xml:"PageCount"`
+	XMLName         xml.Name `xml:"ComicInfo" json:"-"`
+	Title           string   `xml:"Title" json:"title"`
+	Series          string   `xml:"Series" json:"series"`
+	Number          string   `xml:"Number,omitempty" json:"number,omitempty"`
+	Count           int      `xml:"Count,omitempty" json:"count,omitempty"`
+	Volume          string   `xml:"Volume,omitempty" json:"volume,omitempty"`
+	AlternateSeries string   `xml:"AlternateSeries,omitempty" json:"alternate_series,omitempty"`
+	AlternateNumber string   `xml:"AlternateNumber,omitempty" json:"alternate_number,omitempty"`
+	AlternateCount  int      `xml:"AlternateCount,omitempty" json:"alternate_count,omitempty"`
+	Summary         string   `xml:"Summary,omitempty" json:"summary,omitempty"`
+	Notes           string   `xml:"Notes,omitempty" json:"notes,omitempty"`
+	Year            int      `xml:"Year,omitempty" json:"year,omitempty"`
+	Month           int      `xml:"Month,omitempty" json:"month,omitempty"`
+	Day             int      `xml:"Day,omitempty" json:"day,omitempty"`
+	Writer          string   `xml:"Writer,omitempty" json:"writer,omitempty"`
+	Penciller       string   `xml:"Penciller,omitempty" json:"penciller,omitempty"`
+	Inker           string   `xml:"Inker,omitempty" json:"inker,omitempty"`
+	Colorist        string   `xml:"Colorist,omitempty" json:"colorist,omitempty"`
+	Letterer        string   `xml:"Letterer,omitempty" json:"letterer,omitempty"`
+	CoverArtist     string   `xml:"CoverArtist,omitempty" json:"cover_artist,omitempty"`
+	Editor          string   `xml:"Editor,omitempty" json:"editor,omitempty"`
+	Translator      string   `xml:"Translator,omitempty" json:"translator,omitempty"`
+	Publisher       string   `xml:"Publisher,omitempty" json:"publisher,omitempty"`
+	Imprint         string   `xml:"Imprint,omitempty" json:"imprint,omitempty"`
+	Genre           string   `xml:"Genre,omitempty" json:"genre,omitempty"`
+	Tags            string   `xml:"Tags,omitempty" json:"tags,omitempty"`
+	Web             string   `xml:"Web,omitempty" json:"web,omitempty"` // space-separated URLs
+	PageCount       int      `xml:"PageCount" json:"page_count"`
+	LanguageISO     string   `xml:"LanguageISO,omitempty" json:"language_iso,omitempty"`
+	Format          string   `xml:"Format,omitempty" json:"format,omitempty"`
+	BlackAndWhite   string   `xml:"BlackAndWhite,omitempty" json:"black_and_white,omitempty"`
+	Manga           string   `xml:"Manga,omitempty" json:"manga,omitempty"`
+	Characters      string   `xml:"Characters,omitempty" json:"characters,omitempty"`
+	Teams           string   `xml:"Teams,omitempty" json:"teams,omitempty"`
+	Locations       string   `xml:"Locations,omitempty" json:"locations,omitempty"`
+	ScanInformation string   `xml:"ScanInformation,omitempty" json:"scan_information,omitempty"`
+	StoryArc        string   `xml:"StoryArc,omitempty" json:"story_arc,omitempty"`
+	StoryArcNumber  string   `xml:"StoryArcNumber,omitempty" json:"story_arc_number,omitempty"`
+	SeriesGroup     string   `xml:"SeriesGroup,omitempty" json:"series_group,omitempty"`
+	AgeRating       string   `xml:"AgeRating,omitempty" json:"age_rating,omitempty"`
+	// After <Pages> in the schema, see comicInfoDocument
+	CommunityRating     string `xml:"CommunityRating,omitempty" json:"community_rating,omitempty"`
+	MainCharacterOrTeam string `xml:"MainCharacterOrTeam,omitempty" json:"main_character_or_team,omitempty"`
+	Review              string `xml:"Review,omitempty" json:"review,omitempty"`
+	GTIN                string `xml:"GTIN,omitempty" json:"gtin,omitempty"`
 }
 
 // Print if silent flag is not set, or if the verbose flag is set (overrides silent flag)
@@ -38,38 +76,23 @@ func printIfNotSilent(msg string, silentFlag *bool, verboseFlag *bool) {
 	}
 }
 
+// flagSet reports whether a flag was given on the command line, rather than left at its default
+func flagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func printIfVerbose(msg string, verboseFlag *bool) {
 	if *verboseFlag {
 		fmt.Println(msg)
 	}
 }
 
-func readXmlFromZip(filepath string) (ComicInfo, error) {
-	result := new(ComicInfo)
-	r, err := zip.OpenReader(filepath)
-	if err != nil {
-		return *result, err
-	}
-	for _, file := range r.File {
-		if strings.Contains(file.Name, ".xml") {
-			rc, _ := file.Open()
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return *result, err
-			}
-			err = xml.Unmarshal(data, result)
-			if err != nil {
-				return *result, err
-			}
-			rc.Close()
-			return *result, nil
-		}
-	}
-	r.Close()
-
-	return *result, fmt.Errorf("no XMLs found in %s", filepath)
-}
-
 // getChapter extracts the chapter string like "0015", "0015.5", "0015.5.5" from a filename.
 // Returns "" if nothing is found.
 func getChapter(name string) string {
@@ -109,6 +132,12 @@ func compareChaptersLess(name1 string, name2 string) bool {
 		return true
 	}
 
+	return compareChapterNumbers(ch1, ch2) < 0
+}
+
+// compareChapterNumbers compares two chapter number strings like "15" and "15.5".
+// Returns -1, 0 or 1, like strings.Compare.
+func compareChapterNumbers(ch1 string, ch2 string) int {
 	// Split into parts (e.g. "15.5.5" -> ["15","5","5"])
 	parts1 := strings.Split(ch1, ".")
 	parts2 := strings.Split(ch2, ".")
@@ -118,12 +147,21 @@ func compareChaptersLess(name1 string, name2 string) bool {
 		n1, _ := strconv.Atoi(parts1[i])
 		n2, _ := strconv.Atoi(parts2[i])
 		if n1 != n2 {
-			return n1 < n2
+			if n1 < n2 {
+				return -1
+			}
+			return 1
 		}
 	}
 
 	// If all compared parts equal, shorter one comes first
-	return len(parts1) < len(parts2)
+	switch {
+	case len(parts1) < len(parts2):
+		return -1
+	case len(parts1) > len(parts2):
+		return 1
+	}
+	return 0
 }
 
 func sanitizeFilename(name string) string {
@@ -150,12 +188,33 @@ func sanitizeFilenameASCII(name string) string {
 
 // cmdConcat handles the concatenation functionality (previously the main function logic)
 func cmdConcat(args []string) {
+	cfg := loadConfigOrExit()
+
 	// Parse flags for concat command
 	concatFlags := flag.NewFlagSet("concat", flag.ExitOnError)
 	showXML := concatFlags.Bool("x", false, "Print resulting XML (in the resulting cbz archive)")
-	printOrder := concatFlags.Bool("r", false, "Print the order of the input cbz files")
+	printOrder := concatFlags.Bool("r", false, "Print the order of the input files")
 	runSilent := concatFlags.Bool("s", false, "Whether to produce any stdout output at all; errors will still be output; overrides other output flags")
 	runVerbose := concatFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+	strict := concatFlags.Bool("strict", false, "Abort if chapters are missing, duplicated or have no chapter number")
+	dedupe := concatFlags.String("dedupe", "", "Keep one of the duplicate chapters: prefer-newest, prefer-largest or prefer-group=<group>")
+	nameTemplate := concatFlags.String("name-template", cfg.get("name-template", defaultNameTemplate), "Go text/template for the output file name, without the extension")
+	sanitize := concatFlags.String("sanitize", cfg.get("sanitize", profileASCII), "Filename sanitize profile: ascii, unicode, windows-safe or posix-min")
+	format := concatFlags.String("format", formatCBZ, "Output format: cbz, cbt, epub or pdf")
+	groupPriority := concatFlags.String("group-priority", "", "Comma-separated scanlation groups, most preferred first, used to choose between duplicate chapters")
+	extraMetadata := concatFlags.String("extra-metadata", cfg.get("extra-metadata", ""), "Comma-separated metadata formats to write alongside ComicInfo.xml: comicbookinfo (in the zip comment), metroninfo (MetronInfo.xml)")
+	onError := concatFlags.String("on-error", cfg.get("on-error", onErrorWarn), "What to do with inputs that can't be read or have no ComicInfo.xml: abort, skip or warn")
+	cover := concatFlags.String("cover", "", "Cover to place first and mark FrontCover: frontcover for the first page marked so in ComicInfo.xml, a page number of the output, or an image file")
+	blocklistPath := concatFlags.String("blocklist", cfg.get("blocklist", defaultBlocklistPath()), "Blocklist of junk pages to remove, see cbztools blocklist; empty to keep every page")
+	blocklistThreshold := concatFlags.Int("blocklist-threshold", defaultDuplicateThreshold, "Largest number of differing hash bits, out of 64, for a page to match the blocklist")
+	dropDuplicatePages := concatFlags.Bool("drop-duplicate-pages", false, "Drop pages that repeat an earlier page, like credit pages repeated in every chapter, and report them")
+	duplicateHash := concatFlags.String("duplicate-hash", hashBoth, "Perceptual hash for --drop-duplicate-pages: both, which must both be close as for the blocklist, dhash or phash")
+	duplicateThreshold := concatFlags.Int("duplicate-threshold", defaultDuplicateThreshold, "Largest number of differing hash bits, out of 64, for two pages to be the same")
+	crop := concatFlags.Bool("crop", false, "Crop uniform white or black borders of portrait pages, see cbztools crop; cropped PNGs and GIFs are written as PNG")
+	cropTolerance := concatFlags.Int("crop-tolerance", defaultCropTolerance, "Largest difference per color channel, out of 255, for a pixel to belong to a border")
+	cropMargin := concatFlags.Int("crop-margin", defaultCropMargin, "Pixels of border to keep around the content when cropping")
+	spreads := concatFlags.String("spreads", "", "What to do with double-page spreads (landscape pages): split into two pages in reading order, rotate to portrait, or keep them marked DoublePage")
+	layout := concatFlags.String("layout", cfg.get("layout", layoutFlat), "Output layout: flat, komga or kavita for a series folder named for media servers, or mihon for a Mihon local source series folder")
 
 	concatFlags.Parse(args)
 
@@ -168,53 +227,189 @@ func cmdConcat(args []string) {
 		os.Exit(1)
 	}
 	inputDir, outputDir := concatFlags.Arg(0), concatFlags.Arg(1)
+	if _, ok := bookWriters[*format]; !ok {
+		fmt.Printf("Unsupported output format: %s\n", *format)
+		os.Exit(1)
+	}
+	policy, err := parseDedupePolicy(*dedupe, *groupPriority)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	profile, err := getSanitizeProfile(*sanitize)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	outputLayout, err := parseLayout(*layout, *format)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var coverSelection coverSpec
+	if *cover != "" {
+		if coverSelection, err = parseCoverSpec(*cover); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if _, err := parsePageHash(*duplicateHash); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *duplicateThreshold < 0 || *duplicateThreshold > 64 {
+		fmt.Printf("Invalid duplicate threshold %d, expected 0 to 64\n", *duplicateThreshold)
+		os.Exit(1)
+	}
+	if *blocklistThreshold < 0 || *blocklistThreshold > 64 {
+		fmt.Printf("Invalid blocklist threshold %d, expected 0 to 64\n", *blocklistThreshold)
+		os.Exit(1)
+	}
+	spreadsPolicy, err := parseSpreadsPolicy(*spreads)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	cropOpts := cropOptions{Tolerance: *cropTolerance, Margin: *cropMargin}
+	if err := checkCropOptions(cropOpts); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	junk := &blocklist{}
+	if *blocklistPath != "" {
+		if junk, err = readBlocklist(*blocklistPath); err != nil {
+			fmt.Printf("Could not read the blocklist: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Layouts name the output for their media server, unless a name template is set
+	if layoutTemplate, ok := layoutNameTemplates[outputLayout]; ok && cfg.get("name-template", "") == "" && !flagSet(concatFlags, "name-template") {
+		*nameTemplate = layoutTemplate
+	}
+	nameTmpl, err := parseNameTemplate(*nameTemplate, profile)
+	if err != nil {
+		fmt.Printf("Invalid name template: %v\n", err)
+		os.Exit(1)
+	}
+	onErrorPolicy, err := parseOnErrorPolicy(*onError)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	extraFormats, err := parseExtraMetadata(*extraMetadata)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	// Find CBZ files
-	var cbzFiles []string
+	// Find the input archives, PDFs and EPUBs
+	var inputFiles []string
 	filepath.Walk(inputDir, func(path string, info os.FileInfo, err error) error {
-		if err == nil && !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".cbz") {
-			cbzFiles = append(cbzFiles, path)
+		if err == nil && !info.IsDir() && isBookInput(info.Name()) {
+			inputFiles = append(inputFiles, path)
 		}
 		return nil
 	})
 
-	if len(cbzFiles) == 0 {
-		fmt.Println("No CBZ files found")
+	if len(inputFiles) == 0 {
+		fmt.Println("No CBZ, CBR, CB7, CBT, PDF or EPUB files found")
 		os.Exit(1)
 	}
 
-	if len(cbzFiles) == 1 {
-		fmt.Println("Only one CBZ file found - no concatenation needed")
+	if len(inputFiles) == 1 {
+		fmt.Println("Only one input file found - no concatenation needed")
 		os.Exit(1)
 	}
 
 	// Print the original order of the files, for debugging
 	if *printOrder || *runVerbose {
 		printIfVerbose("Original order:", runVerbose)
-		for _, name := range cbzFiles {
+		for _, name := range inputFiles {
 			printIfVerbose(name, runVerbose)
 		}
 	}
 
-	// Sort files using the helper functions
-	sort.Slice(cbzFiles, func(i, j int) bool {
-		return compareChaptersLess(cbzFiles[i], cbzFiles[j])
-	})
+	// Open and validate every file before writing anything. Only the metadata is kept, the files are read again when
+	// merging, so they aren't all held open, or in memory for archives read that way, at once.
+	infos := make(map[string]ComicInfo)
+	var problems []inputProblem
+	for _, input := range inputFiles {
+		book, inputProblems := validateInput(input)
+		if book != nil {
+			infos[input] = book.Info
+			printIfVerbose(fmt.Sprintf("Metadata of %s read from %s", input, book.InfoSource), runVerbose)
+			book.Close()
+		}
+		problems = append(problems, inputProblems...)
+	}
+	keptFiles, skippedFiles, ok := applyOnErrorPolicy(inputFiles, problems, onErrorPolicy)
+	if !ok {
+		fmt.Println("Problems found in the input files:")
+		printInputProblems(os.Stdout, problems)
+		fmt.Println("Aborting before writing anything (--on-error=abort)")
+		os.Exit(1)
+	}
+	if len(problems) > 0 && (!*runSilent || *runVerbose) {
+		fmt.Println("Problems found in the input files:")
+		printInputProblems(os.Stdout, problems)
+	}
+	if len(keptFiles) < 2 {
+		fmt.Printf("Only %d of %d input files can be merged - no concatenation possible\n", len(keptFiles), len(inputFiles))
+		os.Exit(1)
+	}
+
+	// Resolve the chapter numbers from ComicInfo and the file names, which are used both for sorting and naming
+	chapters := make([]chapterFile, 0, len(keptFiles))
+	for _, input := range keptFiles {
+		chapter := resolveChapter(input, infos[input])
+		if stat, err := os.Stat(input); err == nil {
+			chapter.Size, chapter.ModTime = stat.Size(), stat.ModTime()
+		}
+		chapters = append(chapters, chapter)
+	}
+	sortChapterFiles(chapters)
+
+	// Check for missing, duplicate and unnumbered chapters before writing anything
+	analysis := analyzeChapters(chapters)
+	if policy != nil && len(analysis.Duplicates) > 0 {
+		var dropped []chapterFile
+		chapters, dropped = dedupeChapters(chapters, analysis.Duplicates, *policy)
+		for _, f := range dropped {
+			printIfNotSilent(fmt.Sprintf("Skipping duplicate chapter %s: %s", f.Chapter, f.Path), runSilent, runVerbose)
+		}
+		analysis = analyzeChapters(chapters)
+	}
+	if analysis.hasIssues() {
+		if *strict {
+			printChapterAnalysis(os.Stdout, analysis)
+			fmt.Println("Aborting because of the issues above (--strict)")
+			os.Exit(1)
+		}
+		if !*runSilent || *runVerbose {
+			printChapterAnalysis(os.Stdout, analysis)
+		}
+	}
+
+	var mergedFiles []string
+	for _, chapter := range chapters {
+		mergedFiles = append(mergedFiles, chapter.Path)
+	}
 
 	// Print the order of the files
 	if *printOrder || *runVerbose {
 		printIfNotSilent("The files will be concatenated in the following order:", runSilent, runVerbose)
-		for _, name := range cbzFiles {
+		for _, name := range mergedFiles {
 			printIfNotSilent(name, runSilent, runVerbose)
 		}
 	}
+	if *runVerbose {
+		fmt.Println("Resolved chapters:")
+		printChapterTable(os.Stdout, chapters)
+	}
 
 	// Get basic book info from the first file, and the last chapter number from the last file
-	firstComicInfo, err := readXmlFromZip(cbzFiles[0])
-	if err != nil {
-		panic(err)
-	}
-	firstXMLBytes, err := xml.MarshalIndent(firstComicInfo, "", "  ")
+	firstChapterFile, lastChapterFile := chapters[0], chapters[len(chapters)-1]
+	firstXMLBytes, err := xml.MarshalIndent(firstChapterFile.Info, "", "  ")
 	if err != nil {
 		panic(err)
 	}
@@ -223,11 +418,7 @@ func cmdConcat(args []string) {
 		fmt.Println(string(firstXMLBytes[:]))
 	}
 
-	lastComicInfo, err := readXmlFromZip(cbzFiles[len(cbzFiles)-1])
-	if err != nil {
-		panic(err)
-	}
-	lastXMLBytes, err := xml.MarshalIndent(lastComicInfo, "", "  ")
+	lastXMLBytes, err := xml.MarshalIndent(lastChapterFile.Info, "", "  ")
 	if err != nil {
 		panic(err)
 	}
@@ -236,62 +427,154 @@ func cmdConcat(args []string) {
 		fmt.Println(string(lastXMLBytes[:]))
 	}
 
-	seriesName := firstComicInfo.Series
-	firstChapter := getChapter(firstComicInfo.Title)
-	lastChapter := getChapter(lastComicInfo.Title)
+	// A Mihon series folder's details.json fills in the series metadata the chapters leave empty
+	details, hasDetails := readMihonDetails(inputDir)
+	if hasDetails {
+		printIfVerbose(fmt.Sprintf("Series details read from %s", filepath.Join(inputDir, mihonDetailsName)), runVerbose)
+	}
+
+	seriesName := firstChapterFile.Info.Series
+	if seriesName == "" && hasDetails {
+		seriesName = details.comicInfo().Series
+	}
+	firstChapter := firstChapterFile.Chapter
+	lastChapter := lastChapterFile.Chapter
 	title := fmt.Sprintf("%s Ch.%s-%s", seriesName, firstChapter, lastChapter)
-	outputFile := filepath.Join(outputDir, fmt.Sprintf("%s.cbz", sanitizeFilenameASCII(title)))
+	info := mergeComicInfo(chapters, title)
+	if hasDetails {
+		fillComicInfo(&info, details.comicInfo())
+	}
 
-	// Create output CBZ
-	out, err := os.Create(outputFile)
+	// The name and the layout's series folder use the series of the merged ComicInfo, which details.json may fill in
+	nameInfo := newNameData(chapters)
+	nameInfo.Series = info.Series
+	outputName, err := renderName(nameTmpl, nameInfo, profile, maxFilenameBytes-len(*format)-1)
 	if err != nil {
-		panic(err)
+		fmt.Printf("Invalid name template: %v\n", err)
+		os.Exit(1)
+	}
+	targetDir, err := layoutDir(outputLayout, outputDir, info.Series, profile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	defer out.Close()
-	outZipFile := zip.NewWriter(out)
-	defer outZipFile.Close()
 
-	// Starting with the first page, for each archive, read it, get all images inside (opened in the order they were added to the zip file (!))
-	// and write them to the `outZipFile` one-by-one, with the filename `pageIndex`
-	pageIndex := 1
-	for _, cbz := range cbzFiles {
-		r, err := zip.OpenReader(cbz)
+	// Read all chapters into a single book, each archive becoming a chapter
+	book := &Book{ExtraMetadata: extraFormats}
+	defer book.Close()
+	for _, chapter := range chapters {
+		input, err := readBook(chapter.Path)
 		if err != nil {
-			panic(err)
+			fmt.Printf("Could not read %s: %v\n", chapter.Path, err)
+			os.Exit(1)
 		}
-		for _, f := range r.File {
-			// Copy only image files
-			ext := strings.ToLower(filepath.Ext(f.Name))
-			if ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif" {
-				rc, _ := f.Open()
-				filename := fmt.Sprintf("%05d%s", pageIndex, ext)
-				pageIndex++
-				w, _ := outZipFile.Create(filename)
-				io.Copy(w, rc)
-				rc.Close()
+		book.Append(input, chapterTitle(chapter))
+	}
+	// The pages are decoded and hashed once for both the blocklist and the duplicates
+	var hashes []pageHash
+	if len(junk.Entries) > 0 || *dropDuplicatePages {
+		hashes = hashPages(book.Pages)
+		for i, h := range hashes {
+			if h.Err != nil {
+				printIfVerbose(fmt.Sprintf("Can't hash %s: %v", book.describePage(i), h.Err), runVerbose)
 			}
 		}
-		r.Close()
 	}
+	if len(junk.Entries) > 0 {
+		blocked, _ := findBlockedPages(hashes, junk, *blocklistThreshold)
+		if len(blocked) > 0 && (!*runSilent || *runVerbose) {
+			fmt.Printf("Removing %d blocklisted pages:\n", len(blocked))
+			printBlockedPages(os.Stdout, book, blocked)
+		}
+		hashes = book.dropBlockedPages(blocked, hashes)
+	}
+	if *dropDuplicatePages {
+		duplicates, _ := findDuplicatePages(hashes, *duplicateHash, *duplicateThreshold)
+		if len(duplicates) > 0 && (!*runSilent || *runVerbose) {
+			fmt.Printf("Dropping %d duplicate pages:\n", len(duplicates))
+			printDuplicatePages(os.Stdout, book, duplicates)
+		}
+		book.dropDuplicatePages(duplicates)
+	}
+	// Cropped after pages are matched against the blocklist, as they were when added to it, and before spreads are
+	// split, so the gutter of the halves isn't taken for a border
+	if *crop {
+		cropped, errs := book.cropBorders(cropOpts)
+		for i, err := range errs {
+			fmt.Printf("Could not crop %s: %v\n", book.describePage(i), err)
+		}
+		for _, c := range cropped {
+			printIfVerbose(fmt.Sprintf("Cropped %s from %dx%d to %dx%d", book.describePage(c.Page), c.From.X, c.From.Y, c.To.X, c.To.Y), runVerbose)
+		}
+		printIfNotSilent(fmt.Sprintf("Cropped the borders of %d of %d pages", len(cropped), len(book.Pages)), runSilent, runVerbose)
+	}
+	book.Info = info
+	// Spreads follow the reading direction of the merged ComicInfo
+	if spreadsPolicy != "" {
+		spreads, errs := book.transformSpreads(spreadsPolicy)
+		for _, err := range errs {
+			fmt.Printf("Could not process spread %v\n", err)
+		}
+		for _, spread := range spreads {
+			printIfVerbose(fmt.Sprintf("Spread: %s", spread), runVerbose)
+		}
+		printIfNotSilent(fmt.Sprintf("Found %d spreads (--spreads=%s)", len(spreads), spreadsPolicy), runSilent, runVerbose)
+	}
+	// Chosen last, so the page numbers are those of the output and removing pages can't take the cover away
+	if *cover != "" {
+		found, err := book.setCover(coverSelection)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !found {
+			printIfNotSilent("No page is marked FrontCover, keeping the first page as the cover", runSilent, runVerbose)
+		}
+	}
+	book.Info.PageCount = len(book.Pages)
 
-	// Add ComicInfo.xml
-	info := ComicInfo{
-		Title:     title,
-		Series:    seriesName,
-		PageCount: pageIndex - 1,
+	if err := os.MkdirAll(targetDir, 0o755); err != nil {
+		fmt.Printf("Could not create %s: %v\n", targetDir, err)
+		os.Exit(1)
 	}
-	xmlBytes, _ := xml.MarshalIndent(info, "", "  ")
+	outputFile := filepath.Join(targetDir, fmt.Sprintf("%s.%s", outputName, *format))
 
+	xmlBytes, _ := xml.MarshalIndent(book.Info, "", "  ")
 	if *showXML || *runVerbose {
 		printIfNotSilent(fmt.Sprintf("Resulting XML written to %s:", outputFile), runSilent, runVerbose)
 		printIfNotSilent(string(xmlBytes[:]), runSilent, runVerbose)
 	}
 
-	w, _ := outZipFile.Create("ComicInfo.xml")
-	w.Write([]byte(xml.Header))
-	w.Write(xmlBytes)
+	if err := writeBook(book, outputFile, *format); err != nil {
+		fmt.Printf("Could not write %s: %v\n", outputFile, err)
+		os.Remove(outputFile)
+		os.Exit(1)
+	}
+
+	written, err := writeLayoutFiles(outputLayout, book, targetDir, details)
+	for _, path := range written {
+		printIfVerbose(fmt.Sprintf("Wrote %s", path), runVerbose)
+	}
+	if err != nil {
+		fmt.Printf("Could not write the series files to %s: %v\n", targetDir, err)
+		os.Exit(1)
+	}
 
-	printIfNotSilent(fmt.Sprintf("Merged %d files into %s with %d pages\n", len(cbzFiles), outputFile, pageIndex-1), runSilent, runVerbose)
+	printIfNotSilent(fmt.Sprintf("Merged %d files into %s with %d pages\n", len(mergedFiles), outputFile, len(book.Pages)), runSilent, runVerbose)
+	if len(skippedFiles) > 0 {
+		skipped := make(map[string]bool)
+		for _, input := range skippedFiles {
+			skipped[input] = true
+		}
+		var reasons []inputProblem
+		for _, p := range problems {
+			if skipped[p.Path] {
+				reasons = append(reasons, p)
+			}
+		}
+		fmt.Printf("Skipped %d of %d input files:\n", len(skippedFiles), len(inputFiles))
+		printInputProblems(os.Stdout, reasons)
+	}
 }
 
 // cmdHelp displays help information
@@ -302,8 +585,17 @@ func cmdHelp(args []string) {
 	fmt.Println("Usage: cbztools <command> [flags] [args]")
 	fmt.Println()
 	fmt.Println("Commands:")
-	fmt.Println("  concat    Concatenate multiple CBZ files into a single archive")
-	fmt.Println("  help      Show this help message")
+	fmt.Println("  concat        Concatenate multiple CBZ files into a single archive")
+	fmt.Println("  convert       Convert between CBZ, CBR, CB7, CBT, folders, EPUB and PDF")
+	fmt.Println("  info          Show what's inside archives, PDFs and EPUBs")
+	fmt.Println("  verify        Check archives for corrupt entries, broken images and invalid ComicInfo.xml")
+	fmt.Println("  cover         Extract the cover of archives, or set which page it is")
+	fmt.Println("  meta          Export metadata to sidecar files, like Calibre's metadata.opf")
+	fmt.Println("  dedupe-pages  Find, and optionally drop, pages that repeat an earlier page")
+	fmt.Println("  crop          Crop the uniform borders of pages")
+	fmt.Println("  blocklist     Register junk pages, like recruitment pages, that concat and prune remove")
+	fmt.Println("  thumb         Make cover thumbnails of a library, named by file hash")
+	fmt.Println("  help          Show this help message")
 	fmt.Println()
 	fmt.Println("For help on a specific command:")
 	fmt.Println("  cbztools <command> -h")
@@ -311,6 +603,15 @@ func cmdHelp(args []string) {
 	fmt.Println("Examples:")
 	fmt.Println("  cbztools concat ./chapters ./output")
 	fmt.Println("  cbztools concat -v -r ./chapters ./output")
+	fmt.Println("  cbztools concat -format epub ./chapters ./output")
+	fmt.Println("  cbztools convert ./volume.cbz ./volume.epub")
+	fmt.Println("  cbztools convert -batch -to cbz ./library ./converted")
+	fmt.Println("  cbztools info --json ./volume.cbz")
+	fmt.Println("  cbztools verify ./library/*.cbz")
+	fmt.Println("  cbztools meta export --opf ./library/Series/volume.cbz")
+	fmt.Println("  cbztools cover -size 300 -o thumb.jpg ./volume.cbz")
+	fmt.Println("  cbztools blocklist add -label \"Recruitment\" ./chapter.cbz 1")
+	fmt.Println("  cbztools thumb -width 200 -height 300 -o ./thumbnails ./library")
 }
 
 func main() {
@@ -351,6 +652,24 @@ func main() {
 	switch subcommand {
 	case "concat":
 		cmdConcat(subcommandArgs)
+	case "convert":
+		cmdConvert(subcommandArgs)
+	case "info":
+		cmdInfo(subcommandArgs)
+	case "verify":
+		cmdVerify(subcommandArgs)
+	case "meta":
+		cmdMeta(subcommandArgs)
+	case "cover":
+		cmdCover(subcommandArgs)
+	case "dedupe-pages":
+		cmdDedupePages(subcommandArgs)
+	case "crop":
+		cmdCrop(subcommandArgs)
+	case "blocklist":
+		cmdBlocklist(subcommandArgs)
+	case "thumb":
+		cmdThumb(subcommandArgs)
 	case "help":
 		cmdHelp(subcommandArgs)
 	default:
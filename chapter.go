@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+// Sources a chapter or volume number can be resolved from, in order of preference
+const (
+	sourceComicInfo = "ComicInfo"
+	sourceTitle     = "Title"
+	sourceFilename  = "filename"
+	sourceNone      = "none"
+)
+
+// chapterNumberRegex matches a plain chapter number like "15", "0015.5" or "15.5.5"
+var chapterNumberRegex = regexp.MustCompile(`^\d+(?:\.\d+)*$`)
+
+// volumeRegex matches "Vol", "Volume" or "V" + optional separator + digits
+var volumeRegex = regexp.MustCompile(`(?i)(?:^|[^a-z])v(?:ol|olume)?[^0-9a-z]{0,2}(\d+(?:\.\d+)?)`)
+
+// mihonGroupRegex matches Mihon/Tachiyomi download names like "Group Name_Chapter 15" or "Group_Vol.1 Ch.15".
+// Names like "Series_Ch.001" have the same shape, see isSeriesName.
+var mihonGroupRegex = regexp.MustCompile(`(?i)^([^_\[\]]+)_(?:vol|ch)`)
+
+// bracketRegex matches a bracketed tag like "[Group Name]"
+var bracketRegex = regexp.MustCompile(`\[([^\[\]]+)\]`)
+
+// bracketTagsNotGroups are bracketed tags commonly found in filenames that are not group names
+var bracketTagsNotGroups = map[string]bool{
+	"end": true, "digital": true, "official": true, "raw": true, "complete": true,
+	"color": true, "colored": true, "colour": true, "oneshot": true, "hq": true,
+}
+
+// chapterFile is an input archive together with its resolved chapter and volume numbers.
+// The same resolved values are used for sorting and for naming the output.
+type chapterFile struct {
+	Path          string
+	Info          ComicInfo
+	Chapter       string
+	ChapterSource string
+	Volume        string
+	VolumeSource  string
+	Group         string
+	GroupSource   string
+	Size          int64
+	ModTime       time.Time
+}
+
+// getVolume extracts the volume string like "1", "01", "1.5" from a title or filename.
+// Returns "" if nothing is found.
+func getVolume(name string) string {
+	matches := volumeRegex.FindStringSubmatch(name)
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
+
+// getGroup extracts the scanlation group from a filename like "Group_Chapter 15.cbz" (Mihon)
+// or "Series Vol.1 Ch.15 [Group].cbz". Returns "" if nothing is found.
+func getGroup(name string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if matches := mihonGroupRegex.FindStringSubmatch(name); len(matches) > 1 {
+		return strings.TrimSpace(matches[1])
+	}
+
+	// Prefer the last bracketed tag, "[Group] Series Ch.15" is handled the same way
+	tags := bracketRegex.FindAllStringSubmatch(name, -1)
+	for i := len(tags) - 1; i >= 0; i-- {
+		tag := strings.TrimSpace(tags[i][1])
+		if tag != "" && !bracketTagsNotGroups[strings.ToLower(tag)] && !chapterNumberRegex.MatchString(tag) {
+			return tag
+		}
+	}
+	return ""
+}
+
+// resolveChapter picks the chapter and volume numbers for an input archive.
+// ComicInfo Number/Volume take precedence, then the ComicInfo Title, then the filename.
+// The scanlation group comes from ComicInfo Translator/ScanInformation, then the filename.
+func resolveChapter(path string, info ComicInfo) chapterFile {
+	result := chapterFile{
+		Path:          path,
+		Info:          info,
+		ChapterSource: sourceNone,
+		VolumeSource:  sourceNone,
+		GroupSource:   sourceNone,
+	}
+	base := filepath.Base(path)
+
+	number := strings.TrimSpace(info.Number)
+	switch {
+	case chapterNumberRegex.MatchString(number):
+		result.Chapter, result.ChapterSource = number, sourceComicInfo
+	case getChapter(info.Title) != "":
+		result.Chapter, result.ChapterSource = getChapter(info.Title), sourceTitle
+	case getChapter(base) != "":
+		result.Chapter, result.ChapterSource = getChapter(base), sourceFilename
+	}
+
+	// Volume -1 is the ComicInfo schema default and means "not set"
+	volume := strings.TrimSpace(info.Volume)
+	switch {
+	case chapterNumberRegex.MatchString(volume):
+		result.Volume, result.VolumeSource = volume, sourceComicInfo
+	case getVolume(info.Title) != "":
+		result.Volume, result.VolumeSource = getVolume(info.Title), sourceTitle
+	case getVolume(base) != "":
+		result.Volume, result.VolumeSource = getVolume(base), sourceFilename
+	}
+
+	switch {
+	case strings.TrimSpace(info.Translator) != "":
+		result.Group, result.GroupSource = strings.TrimSpace(info.Translator), sourceComicInfo
+	case strings.TrimSpace(info.ScanInformation) != "":
+		result.Group, result.GroupSource = strings.TrimSpace(info.ScanInformation), sourceComicInfo
+	case getGroup(base) != "" && !isSeriesName(getGroup(base), path, info):
+		result.Group, result.GroupSource = getGroup(base), sourceFilename
+	}
+
+	return result
+}
+
+// isSeriesName reports whether a group parsed from a file name is the series instead: the ComicInfo Series, or the
+// folder the archive is in, which Mihon names after the series
+func isSeriesName(group string, path string, info ComicInfo) bool {
+	key := nameKey(group)
+	return key != "" && (key == nameKey(info.Series) || key == nameKey(filepath.Base(filepath.Dir(path))))
+}
+
+// nameKey lowercases a name and keeps only its letters and digits, so sanitized names compare equal to the original
+func nameKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// chapterFileLess orders resolved archives by volume, then chapter, then path, so series whose chapters restart every
+// volume stay in order. Archives without a volume, like chapters not collected yet, come after those with one, and
+// archives without a chapter go to the end.
+func chapterFileLess(a chapterFile, b chapterFile) bool {
+	if a.Chapter == "" || b.Chapter == "" {
+		if a.Chapter == b.Chapter {
+			return a.Path < b.Path
+		}
+		return b.Chapter == ""
+	}
+	if a.Volume == "" || b.Volume == "" {
+		if a.Volume != b.Volume {
+			return b.Volume == ""
+		}
+	} else if c := compareChapterNumbers(a.Volume, b.Volume); c != 0 {
+		return c < 0
+	}
+	if c := compareChapterNumbers(a.Chapter, b.Chapter); c != 0 {
+		return c < 0
+	}
+	return a.Path < b.Path
+}
+
+// sortChapterFiles sorts resolved archives in place, see chapterFileLess
+func sortChapterFiles(files []chapterFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return chapterFileLess(files[i], files[j])
+	})
+}
+
+// printChapterTable writes a table of resolved chapter and volume numbers and where they came from
+func printChapterTable(w io.Writer, files []chapterFile) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tCHAPTER\tSOURCE\tVOLUME\tSOURCE\tGROUP\tSOURCE")
+	for _, f := range files {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			filepath.Base(f.Path), orDash(f.Chapter), f.ChapterSource, orDash(f.Volume), f.VolumeSource,
+			orDash(f.Group), f.GroupSource)
+	}
+	tw.Flush()
+}
+
+// chapterGroups returns the distinct scanlation groups of the files, in order of appearance
+func chapterGroups(files []chapterFile) []string {
+	var groups []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if f.Group != "" && !seen[strings.ToLower(f.Group)] {
+			seen[strings.ToLower(f.Group)] = true
+			groups = append(groups, f.Group)
+		}
+	}
+	return groups
+}
+
+// chapterTitle returns a table of contents title for the chapter
+func chapterTitle(f chapterFile) string {
+	switch {
+	case f.Info.Title != "":
+		return f.Info.Title
+	case f.Chapter != "":
+		return "Chapter " + f.Chapter
+	}
+	base := filepath.Base(f.Path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// sharedVolume returns the volume of the chapters if they all have the same one, else ""
+func sharedVolume(chapters []chapterFile) string {
+	if len(chapters) == 0 {
+		return ""
+	}
+	volume := chapters[0].Volume
+	for _, f := range chapters {
+		if f.Volume == "" || compareChapterNumbers(f.Volume, volume) != 0 {
+			return "" // chapters from several volumes
+		}
+	}
+	return volume
+}
+
+// mergeComicInfo builds the ComicInfo of the merged book. Series-wide fields are taken from the first chapter,
+// the volume only if all chapters share it, and the groups of all chapters are recorded in ScanInformation.
+// PageCount is left to the caller.
+func mergeComicInfo(chapters []chapterFile, title string) ComicInfo {
+	first := chapters[0].Info
+	volume := sharedVolume(chapters)
+	return ComicInfo{
+		Title:           title,
+		Series:          first.Series,
+		Count:           first.Count,
+		Volume:          volume,
+		Year:            first.Year,
+		Writer:          first.Writer,
+		Penciller:       first.Penciller,
+		Inker:           first.Inker,
+		Colorist:        first.Colorist,
+		Letterer:        first.Letterer,
+		CoverArtist:     first.CoverArtist,
+		Editor:          first.Editor,
+		Publisher:       first.Publisher,
+		Imprint:         first.Imprint,
+		Genre:           first.Genre,
+		LanguageISO:     first.LanguageISO,
+		Manga:           first.Manga,
+		ScanInformation: strings.Join(chapterGroups(chapters), ", "),
+		AgeRating:       first.AgeRating,
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
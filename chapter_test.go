@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGetVolume(t *testing.T) {
+	testCases := []struct {
+		name           string
+		expectedVolume string
+		description    string
+	}{
+		{"", "", "Empty name should return empty volume"},
+		{"Vol.1 Ch.001", "1", "Basic Vol. prefix"},
+		{"Vol. 01 Ch. 001", "01", "Vol. prefix with space and leading zero"},
+		{"Volume 2 Chapter 10", "2", "Full 'Volume' prefix"},
+		{"My Manga v3 c015", "3", "Abbreviated 'v' prefix"},
+		{"My Manga V.1.5 Ch.001", "1.5", "Decimal volume"},
+		{"My Manga Vol_4 Ch.001", "4", "Underscore separator"},
+		{"My Manga Ch.001", "", "No volume"},
+		{"Love Live 001", "", "'v' inside a word should not match"},
+		{"Vampire 001", "", "Word starting with 'v' should not match"},
+	}
+
+	for _, tc := range testCases {
+		result := getVolume(tc.name)
+		if result != tc.expectedVolume {
+			t.Errorf("Test '%s': Expected volume '%s' from '%s', got '%s'",
+				tc.description, tc.expectedVolume, tc.name, result)
+		}
+	}
+}
+
+func TestGetGroup(t *testing.T) {
+	testCases := []struct {
+		name          string
+		expectedGroup string
+		description   string
+	}{
+		{"", "", "Empty name should return empty group"},
+		{"Asura Scans_Chapter 15.cbz", "Asura Scans", "Mihon download name"},
+		{"Group A & Group B_Vol.1 Ch.15.cbz", "Group A & Group B", "Mihon download name with volume and several groups"},
+		{"Test_Series_Ch_1-4.cbz", "", "Sanitized name is not a Mihon download name"},
+		{"My Manga Vol.1 Ch.15 [Super Scans].cbz", "Super Scans", "Trailing bracketed group"},
+		{"[Super Scans] My Manga Ch.15.cbz", "Super Scans", "Leading bracketed group"},
+		{"My Manga Ch.15 [Super Scans] [END].cbz", "Super Scans", "Non-group tags are skipped"},
+		{"My Manga Ch.15 [Digital].cbz", "", "Only non-group tags"},
+		{"My Manga [2019] Ch.15.cbz", "", "Numeric tags are not groups"},
+		{"My Manga Ch.15.cbz", "", "No group"},
+	}
+
+	for _, tc := range testCases {
+		result := getGroup(tc.name)
+		if result != tc.expectedGroup {
+			t.Errorf("Test '%s': Expected group '%s' from '%s', got '%s'",
+				tc.description, tc.expectedGroup, tc.name, result)
+		}
+	}
+}
+
+func TestResolveChapterGroup(t *testing.T) {
+	testCases := []struct {
+		path                string
+		info                ComicInfo
+		expectedGroup       string
+		expectedGroupSource string
+		description         string
+	}{
+		{"Ch.1 [File Group].cbz", ComicInfo{Translator: "Translator Group", ScanInformation: "Scan Group"}, "Translator Group", sourceComicInfo, "Translator takes precedence"},
+		{"Ch.1 [File Group].cbz", ComicInfo{ScanInformation: " Scan Group "}, "Scan Group", sourceComicInfo, "ScanInformation is used without Translator"},
+		{"Ch.1 [File Group].cbz", ComicInfo{}, "File Group", sourceFilename, "Filename is the last resort"},
+		{"Ch.1.cbz", ComicInfo{}, "", sourceNone, "No group anywhere"},
+		{"in/Berserk_Ch.001.cbz", ComicInfo{Series: "Berserk"}, "", sourceNone, "The series is not a group"},
+		{"Berserk/berserk_Ch.001.cbz", ComicInfo{}, "", sourceNone, "The folder named after the series is not a group"},
+		{"Berserk/Band of the Hawk_Ch.001.cbz", ComicInfo{Series: "Berserk"}, "Band of the Hawk", sourceFilename, "A Mihon group in the series folder"},
+	}
+
+	for _, tc := range testCases {
+		result := resolveChapter(tc.path, tc.info)
+		if result.Group != tc.expectedGroup || result.GroupSource != tc.expectedGroupSource {
+			t.Errorf("Test '%s': Expected group '%s' from %s, got '%s' from %s",
+				tc.description, tc.expectedGroup, tc.expectedGroupSource, result.Group, result.GroupSource)
+		}
+	}
+}
+
+func TestChapterGroups(t *testing.T) {
+	files := []chapterFile{{Group: "Alpha"}, {Group: ""}, {Group: "Beta"}, {Group: "alpha"}, {Group: "Gamma"}}
+	result := strings.Join(chapterGroups(files), ", ")
+	if result != "Alpha, Beta, Gamma" {
+		t.Errorf("Expected 'Alpha, Beta, Gamma', got '%s'", result)
+	}
+}
+
+func TestResolveChapter(t *testing.T) {
+	testCases := []struct {
+		path                  string
+		info                  ComicInfo
+		expectedChapter       string
+		expectedChapterSource string
+		expectedVolume        string
+		expectedVolumeSource  string
+		description           string
+	}{
+		{"in/Manga Ch.0003.cbz", ComicInfo{Number: "5", Volume: "2", Title: "Ch.0004"}, "5", sourceComicInfo, "2", sourceComicInfo, "Number and Volume take precedence"},
+		{"in/Manga Ch.0003.cbz", ComicInfo{Number: " 5.5 ", Title: "Ch.0004"}, "5.5", sourceComicInfo, "", sourceNone, "Number is trimmed"},
+		{"in/Manga Ch.0003.cbz", ComicInfo{Title: "Vol.2 Ch.0004"}, "0004", sourceTitle, "2", sourceTitle, "Title is used without Number"},
+		{"in/Manga Ch.0003.cbz", ComicInfo{Number: "Extra", Title: "Ch.0004"}, "0004", sourceTitle, "", sourceNone, "Non-numeric Number falls back to Title"},
+		{"in/Manga Vol.1 Ch.0003.cbz", ComicInfo{Volume: "-1"}, "0003", sourceFilename, "1", sourceFilename, "Filename is the last resort, -1 volume is unset"},
+		{"in/Manga Ch.0003.cbz", ComicInfo{Title: "Oneshot"}, "0003", sourceFilename, "", sourceNone, "Title without chapter falls back to filename"},
+		{"in/Vol.1 Oneshot.cbz", ComicInfo{}, "", sourceNone, "1", sourceFilename, "No chapter anywhere"},
+		{"Ch.0001/Manga.cbz", ComicInfo{}, "", sourceNone, "", sourceNone, "Only the base name of the path is used"},
+	}
+
+	for _, tc := range testCases {
+		result := resolveChapter(tc.path, tc.info)
+		if result.Chapter != tc.expectedChapter || result.ChapterSource != tc.expectedChapterSource {
+			t.Errorf("Test '%s': Expected chapter '%s' from %s, got '%s' from %s",
+				tc.description, tc.expectedChapter, tc.expectedChapterSource, result.Chapter, result.ChapterSource)
+		}
+		if result.Volume != tc.expectedVolume || result.VolumeSource != tc.expectedVolumeSource {
+			t.Errorf("Test '%s': Expected volume '%s' from %s, got '%s' from %s",
+				tc.description, tc.expectedVolume, tc.expectedVolumeSource, result.Volume, result.VolumeSource)
+		}
+	}
+}
+
+func TestSortChapterFiles(t *testing.T) {
+	files := []chapterFile{
+		resolveChapter("c.cbz", ComicInfo{Title: "Extra"}),
+		resolveChapter("Manga Ch.0010.cbz", ComicInfo{Number: "2"}),
+		resolveChapter("Manga Ch.0001.cbz", ComicInfo{Number: "10"}),
+		resolveChapter("b.cbz", ComicInfo{Title: "Ch.1.5"}),
+		resolveChapter("a.cbz", ComicInfo{}),
+		resolveChapter("Vol.2 x.cbz", ComicInfo{Number: "1"}),
+		resolveChapter("Vol.1 y.cbz", ComicInfo{Number: "1"}),
+		resolveChapter("Vol.1 z.cbz", ComicInfo{Number: "5"}),
+	}
+	sortChapterFiles(files)
+
+	expected := []string{"Vol.1 y.cbz", "Vol.1 z.cbz", "Vol.2 x.cbz", "b.cbz", "Manga Ch.0010.cbz", "Manga Ch.0001.cbz", "a.cbz", "c.cbz"}
+	for i, f := range files {
+		if f.Path != expected[i] {
+			t.Errorf("Position %d: expected %s, got %s", i, expected[i], f.Path)
+		}
+	}
+}
+
+func TestPrintChapterTable(t *testing.T) {
+	var buf bytes.Buffer
+	printChapterTable(&buf, []chapterFile{
+		resolveChapter("dir/Manga Ch.0003.cbz", ComicInfo{Number: "3"}),
+		resolveChapter("dir/Oneshot.cbz", ComicInfo{}),
+	})
+	output := buf.String()
+
+	for _, expected := range []string{"FILE", "Manga Ch.0003.cbz", sourceComicInfo, "Oneshot.cbz", sourceNone} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected table to contain '%s', got:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "dir/") {
+		t.Errorf("Expected table to contain only base names, got:\n%s", output)
+	}
+}
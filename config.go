@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configEnv overrides the location of the config file
+const configEnv = "CBZTOOLS_CONFIG"
+
+// config holds defaults read from the config file, see loadConfig
+type config map[string]string
+
+// configPath returns the config file location, $CBZTOOLS_CONFIG or <user config dir>/cbztools/config
+func configPath() string {
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cbztools", "config")
+}
+
+// loadConfig reads the config file. A missing file is not an error and results in an empty config.
+func loadConfig() (config, error) {
+	path := configPath()
+	if path == "" {
+		return config{}, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return result, nil
+}
+
+// parseConfig parses "key = value" lines. Empty lines and lines starting with "#" are ignored.
+func parseConfig(r io.Reader) (config, error) {
+	result := config{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		result[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return result, scanner.Err()
+}
+
+// get returns the value for key, or fallback if it isn't set
+func (c config) get(key string, fallback string) string {
+	if value, ok := c[key]; ok {
+		return value
+	}
+	return fallback
+}
+
+// loadConfigOrExit is loadConfig for commands, which can't do much about a broken config file
+func loadConfigOrExit() config {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Could not read config: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	input := `
+# comment
+sanitize = unicode
+Name-Template={{.Series}} = {{.FirstChapter}}
+empty =
+`
+	cfg, err := parseConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"sanitize", "unicode"},
+		{"name-template", "{{.Series}} = {{.FirstChapter}}"},
+		{"empty", ""},
+		{"missing", "fallback"},
+	}
+	for _, tc := range testCases {
+		if result := cfg.get(tc.key, "fallback"); result != tc.expected {
+			t.Errorf("Expected '%s' for key '%s', got '%s'", tc.expected, tc.key, result)
+		}
+	}
+
+	if _, err := parseConfig(strings.NewReader("no equals sign")); err == nil {
+		t.Errorf("Expected an error for a line without '='")
+	}
+	if _, err := parseConfig(strings.NewReader(" = value")); err == nil {
+		t.Errorf("Expected an error for a line without a key")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	t.Setenv(configEnv, path)
+
+	cfg, err := loadConfig()
+	if err != nil || len(cfg) != 0 {
+		t.Errorf("Expected an empty config for a missing file, got %v, %v", cfg, err)
+	}
+
+	if err := os.WriteFile(path, []byte("sanitize = posix-min\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = loadConfig()
+	if err != nil || cfg.get("sanitize", "") != "posix-min" {
+		t.Errorf("Expected sanitize = posix-min, got %v, %v", cfg, err)
+	}
+}
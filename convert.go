@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cmdConvert converts a single archive, PDF, EPUB or image folder to another format,
+// or with -batch every supported file in a directory tree
+func cmdConvert(args []string) {
+	convertFlags := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := convertFlags.String("to", "", "Output format: cbz, cbt, epub or pdf; inferred from the output extension if not set")
+	batch := convertFlags.Bool("batch", false, "Convert every supported file under <input_dir> into <output_dir>, keeping the directory structure; requires -to")
+	extraMetadata := convertFlags.String("extra-metadata", "", "Comma-separated metadata formats to write alongside ComicInfo.xml: comicbookinfo (in the zip comment), metroninfo (MetronInfo.xml)")
+	spreads := convertFlags.String("spreads", "", "What to do with double-page spreads (landscape pages): split, rotate or keep, see concat")
+	crop := convertFlags.Bool("crop", false, "Crop uniform white or black borders of portrait pages, see cbztools crop; cropped PNGs and GIFs are written as PNG")
+	cropTolerance := convertFlags.Int("crop-tolerance", defaultCropTolerance, "Largest difference per color channel, out of 255, for a pixel to belong to a border")
+	cropMargin := convertFlags.Int("crop-margin", defaultCropMargin, "Pixels of border to keep around the content when cropping")
+	runSilent := convertFlags.Bool("s", false, "Whether to produce any stdout output at all; errors will still be output; overrides other output flags")
+	runVerbose := convertFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	convertFlags.Parse(args)
+
+	if convertFlags.NArg() != 2 {
+		fmt.Printf("cbztools convert v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools convert [flags] <input> <output>")
+		fmt.Println("       cbztools convert -batch -to <format> [flags] <input_dir> <output_dir>")
+		fmt.Println("Inputs: cbz, cbr, cb7, cbt, epub, pdf or a folder of images")
+		fmt.Println("Flags:")
+		convertFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	input, output := convertFlags.Arg(0), convertFlags.Arg(1)
+
+	format := strings.ToLower(*to)
+	if format == "" && !*batch {
+		format = outputFormat(output)
+	}
+	if _, ok := bookWriters[format]; !ok {
+		if *batch {
+			fmt.Println("Batch conversion needs a supported output format, use -to")
+		} else {
+			fmt.Printf("Cannot determine a supported output format for %s, use -to\n", output)
+		}
+		os.Exit(1)
+	}
+	extraFormats, err := parseExtraMetadata(*extraMetadata)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	spreadsPolicy, err := parseSpreadsPolicy(*spreads)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var cropOpts *cropOptions
+	if *crop {
+		cropOpts = &cropOptions{Tolerance: *cropTolerance, Margin: *cropMargin}
+		if err := checkCropOptions(*cropOpts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *batch {
+		convertBatch(input, output, format, extraFormats, spreadsPolicy, cropOpts, runSilent, runVerbose)
+		return
+	}
+
+	if stat, err := os.Stat(input); err != nil || (!stat.IsDir() && !isBookInput(input)) {
+		fmt.Printf("Unsupported input format: %s\n", input)
+		os.Exit(1)
+	}
+	pages, err := convertBook(input, output, format, extraFormats, spreadsPolicy, cropOpts)
+	if err != nil {
+		fmt.Printf("Could not convert %s: %v\n", input, err)
+		os.Exit(1)
+	}
+	printIfNotSilent(fmt.Sprintf("Converted %s to %s with %d pages", input, output, pages), runSilent, runVerbose)
+}
+
+// convertBook reads the input and writes it in the given format, adding the extra metadata formats, cropping the
+// borders if crop is set and applying the spreads policy if set, returning the number of pages. The output may be the
+// input.
+func convertBook(input string, output string, format string, extraMetadata []string, spreads string, crop *cropOptions) (int, error) {
+	book, err := readBook(input)
+	if err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+	defer book.Close()
+	// Cropped before spreads are split, as in concat
+	if crop != nil {
+		_, errs := book.cropBorders(*crop)
+		for i := range book.Pages {
+			if err := errs[i]; err != nil {
+				return 0, fmt.Errorf("crop %s: %w", book.describePage(i), err)
+			}
+		}
+	}
+	if spreads != "" {
+		if _, errs := book.transformSpreads(spreads); len(errs) > 0 {
+			return 0, fmt.Errorf("spreads: %w", errs[0])
+		}
+	}
+	book.Info.PageCount = len(book.Pages)
+	// The formats the input has are kept
+	for _, format := range extraMetadata {
+		if !book.writesMetadata(format) {
+			book.ExtraMetadata = append(book.ExtraMetadata, format)
+		}
+	}
+
+	// The pages are read from the input until the book is written, so it can't be truncated first
+	if filepath.Clean(output) == filepath.Clean(input) {
+		if err := rewriteBook(book, output, format); err != nil {
+			return 0, fmt.Errorf("write: %w", err)
+		}
+		return len(book.Pages), nil
+	}
+	if err := writeBook(book, output, format); err != nil {
+		os.Remove(output)
+		return 0, fmt.Errorf("write: %w", err)
+	}
+	return len(book.Pages), nil
+}
+
+// convertBatch converts every supported file under inputDir into outputDir, mirroring the directory tree.
+// Failures are reported and skipped, the command exits with an error at the end if there were any.
+func convertBatch(inputDir string, outputDir string, format string, extraMetadata []string, spreads string, crop *cropOptions, runSilent *bool, runVerbose *bool) {
+	var inputs []string
+	err := filepath.Walk(inputDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && isBookInput(info.Name()) {
+			inputs = append(inputs, path)
+		}
+		return nil
+	})
+	if err != nil || len(inputs) == 0 {
+		fmt.Println("No convertible files found")
+		os.Exit(1)
+	}
+
+	converted, failed := 0, 0
+	for _, input := range inputs {
+		rel, _ := filepath.Rel(inputDir, input)
+		output := filepath.Join(outputDir, strings.TrimSuffix(rel, filepath.Ext(rel))+"."+format)
+		if filepath.Clean(output) == filepath.Clean(input) {
+			printIfVerbose(fmt.Sprintf("Skipping %s, it is already %s", input, format), runVerbose)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+			fmt.Printf("Could not create %s: %v\n", filepath.Dir(output), err)
+			os.Exit(1)
+		}
+
+		pages, err := convertBook(input, output, format, extraMetadata, spreads, crop)
+		if err != nil {
+			fmt.Printf("Could not convert %s: %v\n", input, err)
+			failed++
+			continue
+		}
+		converted++
+		printIfNotSilent(fmt.Sprintf("Converted %s to %s with %d pages", input, output, pages), runSilent, runVerbose)
+	}
+
+	printIfNotSilent(fmt.Sprintf("Converted %d of %d files", converted, len(inputs)), runSilent, runVerbose)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertBookInPlace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.cbz")
+	if err := writeCBZ(testBook(t, ComicInfo{Series: "Series"}, 3), path); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := convertBook(path, path, formatCBZ, nil, "", nil)
+	if err != nil || pages != 3 {
+		t.Fatalf("Expected 3 pages converted in place, got %d, %v", pages, err)
+	}
+	book, err := readCBZ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	if len(book.Pages) != 3 || book.Info.Series != "Series" {
+		t.Errorf("Expected the book to survive, got %d pages of %q", len(book.Pages), book.Info.Series)
+	}
+	for i, page := range book.Pages {
+		if _, err := page.ReadAll(); err != nil {
+			t.Errorf("Page %d can't be read: %v", i, err)
+		}
+	}
+
+	// A failed write leaves nothing behind
+	output := filepath.Join(dir, "out.cbz")
+	if _, err := convertBook(path, output, "unknown", nil, "", nil); err == nil {
+		t.Error("Expected an unsupported format to fail")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Expected no partial output, got %v", err)
+	}
+}
+
+func TestConvertBookCrop(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.cbz")
+	var data bytes.Buffer
+	png.Encode(&data, testBorderedImage(200, 300, image.Rect(30, 40, 170, 260), color.White))
+	if err := writeCBZ(&Book{Pages: []Page{newBytesPage("page.png", data.Bytes())}}, path); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out.cbz")
+	if _, err := convertBook(path, output, formatCBZ, nil, "", &cropOptions{Tolerance: defaultCropTolerance}); err != nil {
+		t.Fatal(err)
+	}
+	book, err := readCBZ(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	if width, height := pageSize(t, book.Pages[0]); width != 140 || height != 220 {
+		t.Errorf("Expected the page to be cropped to 140x220, got %dx%d", width, height)
+	}
+}
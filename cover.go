@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// coverFrontCover selects the page marked FrontCover in ComicInfo.xml as the cover
+const coverFrontCover = "frontcover"
+
+// coverSpec is a parsed cover selection: a page number, an image file, or the page marked FrontCover
+type coverSpec struct {
+	Page       int // 1-based
+	File       string
+	FrontCover bool
+}
+
+// parseCoverSpec parses a cover selection: frontcover, a 1-based page number or the path of an image file
+func parseCoverSpec(value string) (coverSpec, error) {
+	if strings.EqualFold(value, coverFrontCover) {
+		return coverSpec{FrontCover: true}, nil
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 1 {
+			return coverSpec{}, fmt.Errorf("invalid cover page %d, pages are counted from 1", n)
+		}
+		return coverSpec{Page: n}, nil
+	}
+	if !isImageExt(strings.ToLower(filepath.Ext(value))) {
+		return coverSpec{}, fmt.Errorf("invalid cover %q, expected %s, a page number or an image file", value, coverFrontCover)
+	}
+	if _, err := os.Stat(value); err != nil {
+		return coverSpec{}, fmt.Errorf("cover image: %w", err)
+	}
+	return coverSpec{File: value}, nil
+}
+
+// setCover moves the selected cover to the front of the book, or adds the image file there, and marks it FrontCover.
+// Other pages marked FrontCover, like those of merged chapters, become InnerCover.
+// If the spec is FrontCover and no page is marked, the first page is marked and found is false.
+func (b *Book) setCover(spec coverSpec) (found bool, err error) {
+	if len(b.Pages) == 0 {
+		return false, fmt.Errorf("the book has no pages")
+	}
+	switch {
+	case spec.File != "":
+		data, err := os.ReadFile(spec.File)
+		if err != nil {
+			return false, err
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return false, fmt.Errorf("cover image %s: %w", spec.File, err)
+		}
+		b.Pages = append([]Page{newBytesPage(filepath.Base(spec.File), data)}, b.Pages...)
+		b.reindexChapters()
+		found = true
+	case spec.Page > 0:
+		if spec.Page > len(b.Pages) {
+			return false, fmt.Errorf("cover page %d is past the last page, %d", spec.Page, len(b.Pages))
+		}
+		b.moveToFront(spec.Page - 1)
+		found = true
+	default:
+		for i, page := range b.Pages {
+			if page.Type == comicPageFrontCover {
+				b.moveToFront(i)
+				found = true
+				break
+			}
+		}
+	}
+
+	for i := range b.Pages {
+		if b.Pages[i].Type == comicPageFrontCover {
+			b.Pages[i].Type = comicPageInnerCover
+		}
+	}
+	b.Pages[0].Type = comicPageFrontCover
+	return found, nil
+}
+
+// frontCover returns the first page marked FrontCover, or the first page if none is
+func (b *Book) frontCover() (Page, error) {
+	if len(b.Pages) == 0 {
+		return Page{}, fmt.Errorf("the book has no pages")
+	}
+	for _, page := range b.Pages {
+		if page.Type == comicPageFrontCover {
+			return page, nil
+		}
+	}
+	return b.Pages[0], nil
+}
+
+// moveToFront moves a page to the front of the book, into the first chapter
+func (b *Book) moveToFront(i int) {
+	page := b.Pages[i]
+	pages := append([]Page{page}, b.Pages[:i]...)
+	b.Pages = append(pages, b.Pages[i+1:]...)
+	b.reindexChapters()
+}
+
+// reindexChapters recomputes where chapters start after pages were moved or added at the front, which belong to
+// the first chapter. Chapters left without pages are dropped.
+func (b *Book) reindexChapters() {
+	if len(b.Chapters) == 0 {
+		return
+	}
+	// The rest of the pages are still in chapter order
+	if len(b.Pages) > 1 {
+		b.Pages[0].Chapter = b.Pages[1].Chapter
+	}
+	b.renumberChapters()
+}
+
+// coverImage returns the page as a JPEG scaled to fit in a size×size square, see scaleToFit.
+// JPEGs that don't need scaling are returned as they are.
+func coverImage(page Page, size int) ([]byte, error) {
+	data, err := page.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	scaled := scaleToFit(img, size, size)
+	if format == "jpeg" && scaled == img {
+		return data, nil
+	}
+	return encodeJPEG(scaled)
+}
+
+func cmdCover(args []string) {
+	coverFlags := flag.NewFlagSet("cover", flag.ExitOnError)
+	pageSpec := coverFlags.String("page", coverFrontCover, "The cover: frontcover for the page marked FrontCover in ComicInfo.xml (else the first page), a page number, or an image file")
+	output := coverFlags.String("o", "", "Where to write the cover, cover.jpg next to the file by default")
+	size := coverFlags.Int("size", 0, "Scale the cover to fit in a square of this many pixels, for a thumbnail; 0 keeps its size")
+	set := coverFlags.Bool("set", false, "Also rewrite the file with the cover as its first page, marked FrontCover")
+	runSilent := coverFlags.Bool("s", false, "Silent mode, only print errors")
+	runVerbose := coverFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	coverFlags.Parse(args)
+
+	if coverFlags.NArg() != 1 {
+		fmt.Printf("cbztools cover v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools cover [flags] <file>")
+		fmt.Println("Flags:")
+		coverFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	input := coverFlags.Arg(0)
+	spec, err := parseCoverSpec(*pageSpec)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *size < 0 {
+		fmt.Printf("Invalid size %d\n", *size)
+		os.Exit(1)
+	}
+	format := outputFormat(input)
+	if *set && format == "" {
+		fmt.Printf("Can't rewrite %s, convert it to CBZ first\n", input)
+		os.Exit(1)
+	}
+	if *output == "" {
+		*output = filepath.Join(filepath.Dir(input), mihonCoverName)
+	}
+
+	book, err := readBook(input)
+	if err != nil {
+		fmt.Printf("Could not read %s: %v\n", input, err)
+		os.Exit(1)
+	}
+	defer book.Close()
+	found, err := book.setCover(spec)
+	if err != nil {
+		fmt.Printf("Could not select the cover of %s: %v\n", input, err)
+		os.Exit(1)
+	}
+	if !found {
+		printIfNotSilent("No page is marked FrontCover, using the first page", runSilent, runVerbose)
+	}
+
+	cover, err := coverImage(book.Pages[0], *size)
+	if err != nil {
+		fmt.Printf("Could not read the cover %s: %v\n", book.Pages[0].Name, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*output, cover, 0o644); err != nil {
+		fmt.Printf("Could not write %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	printIfNotSilent(fmt.Sprintf("Wrote the cover of %s to %s", input, *output), runSilent, runVerbose)
+
+	if *set {
+		if err := rewriteBook(book, input, format); err != nil {
+			fmt.Printf("Could not rewrite %s: %v\n", input, err)
+			os.Exit(1)
+		}
+		printIfNotSilent(fmt.Sprintf("Rewrote %s with the cover first", input), runSilent, runVerbose)
+	}
+}
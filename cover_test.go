@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCoverSpec(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "cover.png")
+	data, _ := testPNGPage(t, "cover.png", 10, 20).ReadAll()
+	os.WriteFile(imagePath, data, 0o644)
+
+	testCases := []struct {
+		value    string
+		expected coverSpec
+		valid    bool
+	}{
+		{"FrontCover", coverSpec{FrontCover: true}, true},
+		{"3", coverSpec{Page: 3}, true},
+		{"0", coverSpec{}, false},
+		{imagePath, coverSpec{File: imagePath}, true},
+		{filepath.Join(dir, "missing.jpg"), coverSpec{}, false},
+		{"first", coverSpec{}, false},
+	}
+
+	for _, tc := range testCases {
+		spec, err := parseCoverSpec(tc.value)
+		if (err == nil) != tc.valid || spec != tc.expected {
+			t.Errorf("Expected %q to parse to %+v (valid: %v), got %+v, %v", tc.value, tc.expected, tc.valid, spec, err)
+		}
+	}
+}
+
+func TestSetCover(t *testing.T) {
+	testCases := []struct {
+		description   string
+		spec          coverSpec
+		marked        int // page marked FrontCover before, -1 for none
+		found         bool
+		cover         int // original index of the page expected first
+		chapterStarts []int
+	}{
+		{
+			description:   "page number",
+			spec:          coverSpec{Page: 4},
+			marked:        -1,
+			found:         true,
+			cover:         3,
+			chapterStarts: []int{0, 3, 4},
+		},
+		{
+			description:   "marked page",
+			spec:          coverSpec{FrontCover: true},
+			marked:        1,
+			found:         true,
+			cover:         1,
+			chapterStarts: []int{0, 2, 4},
+		},
+		{
+			description:   "nothing marked keeps the first page",
+			spec:          coverSpec{FrontCover: true},
+			marked:        -1,
+			cover:         0,
+			chapterStarts: []int{0, 2, 4},
+		},
+		{
+			description:   "only page of a chapter",
+			spec:          coverSpec{Page: 5},
+			marked:        -1,
+			found:         true,
+			cover:         4,
+			chapterStarts: []int{0, 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			book := testBook(t, ComicInfo{}, 2, 2, 1)
+			if tc.marked >= 0 {
+				book.Pages[tc.marked].Type = comicPageFrontCover
+			}
+			// Pages are told apart by their size
+			width, height := pageSize(t, book.Pages[tc.cover])
+
+			found, err := book.setCover(tc.spec)
+			if err != nil || found != tc.found {
+				t.Fatalf("Expected found %v, got %v, %v", tc.found, found, err)
+			}
+			if w, h := pageSize(t, book.Pages[0]); w != width || h != height || book.Pages[0].Type != comicPageFrontCover {
+				t.Errorf("Expected page %d first and marked, got a %dx%d %q page", tc.cover, w, h, book.Pages[0].Type)
+			}
+			if len(book.Pages) != 5 || len(book.Chapters) != len(tc.chapterStarts) {
+				t.Fatalf("Expected 5 pages in %d chapters, got %d in %d", len(tc.chapterStarts), len(book.Pages), len(book.Chapters))
+			}
+			for i, start := range tc.chapterStarts {
+				if book.Chapters[i].FirstPage != start {
+					t.Errorf("Expected chapter %d to start at page %d, got %d", i, start, book.Chapters[i].FirstPage)
+				}
+			}
+		})
+	}
+}
+
+func TestSetCoverFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cover.png")
+	data, _ := testPNGPage(t, "cover.png", 7, 9).ReadAll()
+	os.WriteFile(path, data, 0o644)
+
+	book := testBook(t, ComicInfo{}, 2, 1)
+	book.Pages[2].Type = comicPageFrontCover
+	if _, err := book.setCover(coverSpec{File: path}); err != nil {
+		t.Fatal(err)
+	}
+	if w, h := pageSize(t, book.Pages[0]); w != 7 || h != 9 || len(book.Pages) != 4 || book.Chapters[1].FirstPage != 3 {
+		t.Errorf("Expected the image first, got a %dx%d page of %d, chapters %+v", w, h, len(book.Pages), book.Chapters)
+	}
+	if book.Pages[3].Type != comicPageInnerCover {
+		t.Errorf("Expected the previous FrontCover to become an InnerCover, got %q", book.Pages[3].Type)
+	}
+}
+
+func TestComicInfoPagesRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	book := testBook(t, ComicInfo{Series: "Series", GTIN: "9781234567897"}, 3)
+	book.Pages[0].Type = comicPageFrontCover
+	book.Pages[2].Type = "Advertisement"
+	if err := writeBook(book, path, formatCBZ); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := readBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+	for i, expected := range []string{comicPageFrontCover, "", "Advertisement"} {
+		if result.Pages[i].Type != expected {
+			t.Errorf("Expected page %d to be %q, got %q", i, expected, result.Pages[i].Type)
+		}
+	}
+	// The page list is in its schema place, so the written ComicInfo.xml has no findings
+	report := verifyFile(path, false)
+	if len(report.Findings) > 0 {
+		t.Errorf("Expected no findings, got %+v", report.Findings)
+	}
+}
+
+func TestCoverImage(t *testing.T) {
+	page := testPNGPage(t, "page.png", 100, 200)
+	data, err := coverImage(page, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" || config.Width != 25 || config.Height != 50 {
+		t.Errorf("Expected a 25x50 JPEG, got a %dx%d %s, %v", config.Width, config.Height, format, err)
+	}
+
+	// The page is transparent below its first row, which must come out white rather than black
+	data, err = coverImage(page, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(50, 100).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("Expected transparent areas to be white, got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+}
+
+func pageSize(t *testing.T, page Page) (int, int) {
+	config, _, err := page.DecodeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Width, config.Height
+}
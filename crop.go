@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+)
+
+// Defaults of the crop settings
+const (
+	defaultCropTolerance = 24 // per channel, out of 255, enough for paper texture and JPEG noise
+	defaultCropMargin    = 8  // pixels
+)
+
+// cropNoiseFraction is the share of pixels of a border line that may stray from its color, for dust and specks
+const cropNoiseFraction = 0.005
+
+// cropMinimum is the least share of a side a crop must remove, re-encoding costs quality for little gain otherwise
+const cropMinimum = 0.01
+
+// cropOptions are the settings of the border crop
+type cropOptions struct {
+	Tolerance int // largest difference per channel for a pixel to be border colored
+	Margin    int // pixels of border kept around the content
+}
+
+// pageCrop is a page that was cropped
+type pageCrop struct {
+	Page int // index into Book.Pages
+	From image.Point
+	To   image.Point
+}
+
+// lineColor averages the n pixels of a row or column, starting at x, y and stepping by dx, dy, and reports whether
+// it is uniform: all but a few pixels within tolerance of the average, and the average within tolerance of ref
+// unless ref is nil
+func lineColor(img image.Image, x int, y int, dx int, dy int, n int, tolerance int, ref *[3]int) ([3]int, bool) {
+	pixels := make([][3]int, n)
+	var sum [3]int
+	for i := range pixels {
+		c := color.RGBAModel.Convert(img.At(x+i*dx, y+i*dy)).(color.RGBA)
+		pixels[i] = [3]int{int(c.R), int(c.G), int(c.B)}
+		for ch := range sum {
+			sum[ch] += pixels[i][ch]
+		}
+	}
+	var average [3]int
+	for ch := range sum {
+		average[ch] = sum[ch] / n
+	}
+	if ref != nil && !colorWithin(average, *ref, tolerance) {
+		return average, false
+	}
+	strays := 0
+	for _, p := range pixels {
+		if !colorWithin(p, average, tolerance) {
+			strays++
+		}
+	}
+	return average, float64(strays) <= float64(n)*cropNoiseFraction
+}
+
+// colorWithin reports whether every channel of a and b differs by at most tolerance
+func colorWithin(a [3]int, b [3]int, tolerance int) bool {
+	for ch := range a {
+		d := a[ch] - b[ch]
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// borderDepth counts the uniform lines of one color from an edge inwards. Lines start at x, y and have n pixels
+// stepping by dx, dy; each next line is a step of ix, iy. At most limit lines are counted.
+func borderDepth(img image.Image, x int, y int, dx int, dy int, n int, ix int, iy int, limit int, tolerance int) int {
+	if n <= 0 {
+		return 0
+	}
+	ref, uniform := lineColor(img, x, y, dx, dy, n, tolerance, nil)
+	if !uniform {
+		return 0
+	}
+	depth := 1
+	for depth < limit {
+		if _, uniform := lineColor(img, x+depth*ix, y+depth*iy, dx, dy, n, tolerance, &ref); !uniform {
+			break
+		}
+		depth++
+	}
+	return depth
+}
+
+// contentBounds finds the part of an image inside its uniform borders. Top and bottom are found first, so the sides
+// are only measured between them. Blank images have no content and return an empty rectangle.
+func contentBounds(img image.Image, tolerance int) image.Rectangle {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	top := borderDepth(img, b.Min.X, b.Min.Y, 1, 0, w, 0, 1, h, tolerance)
+	if top == h {
+		return image.Rectangle{}
+	}
+	bottom := borderDepth(img, b.Min.X, b.Max.Y-1, 1, 0, w, 0, -1, h-top, tolerance)
+	rows := h - top - bottom
+	left := borderDepth(img, b.Min.X, b.Min.Y+top, 0, 1, rows, 1, 0, w, tolerance)
+	right := borderDepth(img, b.Max.X-1, b.Min.Y+top, 0, 1, rows, -1, 0, w-left, tolerance)
+	return image.Rect(b.Min.X+left, b.Min.Y+top, b.Max.X-right, b.Max.Y-bottom)
+}
+
+// cropRect returns the rectangle to crop an image to, keeping opts.Margin pixels of border around the content, and
+// false if the image should be left alone: landscape spreads, blank pages and crops too small to be worth it
+func cropRect(img image.Image, opts cropOptions) (image.Rectangle, bool) {
+	b := img.Bounds()
+	if b.Dx() > b.Dy() {
+		return b, false
+	}
+	content := contentBounds(img, opts.Tolerance)
+	if content.Empty() {
+		return b, false
+	}
+	r := image.Rect(content.Min.X-opts.Margin, content.Min.Y-opts.Margin, content.Max.X+opts.Margin, content.Max.Y+opts.Margin).Intersect(b)
+	if float64(b.Dx()-r.Dx()) < float64(b.Dx())*cropMinimum && float64(b.Dy()-r.Dy()) < float64(b.Dy())*cropMinimum {
+		return b, false
+	}
+	return r, true
+}
+
+// cropImage returns the part of an image inside r
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// cropPageData crops the borders of a page image. The page is returned as it is if it's left alone.
+func cropPageData(page Page, data []byte, opts cropOptions) (Page, *pageCrop, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return page, nil, err
+	}
+	r, ok := cropRect(img, opts)
+	if !ok {
+		return page, nil, nil
+	}
+	encoded, name, err := encodePage(cropImage(img, r), page.Name)
+	if err != nil {
+		return page, nil, err
+	}
+	bounds := img.Bounds()
+	return page.withData(name, encoded), &pageCrop{From: bounds.Size(), To: r.Size()}, nil
+}
+
+// cropBorders crops the uniform borders of every page, see cropRect, and returns the cropped pages. Pages that
+// can't be decoded are left as they are, their errors are returned by page index.
+func (b *Book) cropBorders(opts cropOptions) ([]pageCrop, map[int]error) {
+	pages := make([]Page, len(b.Pages))
+	crops := make([]*pageCrop, len(b.Pages))
+	errs := make([]error, len(b.Pages))
+	processPages(b.Pages, func(i int, data []byte, err error) {
+		pages[i] = b.Pages[i]
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		pages[i], crops[i], errs[i] = cropPageData(b.Pages[i], data, opts)
+	})
+	b.Pages = pages
+
+	var cropped []pageCrop
+	failed := make(map[int]error)
+	for i := range pages {
+		if errs[i] != nil {
+			failed[i] = errs[i]
+		}
+		if crops[i] != nil {
+			crop := *crops[i]
+			crop.Page = i
+			cropped = append(cropped, crop)
+		}
+	}
+	return cropped, failed
+}
+
+// checkCropOptions checks the crop flag values
+func checkCropOptions(opts cropOptions) error {
+	if opts.Tolerance < 0 || opts.Tolerance > 255 {
+		return fmt.Errorf("invalid crop tolerance %d, expected 0 to 255", opts.Tolerance)
+	}
+	if opts.Margin < 0 {
+		return fmt.Errorf("invalid crop margin %d", opts.Margin)
+	}
+	return nil
+}
+
+func cmdCrop(args []string) {
+	cropFlags := flag.NewFlagSet("crop", flag.ExitOnError)
+	tolerance := cropFlags.Int("tolerance", defaultCropTolerance, "Largest difference per color channel, out of 255, for a pixel to belong to a border")
+	margin := cropFlags.Int("margin", defaultCropMargin, "Pixels of border to keep around the content")
+	runSilent := cropFlags.Bool("s", false, "Silent mode, only print errors")
+	runVerbose := cropFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	cropFlags.Parse(args)
+
+	if cropFlags.NArg() != 2 {
+		fmt.Printf("cbztools crop v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools crop [flags] <input> <output>")
+		fmt.Println("The output format follows its extension, and the output may be the input itself.")
+		fmt.Println("Cropped pages are re-encoded: JPEGs stay JPEGs, PNGs and GIFs are written as PNG.")
+		fmt.Println("Flags:")
+		cropFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	input, output := cropFlags.Arg(0), cropFlags.Arg(1)
+	opts := cropOptions{Tolerance: *tolerance, Margin: *margin}
+	if err := checkCropOptions(opts); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	format := outputFormat(output)
+	if format == "" {
+		fmt.Printf("Unsupported output format: %s\n", output)
+		os.Exit(1)
+	}
+
+	book, err := readBook(input)
+	if err != nil {
+		fmt.Printf("Could not read %s: %v\n", input, err)
+		os.Exit(1)
+	}
+	defer book.Close()
+	cropped, errs := book.cropBorders(opts)
+	for i, err := range errs {
+		fmt.Printf("Could not crop %s: %v\n", book.describePage(i), err)
+	}
+	for _, c := range cropped {
+		printIfVerbose(fmt.Sprintf("Cropped %s from %dx%d to %dx%d", book.describePage(c.Page), c.From.X, c.From.Y, c.To.X, c.To.Y), runVerbose)
+	}
+
+	// The input may be the output
+	if err := rewriteBook(book, output, format); err != nil {
+		fmt.Printf("Could not write %s: %v\n", output, err)
+		os.Exit(1)
+	}
+	printIfNotSilent(fmt.Sprintf("Cropped %d of %d pages into %s", len(cropped), len(book.Pages), output), runSilent, runVerbose)
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+// testBorderedImage draws content, a gradient, inside a border of the given color
+func testBorderedImage(width int, height int, content image.Rectangle, border color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(border), image.Point{}, draw.Src)
+	for y := content.Min.Y; y < content.Max.Y; y++ {
+		for x := content.Min.X; x < content.Max.X; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 3), uint8(y * 2), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestContentBounds(t *testing.T) {
+	white, black := color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255}
+	testCases := []struct {
+		description string
+		img         func() image.Image
+		expected    image.Rectangle
+	}{
+		{"white border", func() image.Image {
+			return testBorderedImage(100, 150, image.Rect(20, 30, 60, 90), white)
+		}, image.Rect(20, 30, 60, 90)},
+		{"black border", func() image.Image {
+			return testBorderedImage(100, 150, image.Rect(5, 0, 95, 140), black)
+		}, image.Rect(5, 0, 95, 140)},
+		{"full bleed", func() image.Image {
+			return testBorderedImage(100, 150, image.Rect(0, 0, 100, 150), white)
+		}, image.Rect(0, 0, 100, 150)},
+		{"paper texture and a speck", func() image.Image {
+			img := testBorderedImage(400, 600, image.Rect(40, 50, 360, 550), white)
+			for x := 0; x < 400; x += 7 {
+				img.Set(x, 10, color.RGBA{240, 240, 235, 255})
+			}
+			img.Set(200, 20, black)
+			return img
+		}, image.Rect(40, 50, 360, 550)},
+		{"white top and black sides", func() image.Image {
+			img := testBorderedImage(100, 150, image.Rect(10, 20, 90, 150), black)
+			draw.Draw(img, image.Rect(0, 0, 100, 20), image.NewUniform(white), image.Point{}, draw.Src)
+			return img
+		}, image.Rect(10, 20, 90, 150)},
+		{"blank", func() image.Image {
+			return testBorderedImage(100, 150, image.Rectangle{}, white)
+		}, image.Rectangle{}},
+	}
+
+	for _, tc := range testCases {
+		if result := contentBounds(tc.img(), defaultCropTolerance); result != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestCropRect(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	opts := cropOptions{Tolerance: defaultCropTolerance, Margin: 8}
+	testCases := []struct {
+		description string
+		img         image.Image
+		expected    image.Rectangle
+		crop        bool
+	}{
+		{"margin kept", testBorderedImage(200, 300, image.Rect(30, 40, 170, 260), white), image.Rect(22, 32, 178, 268), true},
+		{"margin clamped", testBorderedImage(200, 300, image.Rect(4, 40, 196, 260), white), image.Rect(0, 32, 200, 268), true},
+		{"spread left alone", testBorderedImage(300, 200, image.Rect(30, 40, 270, 160), white), image.Rect(0, 0, 300, 200), false},
+		{"too little to crop", testBorderedImage(1000, 1500, image.Rect(10, 10, 990, 1490), white), image.Rect(0, 0, 1000, 1500), false},
+		{"blank page", testBorderedImage(200, 300, image.Rectangle{}, white), image.Rect(0, 0, 200, 300), false},
+	}
+
+	for _, tc := range testCases {
+		result, crop := cropRect(tc.img, opts)
+		if result != tc.expected || crop != tc.crop {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", tc.description, tc.expected, tc.crop, result, crop)
+		}
+	}
+}
+
+func TestCropBorders(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	bordered := testBorderedImage(200, 300, image.Rect(30, 40, 170, 260), white)
+	var pngData bytes.Buffer
+	png.Encode(&pngData, bordered)
+	jpegData, err := encodeJPEG(bordered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := testBook(t, ComicInfo{}, 1, 1)
+	book.Pages = append(book.Pages, newBytesPage("scan.png", pngData.Bytes()), newBytesPage("scan.jpg", jpegData))
+	book.Pages[2].Chapter, book.Pages[3].Chapter = 1, 1
+	book.Pages[2].Type = comicPageFrontCover
+
+	cropped, errs := book.cropBorders(cropOptions{Tolerance: defaultCropTolerance, Margin: 0})
+	if len(errs) != 0 || len(cropped) != 2 || cropped[0].Page != 2 || cropped[1].Page != 3 {
+		t.Fatalf("Expected pages 3 and 4 to be cropped, got %+v, %v", cropped, errs)
+	}
+	if cropped[0].From != image.Pt(200, 300) || cropped[0].To != image.Pt(140, 220) {
+		t.Errorf("Expected 200x300 to become 140x220, got %v to %v", cropped[0].From, cropped[0].To)
+	}
+	for i, name := range []string{"page.png", "page.png", "scan.png", "scan.jpg"} {
+		if book.Pages[i].Name != name {
+			t.Errorf("Expected page %d to be %s, got %s", i, name, book.Pages[i].Name)
+		}
+	}
+	if book.Pages[2].Chapter != 1 || book.Pages[2].Type != comicPageFrontCover {
+		t.Errorf("Expected the cropped page to keep its chapter and type, got %+v", book.Pages[2])
+	}
+	for i := 2; i < 4; i++ {
+		if width, height := pageSize(t, book.Pages[i]); width > 145 || height > 225 {
+			t.Errorf("Expected page %d to be cropped to about 140x220, got %dx%d", i, width, height)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ComicInfo Manga value for right-to-left reading order
+const mangaRightToLeft = "YesAndRightToLeft"
+
+// epubCreatorRoles maps ComicInfo creator fields to MARC relator codes, in the order they are written
+var epubCreatorRoles = []struct {
+	Role  string
+	Value func(ComicInfo) string
+}{
+	{"aut", func(c ComicInfo) string { return c.Writer }},
+	{"art", func(c ComicInfo) string { return c.Penciller }},
+	{"art", func(c ComicInfo) string { return c.Inker }},
+	{"clr", func(c ComicInfo) string { return c.Colorist }},
+	{"art", func(c ComicInfo) string { return c.Letterer }},
+	{"cov", func(c ComicInfo) string { return c.CoverArtist }},
+	{"edt", func(c ComicInfo) string { return c.Editor }},
+	{"trl", func(c ComicInfo) string { return c.Translator }},
+}
+
+// epubPage is a page as referenced from the EPUB documents
+type epubPage struct {
+	ID        string // manifest id of the XHTML document, the image is "img-" + ID
+	Href      string // XHTML document, relative to the OPF
+	ImageHref string
+	MediaType string
+	Width     int
+	Height    int
+}
+
+// epubNavPoint is a table of contents entry
+type epubNavPoint struct {
+	Title string
+	Href  string
+}
+
+// epubCreator is a dc:creator entry
+type epubCreator struct {
+	Name string
+	Role string
+}
+
+// epubData is everything the EPUB templates need
+type epubData struct {
+	Identifier      string
+	Title           string
+	Language        string
+	Series          string
+	SeriesPosition  string
+	Creators        []epubCreator
+	Publisher       string
+	Description     string
+	Subjects        []string
+	Date            string
+	Modified        string
+	RightToLeft     bool
+	Pages           []epubPage
+	NavPoints       []epubNavPoint
+	FirstPageWidth  int
+	FirstPageHeight int
+}
+
+var epubFuncs = template.FuncMap{
+	"x": xmlEscape,
+	"inc": func(i int) int {
+		return i + 1
+	},
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+var epubOPFTemplate = template.Must(template.New("opf").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">{{x .Identifier}}</dc:identifier>
+    <dc:title>{{x .Title}}</dc:title>
+    <dc:language>{{x .Language}}</dc:language>
+{{- range $i, $c := .Creators}}
+    <dc:creator id="creator{{inc $i}}">{{x $c.Name}}</dc:creator>
+    <meta refines="#creator{{inc $i}}" property="role" scheme="marc:relators">{{$c.Role}}</meta>
+{{- end}}
+{{- with .Publisher}}
+    <dc:publisher>{{x .}}</dc:publisher>
+{{- end}}
+{{- with .Description}}
+    <dc:description>{{x .}}</dc:description>
+{{- end}}
+{{- range .Subjects}}
+    <dc:subject>{{x .}}</dc:subject>
+{{- end}}
+{{- with .Date}}
+    <dc:date>{{x .}}</dc:date>
+{{- end}}
+{{- with .Series}}
+    <meta property="belongs-to-collection" id="series">{{x .}}</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+{{- with $.SeriesPosition}}
+    <meta refines="#series" property="group-position">{{x .}}</meta>
+{{- end}}
+{{- end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">auto</meta>
+    <meta property="rendition:spread">landscape</meta>
+    <meta name="cover" content="img-{{(index .Pages 0).ID}}"/>
+    <meta name="original-resolution" content="{{.FirstPageWidth}}x{{.FirstPageHeight}}"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+{{- range $i, $p := .Pages}}
+    <item id="{{$p.ID}}" href="{{$p.Href}}" media-type="application/xhtml+xml"/>
+    <item id="img-{{$p.ID}}" href="{{$p.ImageHref}}" media-type="{{$p.MediaType}}"{{if eq $i 0}} properties="cover-image"{{end}}/>
+{{- end}}
+  </manifest>
+  <spine toc="ncx"{{if .RightToLeft}} page-progression-direction="rtl"{{end}}>
+{{- range .Pages}}
+    <itemref idref="{{.ID}}"/>
+{{- end}}
+  </spine>
+</package>
+`))
+
+var epubPageTemplate = template.Must(template.New("page").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>{{x .Title}}</title>
+  <meta name="viewport" content="width={{.Page.Width}}, height={{.Page.Height}}"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: {{.Page.Width}}px; height: {{.Page.Height}}px; }</style>
+</head>
+<body>
+  <img src="../{{.Page.ImageHref}}" alt="{{x .Title}}"/>
+</body>
+</html>
+`))
+
+var epubNavTemplate = template.Must(template.New("nav").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>{{x .Title}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol>
+{{- range .NavPoints}}
+      <li><a href="{{.Href}}">{{x .Title}}</a></li>
+{{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var epubNCXTemplate = template.Must(template.New("ncx").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="{{x .Identifier}}"/>
+  </head>
+  <docTitle><text>{{x .Title}}</text></docTitle>
+  <navMap>
+{{- range $i, $n := .NavPoints}}
+    <navPoint id="nav{{inc $i}}" playOrder="{{inc $i}}">
+      <navLabel><text>{{x $n.Title}}</text></navLabel>
+      <content src="{{$n.Href}}"/>
+    </navPoint>
+{{- end}}
+  </navMap>
+</ncx>
+`))
+
+// xmlEscape escapes a string for use in XML text and attribute values
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// bookTitle returns the title of the book, falling back to the series name
+func bookTitle(info ComicInfo) string {
+	switch {
+	case info.Title != "":
+		return info.Title
+	case info.Series != "":
+		return info.Series
+	}
+	return "Untitled"
+}
+
+// splitList splits a comma-separated ComicInfo list like Genre or Writer
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// newEPUBMetadata maps ComicInfo to the package metadata, shared by EPUB and Calibre's metadata.opf
+func newEPUBMetadata(info ComicInfo, pageCount int) epubData {
+	data := epubData{
+		Title:       bookTitle(info),
+		Language:    info.LanguageISO,
+		Series:      info.Series,
+		Publisher:   info.Publisher,
+		Description: info.Summary,
+		Subjects:    splitList(info.Genre),
+		Modified:    time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		RightToLeft: info.Manga == mangaRightToLeft,
+	}
+	if data.Language == "" {
+		data.Language = "und"
+	}
+	if _, err := strconv.Atoi(info.Volume); err == nil && !strings.HasPrefix(info.Volume, "-") {
+		data.SeriesPosition = info.Volume
+	}
+	if info.Year > 0 {
+		data.Date = strconv.Itoa(info.Year)
+	}
+	for _, role := range epubCreatorRoles {
+		for _, name := range splitList(role.Value(info)) {
+			data.Creators = append(data.Creators, epubCreator{Name: name, Role: role.Role})
+		}
+	}
+
+	// A stable identifier, so converting the same book twice gives the same EPUB, as a name-based (version 5) UUID
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%d", info.Series, data.Title, pageCount)
+	sum := hash.Sum(nil)
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	data.Identifier = fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+	return data
+}
+
+// newEPUBData maps the book's ComicInfo and pages to the EPUB metadata.
+// Page dimensions are read from the image headers.
+func newEPUBData(book *Book) (epubData, error) {
+	data := newEPUBMetadata(book.Info, len(book.Pages))
+	for i, page := range book.Pages {
+		config, _, err := page.DecodeConfig()
+		if err != nil {
+			return data, fmt.Errorf("page %d (%s): %w", i+1, page.Name, err)
+		}
+		id := fmt.Sprintf("p%05d", i+1)
+		data.Pages = append(data.Pages, epubPage{
+			ID:        id,
+			Href:      "pages/" + id + ".xhtml",
+			ImageHref: fmt.Sprintf("images/%05d%s", i+1, page.Ext()),
+			MediaType: imageMediaType(page.Ext()),
+			Width:     config.Width,
+			Height:    config.Height,
+		})
+	}
+	if len(data.Pages) > 0 {
+		data.FirstPageWidth, data.FirstPageHeight = data.Pages[0].Width, data.Pages[0].Height
+	}
+
+	for i, chapter := range book.Chapters {
+		if chapter.FirstPage >= len(data.Pages) {
+			continue
+		}
+		title := chapter.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		data.NavPoints = append(data.NavPoints, epubNavPoint{Title: title, Href: data.Pages[chapter.FirstPage].Href})
+	}
+	if len(data.NavPoints) == 0 && len(data.Pages) > 0 {
+		data.NavPoints = []epubNavPoint{{Title: data.Title, Href: data.Pages[0].Href}}
+	}
+
+	return data, nil
+}
+
+// writeEPUB writes the book as a fixed-layout EPUB3, one XHTML document per page
+func writeEPUB(book *Book, path string) (err error) {
+	if len(book.Pages) == 0 {
+		return fmt.Errorf("cannot write an EPUB without pages")
+	}
+	data, err := newEPUBData(book)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	zw := zip.NewWriter(out)
+
+	// The mimetype must be the first entry, stored uncompressed and without a data descriptor
+	mimetype := []byte("application/epub+zip")
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mimetype); err != nil {
+		return err
+	}
+
+	if err := writeZipString(zw, "META-INF/container.xml", epubContainer); err != nil {
+		return err
+	}
+	if err := writeZipTemplate(zw, "OEBPS/content.opf", epubOPFTemplate, data); err != nil {
+		return err
+	}
+	if err := writeZipTemplate(zw, "OEBPS/nav.xhtml", epubNavTemplate, data); err != nil {
+		return err
+	}
+	if err := writeZipTemplate(zw, "OEBPS/toc.ncx", epubNCXTemplate, data); err != nil {
+		return err
+	}
+
+	for i, page := range book.Pages {
+		pageData := struct {
+			Title string
+			Page  epubPage
+		}{fmt.Sprintf("Page %d", i+1), data.Pages[i]}
+		if err := writeZipTemplate(zw, "OEBPS/"+data.Pages[i].Href, epubPageTemplate, pageData); err != nil {
+			return err
+		}
+
+		// Images are already compressed
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + data.Pages[i].ImageHref, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		rc, err := page.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeZipString adds a deflated file with the given content to the zip
+func writeZipString(zw *zip.Writer, name string, content string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, content)
+	return err
+}
+
+// writeZipTemplate adds a deflated file with the executed template to the zip
+func writeZipTemplate(zw *zip.Writer, name string, tmpl *template.Template, data interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
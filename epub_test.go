@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewEPUBData(t *testing.T) {
+	info := ComicInfo{
+		Title:       "Title & More",
+		Series:      "Series",
+		Volume:      "2",
+		Year:        2020,
+		Writer:      "Writer One, Writer Two",
+		Penciller:   "Artist",
+		Genre:       "Action, Comedy",
+		LanguageISO: "ja",
+		Manga:       mangaRightToLeft,
+	}
+	book := testBook(t, info, 2, 1)
+	book.Chapters[0].Title = "First"
+
+	data, err := newEPUBData(book)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if data.Title != "Title & More" || data.Language != "ja" || data.Series != "Series" || data.SeriesPosition != "2" || data.Date != "2020" {
+		t.Errorf("Metadata was not mapped, got %+v", data)
+	}
+	if !data.RightToLeft {
+		t.Errorf("Expected right-to-left for %s", mangaRightToLeft)
+	}
+	expectedCreators := []epubCreator{{"Writer One", "aut"}, {"Writer Two", "aut"}, {"Artist", "art"}}
+	if len(data.Creators) != len(expectedCreators) {
+		t.Fatalf("Expected creators %v, got %v", expectedCreators, data.Creators)
+	}
+	for i, c := range expectedCreators {
+		if data.Creators[i] != c {
+			t.Errorf("Expected creator %v, got %v", c, data.Creators[i])
+		}
+	}
+	if strings.Join(data.Subjects, "|") != "Action|Comedy" {
+		t.Errorf("Expected subjects Action|Comedy, got %v", data.Subjects)
+	}
+	if len(data.Pages) != 3 || data.Pages[2].Width != 41 || data.Pages[2].Height != 60 {
+		t.Errorf("Expected page dimensions from the images, got %+v", data.Pages)
+	}
+	expectedNav := []epubNavPoint{{"First", "pages/p00001.xhtml"}, {"Chapter 2", "pages/p00003.xhtml"}}
+	if len(data.NavPoints) != 2 || data.NavPoints[0] != expectedNav[0] || data.NavPoints[1] != expectedNav[1] {
+		t.Errorf("Expected nav points %v, got %v", expectedNav, data.NavPoints)
+	}
+
+	data, _ = newEPUBData(testBook(t, ComicInfo{Series: "Only Series", Volume: "-1"}, 1))
+	if data.Title != "Only Series" || data.Language != "und" || data.SeriesPosition != "" || data.RightToLeft {
+		t.Errorf("Expected fallbacks for missing metadata, got %+v", data)
+	}
+
+	other, _ := newEPUBData(testBook(t, ComicInfo{Series: "Only Series"}, 1))
+	if other.Identifier != data.Identifier {
+		t.Errorf("Expected a stable identifier, got %s and %s", data.Identifier, other.Identifier)
+	}
+	uuid := regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(data.Identifier) {
+		t.Errorf("Expected a version 5 UUID, got %s", data.Identifier)
+	}
+}
+
+func TestWriteEPUB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.epub")
+	book := testBook(t, ComicInfo{Title: "<Title>", Series: "Series", Manga: mangaRightToLeft}, 2, 2)
+	if err := writeEPUB(book, path); err != nil {
+		t.Fatalf("Failed to write EPUB: %v", err)
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	defer r.Close()
+
+	first := r.File[0]
+	if first.Name != "mimetype" || first.Method != zip.Store || first.Flags&0x8 != 0 {
+		t.Errorf("Expected a stored mimetype entry first without a data descriptor, got %s (method %d, flags %x)", first.Name, first.Method, first.Flags)
+	}
+
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(data)
+	}
+
+	if files["mimetype"] != "application/epub+zip" {
+		t.Errorf("Unexpected mimetype %q", files["mimetype"])
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/toc.ncx", "OEBPS/pages/p00004.xhtml"} {
+		content, ok := files[name]
+		if !ok {
+			t.Errorf("Expected %s in the EPUB", name)
+			continue
+		}
+		decoder := xml.NewDecoder(strings.NewReader(content))
+		decoder.Strict = true
+		for {
+			if _, err := decoder.Token(); err != nil {
+				if err != io.EOF {
+					t.Errorf("%s is not well-formed XML: %v", name, err)
+				}
+				break
+			}
+		}
+	}
+	if _, ok := files["OEBPS/images/00004.png"]; !ok {
+		t.Errorf("Expected page images in the EPUB")
+	}
+
+	opf := files["OEBPS/content.opf"]
+	for _, expected := range []string{"&lt;Title&gt;", `page-progression-direction="rtl"`, `property="rendition:layout">pre-paginated`, `properties="cover-image"`, `property="belongs-to-collection"`} {
+		if !strings.Contains(opf, expected) {
+			t.Errorf("Expected OPF to contain %s", expected)
+		}
+	}
+	if !strings.Contains(files["OEBPS/pages/p00001.xhtml"], `content="width=40, height=60"`) {
+		t.Errorf("Expected the page viewport to match the image size")
+	}
+}
+
+func TestWriteEPUBWithoutPages(t *testing.T) {
+	if err := writeEPUB(&Book{}, filepath.Join(t.TempDir(), "out.epub")); err == nil {
+		t.Errorf("Expected an error for a book without pages")
+	}
+}
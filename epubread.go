@@ -0,0 +1,420 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// opfContainer is META-INF/container.xml, which points at the OPF package document
+type opfContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// opfPackage is the OPF package document, only the parts needed to read a comic
+type opfPackage struct {
+	Metadata opfMetadata `xml:"metadata"`
+	Manifest []opfItem   `xml:"manifest>item"`
+	Spine    struct {
+		Toc       string `xml:"toc,attr"`
+		Direction string `xml:"page-progression-direction,attr"`
+		Items     []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// opfMetadata holds the Dublin Core and meta elements of an OPF, for EPUB 2 and 3 as well as Calibre's metadata.opf
+type opfMetadata struct {
+	Titles      []string     `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Creators    []opfCreator `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Publisher   string       `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+	Description string       `xml:"http://purl.org/dc/elements/1.1/ description"`
+	Subjects    []string     `xml:"http://purl.org/dc/elements/1.1/ subject"`
+	Date        string       `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Language    string       `xml:"http://purl.org/dc/elements/1.1/ language"`
+	Metas       []opfMeta    `xml:"meta"`
+}
+
+type opfCreator struct {
+	ID   string `xml:"id,attr"`
+	Role string `xml:"http://www.idpf.org/2007/opf role,attr"` // EPUB 2, EPUB 3 uses a refining meta
+	Name string `xml:",chardata"`
+}
+
+// opfMeta is either an EPUB 2 <meta name content/> or an EPUB 3 <meta property refines>value</meta>
+type opfMeta struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	ID       string `xml:"id,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+// opfCreatorFields maps MARC relator codes to ComicInfo creator fields, the reverse of epubCreatorRoles
+var opfCreatorFields = map[string]func(c *ComicInfo) *string{
+	"aut": func(c *ComicInfo) *string { return &c.Writer },
+	"art": func(c *ComicInfo) *string { return &c.Penciller },
+	"ill": func(c *ComicInfo) *string { return &c.Penciller },
+	"clr": func(c *ComicInfo) *string { return &c.Colorist },
+	"cov": func(c *ComicInfo) *string { return &c.CoverArtist },
+	"edt": func(c *ComicInfo) *string { return &c.Editor },
+	"trl": func(c *ComicInfo) *string { return &c.Translator },
+}
+
+// refined returns the value of the first EPUB 3 meta refining the element with the id
+func (m opfMetadata) refined(id string, property string) string {
+	for _, meta := range m.Metas {
+		if id != "" && meta.Refines == "#"+id && meta.Property == property {
+			return strings.TrimSpace(meta.Value)
+		}
+	}
+	return ""
+}
+
+// named returns the content of the first EPUB 2 meta with the name
+func (m opfMetadata) named(name string) string {
+	for _, meta := range m.Metas {
+		if meta.Name == name {
+			return strings.TrimSpace(meta.Content)
+		}
+	}
+	return ""
+}
+
+// opfNumber normalizes a series position, Calibre writes "2.0" for volume 2
+func opfNumber(value string) string {
+	if f, err := strconv.ParseFloat(value, 64); err == nil && f == float64(int(f)) {
+		return strconv.Itoa(int(f))
+	}
+	return value
+}
+
+// comicInfo maps the OPF metadata to ComicInfo. Creators without a known role are taken as writers.
+func (m opfMetadata) comicInfo() ComicInfo {
+	info := ComicInfo{
+		Publisher:   strings.TrimSpace(m.Publisher),
+		Summary:     strings.TrimSpace(m.Description),
+		LanguageISO: strings.TrimSpace(m.Language),
+	}
+	if len(m.Titles) > 0 {
+		info.Title = strings.TrimSpace(m.Titles[0])
+	}
+
+	for _, creator := range m.Creators {
+		role := creator.Role
+		if refined := m.refined(creator.ID, "role"); refined != "" {
+			role = refined
+		}
+		field, ok := opfCreatorFields[role]
+		if !ok {
+			field = opfCreatorFields["aut"]
+		}
+		if name := strings.TrimSpace(creator.Name); name != "" {
+			*field(&info) = strings.Join(append(splitList(*field(&info)), name), ", ")
+		}
+	}
+
+	var subjects []string
+	for _, subject := range m.Subjects {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			subjects = append(subjects, subject)
+		}
+	}
+	info.Genre = strings.Join(subjects, ", ")
+
+	if len(m.Date) >= 4 {
+		info.Year, _ = strconv.Atoi(m.Date[:4])
+	}
+
+	// EPUB 3 collections, then Calibre's series metadata
+	for _, meta := range m.Metas {
+		if meta.Property != "belongs-to-collection" {
+			continue
+		}
+		if kind := m.refined(meta.ID, "collection-type"); kind != "" && kind != "series" {
+			continue
+		}
+		info.Series = strings.TrimSpace(meta.Value)
+		info.Volume = opfNumber(m.refined(meta.ID, "group-position"))
+		break
+	}
+	if info.Series == "" {
+		info.Series = m.named("calibre:series")
+		info.Volume = opfNumber(m.named("calibre:series_index"))
+	}
+	return info
+}
+
+// readZipFile reads a whole file from the zip archive
+func readZipFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// resolveHref resolves a URL-encoded href relative to the document it appears in, dropping any fragment
+func resolveHref(base string, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(path.Dir(base), href)
+}
+
+// newHTMLDecoder returns a lenient XML decoder, as content documents aren't always well-formed XHTML
+func newHTMLDecoder(data []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	return d
+}
+
+// xmlAttr returns the value of the attribute with the local name
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// epubDocumentImages returns the images a content document shows, <img src> and SVG <image href>, in order
+func epubDocumentImages(docPath string, data []byte) []string {
+	var images []string
+	seen := make(map[string]bool)
+	d := newHTMLDecoder(data)
+	for {
+		token, err := d.Token()
+		if err != nil {
+			break
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var href string
+		switch strings.ToLower(element.Name.Local) {
+		case "img":
+			href = xmlAttr(element, "src")
+		case "image":
+			href = xmlAttr(element, "href")
+		}
+		if href == "" || strings.HasPrefix(href, "data:") {
+			continue
+		}
+		if image := resolveHref(docPath, href); !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// epubNavEntries returns the targets and titles of the links in the EPUB 3 navigation document's toc
+func epubNavEntries(navPath string, data []byte) []epubNavPoint {
+	var entries []epubNavPoint
+	d := newHTMLDecoder(data)
+	inToc, inLink := false, false
+	var current epubNavPoint
+	for {
+		token, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "nav":
+				inToc = strings.Contains(xmlAttr(t, "type"), "toc")
+			case "a":
+				if inToc {
+					inLink = true
+					current = epubNavPoint{Href: resolveHref(navPath, xmlAttr(t, "href"))}
+				}
+			}
+		case xml.CharData:
+			if inLink {
+				current.Title += string(t)
+			}
+		case xml.EndElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "nav":
+				inToc = false
+			case "a":
+				if inLink {
+					current.Title = strings.Join(strings.Fields(current.Title), " ")
+					entries = append(entries, current)
+					inLink = false
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// epubNCXEntries returns the targets and titles of the EPUB 2 NCX navigation points
+func epubNCXEntries(ncxPath string, data []byte) []epubNavPoint {
+	var ncx struct {
+		NavPoints []struct {
+			Label   string `xml:"navLabel>text"`
+			Content struct {
+				Src string `xml:"src,attr"`
+			} `xml:"content"`
+		} `xml:"navMap>navPoint"`
+	}
+	if err := xml.Unmarshal(data, &ncx); err != nil {
+		return nil
+	}
+	var entries []epubNavPoint
+	for _, point := range ncx.NavPoints {
+		entries = append(entries, epubNavPoint{Title: strings.TrimSpace(point.Label), Href: resolveHref(ncxPath, point.Content.Src)})
+	}
+	return entries
+}
+
+// readEPUB reads a comic EPUB. Pages are the images of the spine documents in reading order,
+// chapters come from the table of contents and the OPF metadata is mapped to ComicInfo.
+func readEPUB(filePath string) (*Book, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	book, err := readEPUBFromZip(&r.Reader)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	book.closers = []io.Closer{r}
+	return book, nil
+}
+
+func readEPUBFromZip(r *zip.Reader) (*Book, error) {
+	files := make(map[string]*zip.File)
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+
+	containerData, err := readZipFile(files, "META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var container opfContainer
+	if err := xml.Unmarshal(containerData, &container); err != nil {
+		return nil, fmt.Errorf("container.xml: %w", err)
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("container.xml has no rootfile")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	opfData, err := readZipFile(files, opfPath)
+	if err != nil {
+		return nil, err
+	}
+	var pkg opfPackage
+	if err := xml.Unmarshal(opfData, &pkg); err != nil {
+		return nil, fmt.Errorf("%s: %w", opfPath, err)
+	}
+
+	book := &Book{Info: pkg.Metadata.comicInfo(), InfoSource: metaSourceOPF}
+	if pkg.Spine.Direction == "rtl" {
+		book.Info.Manga = mangaRightToLeft
+	}
+	if book.Info == (ComicInfo{}) {
+		book.InfoSource = metaSourceNone
+	}
+
+	items := make(map[string]opfItem)
+	for _, item := range pkg.Manifest {
+		items[item.ID] = item
+	}
+
+	// The first page of every spine document, to map the table of contents to pages
+	documentPages := make(map[string]int)
+	for _, itemref := range pkg.Spine.Items {
+		item, ok := items[itemref.IDRef]
+		if !ok {
+			continue
+		}
+		itemPath := resolveHref(opfPath, item.Href)
+
+		var images []string
+		if strings.HasPrefix(item.MediaType, "image/") {
+			images = []string{itemPath}
+		} else {
+			data, err := readZipFile(files, itemPath)
+			if err != nil {
+				return nil, err
+			}
+			images = epubDocumentImages(itemPath, data)
+		}
+
+		documentPages[itemPath] = len(book.Pages)
+		for _, image := range images {
+			f, ok := files[image]
+			if !ok || !isImageExt(strings.ToLower(path.Ext(image))) {
+				continue
+			}
+			book.Pages = append(book.Pages, Page{Name: image, open: f.Open})
+		}
+	}
+	if len(book.Pages) == 0 {
+		return nil, fmt.Errorf("no page images found in the spine")
+	}
+
+	// Table of contents, from the EPUB 3 navigation document or else the NCX
+	var entries []epubNavPoint
+	for _, item := range pkg.Manifest {
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			navPath := resolveHref(opfPath, item.Href)
+			if data, err := readZipFile(files, navPath); err == nil {
+				entries = epubNavEntries(navPath, data)
+			}
+		}
+	}
+	if ncx, ok := items[pkg.Spine.Toc]; ok && len(entries) == 0 {
+		ncxPath := resolveHref(opfPath, ncx.Href)
+		if data, err := readZipFile(files, ncxPath); err == nil {
+			entries = epubNCXEntries(ncxPath, data)
+		}
+	}
+
+	var chapters []Chapter
+	seen := make(map[int]bool)
+	for _, entry := range entries {
+		page, ok := documentPages[entry.Href]
+		if !ok || page >= len(book.Pages) || seen[page] {
+			continue
+		}
+		seen[page] = true
+		chapters = append(chapters, Chapter{Title: entry.Title, FirstPage: page})
+	}
+	book.setChapters(chapters)
+	return book, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOPFNumber(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{"2", "2"},
+		{"2.0", "2"},
+		{"2.5", "2.5"},
+		{"", ""},
+		{"II", "II"},
+	}
+
+	for _, tc := range testCases {
+		if result := opfNumber(tc.value); result != tc.expected {
+			t.Errorf("Expected opfNumber(%q) to be %q, got %q", tc.value, tc.expected, result)
+		}
+	}
+}
+
+func TestEPUBRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	info := ComicInfo{
+		Title:       "Title & More",
+		Series:      "Series",
+		Volume:      "3",
+		Summary:     "Summary",
+		Year:        2021,
+		Writer:      "Writer One, Writer Two",
+		Colorist:    "Colorist",
+		Translator:  "Translator",
+		Publisher:   "Publisher",
+		Genre:       "Action, Comedy",
+		LanguageISO: "ja",
+		Manga:       mangaRightToLeft,
+	}
+	book := testBook(t, info, 2, 3)
+	book.Chapters[0].Title = "First"
+	book.Chapters[1].Title = "Second"
+	if err := writeEPUB(book, path); err != nil {
+		t.Fatalf("Failed to write EPUB: %v", err)
+	}
+
+	result, err := readEPUB(path)
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	defer result.Close()
+
+	if !reflect.DeepEqual(result.Info, info) {
+		t.Errorf("Expected metadata\n%+v\ngot\n%+v", info, result.Info)
+	}
+	if len(result.Pages) != 5 {
+		t.Fatalf("Expected 5 pages, got %d", len(result.Pages))
+	}
+	for i, page := range result.Pages {
+		expected, _ := book.Pages[i].ReadAll()
+		if data, err := page.ReadAll(); err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("Expected page %d to be read in spine order (%v)", i, err)
+		}
+	}
+	expectedChapters := []Chapter{{Title: "First", FirstPage: 0}, {Title: "Second", FirstPage: 2}}
+	if !reflect.DeepEqual(result.Chapters, expectedChapters) {
+		t.Errorf("Expected chapters %v, got %v", expectedChapters, result.Chapters)
+	}
+}
+
+func TestReadEPUB2(t *testing.T) {
+	page := testPNGPage(t, "page.png", 10, 20)
+	pageData, _ := page.ReadAll()
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="content/book.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{"content/book.opf", `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Volume 2</dc:title>
+    <dc:creator opf:role="aut">Writer</dc:creator>
+    <dc:creator opf:role="ill">Artist</dc:creator>
+    <dc:creator>Unknown Role</dc:creator>
+    <dc:date>2019-04-01T00:00:00+00:00</dc:date>
+    <meta name="calibre:series" content="Series"/>
+    <meta name="calibre:series_index" content="2.0"/>
+  </metadata>
+  <manifest>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="cover" href="images/cover.png" media-type="image/png"/>
+    <item id="p1" href="text/page%201.html" media-type="application/xhtml+xml"/>
+    <item id="p2" href="text/page2.html" media-type="application/xhtml+xml"/>
+    <item id="pic" href="images/page%201.png" media-type="image/png"/>
+    <item id="pic2" href="images/page2.png" media-type="image/png"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="cover"/>
+    <itemref idref="p1"/>
+    <itemref idref="p2"/>
+  </spine>
+</package>`},
+		{"content/toc.ncx", `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="n1"><navLabel><text>Start</text></navLabel><content src="text/page%201.html#top"/></navPoint>
+    <navPoint id="n2"><navLabel><text>Later</text></navLabel><content src="text/page2.html"/></navPoint>
+  </navMap>
+</ncx>`},
+		{"content/text/page 1.html", `<html><body><p>Not XHTML<br></p><img src="../images/page%201.png"><img src="../images/page%201.png"></body></html>`},
+		{"content/text/page2.html", `<html xmlns="http://www.w3.org/1999/xhtml"><body><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="../images/page2.png"/></svg></body></html>`},
+		{"content/images/cover.png", string(pageData)},
+		{"content/images/page 1.png", string(pageData)},
+		{"content/images/page2.png", string(pageData)},
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, _ := zw.Create(f.name)
+		w.Write([]byte(f.content))
+	}
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := readEPUB(path)
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	defer book.Close()
+
+	var names []string
+	for _, page := range book.Pages {
+		names = append(names, page.Name)
+	}
+	expectedNames := []string{"content/images/cover.png", "content/images/page 1.png", "content/images/page2.png"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected pages %v, got %v", expectedNames, names)
+	}
+	expectedChapters := []Chapter{{Title: "Volume 2", FirstPage: 0}, {Title: "Start", FirstPage: 1}, {Title: "Later", FirstPage: 2}}
+	if !reflect.DeepEqual(book.Chapters, expectedChapters) {
+		t.Errorf("Expected chapters %v, got %v", expectedChapters, book.Chapters)
+	}
+	expectedInfo := ComicInfo{Title: "Volume 2", Series: "Series", Volume: "2", Year: 2019, Writer: "Writer, Unknown Role", Penciller: "Artist"}
+	if !reflect.DeepEqual(book.Info, expectedInfo) {
+		t.Errorf("Expected metadata %+v, got %+v", expectedInfo, book.Info)
+	}
+}
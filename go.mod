@@ -3,3 +3,21 @@ module cbzconcat
 go 1.20
 
 require github.com/mozillazg/go-unidecode v0.2.0
+
+require (
+	github.com/bodgit/sevenzip v1.4.5
+	github.com/nwaples/rardecode v1.1.3
+	golang.org/x/text v0.16.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.6 // indirect
+	github.com/bodgit/plumbing v1.3.0 // indirect
+	github.com/bodgit/windows v1.0.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/klauspost/compress v1.17.4 // indirect
+	github.com/pierrec/lz4/v4 v4.1.19 // indirect
+	github.com/ulikunitz/xz v0.5.11 // indirect
+	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
+)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strings"
+)
+
+// jpegQuality is the quality of the JPEGs cbztools encodes, for covers and thumbnails
+const jpegQuality = 90
+
+// scaleToFit scales an image down to fit in a maxWidth×maxHeight box, keeping its aspect ratio. A limit of 0 leaves
+// that side free. Images that already fit return the image as it is.
+func scaleToFit(img image.Image, maxWidth int, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	fitsWidth, fitsHeight := maxWidth <= 0 || width <= maxWidth, maxHeight <= 0 || height <= maxHeight
+	if fitsWidth && fitsHeight {
+		return img
+	}
+	// The side that needs the most scaling sets the scale
+	if !fitsWidth && (fitsHeight || width*maxHeight >= height*maxWidth) {
+		height, width = height*maxWidth/width, maxWidth
+	} else {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return resizeArea(img, width, height)
+}
+
+// resizeArea scales an image down to width×height, with each target pixel the average of the source pixels it covers
+func resizeArea(img image.Image, width int, height int) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := spanOf(y, height, bounds.Min.Y, bounds.Dy())
+		for x := 0; x < width; x++ {
+			x0, x1 := spanOf(x, width, bounds.Min.X, bounds.Dx())
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// RGBA() is premultiplied 16-bit, as is color.RGBA in 8 bits
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// spanOf returns the source range [from, to) of target index i out of n, for a source starting at min with length size
+func spanOf(i int, n int, min int, size int) (int, int) {
+	from, to := min+i*size/n, min+(i+1)*size/n
+	if to == from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// encodeJPEG encodes an image as a JPEG. JPEG has no alpha, so transparent areas are drawn on white instead of
+// turning black.
+func encodeJPEG(img image.Image) ([]byte, error) {
+	if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = flat
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodePage encodes a changed page image in the format of the page it came from, JPEG for JPEGs and PNG otherwise,
+// and returns the page name with a matching extension
+func encodePage(img image.Image, name string) ([]byte, string, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".jpg" || ext == ".jpeg" {
+		data, err := encodeJPEG(img)
+		return data, name, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), strings.TrimSuffix(name, path.Ext(name)) + ".png", nil
+}
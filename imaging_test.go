@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestScaleToFit(t *testing.T) {
+	testCases := []struct {
+		width, height       int
+		maxWidth, maxHeight int
+		expectedWidth       int
+		expectedHeight      int
+	}{
+		{100, 200, 50, 50, 25, 50},
+		{300, 100, 60, 60, 60, 20},
+		{40, 30, 50, 50, 40, 30},
+		{1000, 1, 10, 10, 10, 1},
+		{100, 200, 0, 0, 100, 200},
+		{1000, 1500, 300, 400, 266, 400},
+		{1500, 1000, 300, 400, 300, 200},
+		{1000, 1500, 300, 0, 300, 450},
+		{1000, 1500, 0, 300, 200, 300},
+	}
+
+	for _, tc := range testCases {
+		img := image.NewGray(image.Rect(0, 0, tc.width, tc.height))
+		bounds := scaleToFit(img, tc.maxWidth, tc.maxHeight).Bounds()
+		if bounds.Dx() != tc.expectedWidth || bounds.Dy() != tc.expectedHeight {
+			t.Errorf("Expected %dx%d in %dx%d to become %dx%d, got %dx%d", tc.width, tc.height, tc.maxWidth, tc.maxHeight,
+				tc.expectedWidth, tc.expectedHeight, bounds.Dx(), bounds.Dy())
+		}
+	}
+}
+
+func TestResizeArea(t *testing.T) {
+	// Black and white columns average to gray
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		img.SetGray(0, y, color.Gray{255})
+		img.SetGray(2, y, color.Gray{255})
+	}
+	result := resizeArea(img, 2, 1)
+	for x := 0; x < 2; x++ {
+		if c := result.RGBAAt(x, 0); c.R != 127 || c.A != 255 {
+			t.Errorf("Expected gray at %d, got %v", x, c)
+		}
+	}
+}
+
+func TestEncodePage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	testCases := []struct {
+		name           string
+		expectedName   string
+		expectedFormat string
+	}{
+		{"001.jpg", "001.jpg", "jpeg"},
+		{"ch1/002.JPEG", "ch1/002.JPEG", "jpeg"},
+		{"003.png", "003.png", "png"},
+		{"004.gif", "004.png", "png"},
+	}
+
+	for _, tc := range testCases {
+		data, name, err := encodePage(img, tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || format != tc.expectedFormat || name != tc.expectedName {
+			t.Errorf("Expected %s to become a %s named %s, got a %s named %s, %v", tc.name, tc.expectedFormat, tc.expectedName, format, name, err)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode"
+)
+
+// archiveEntry is a file inside an archive or folder
+type archiveEntry struct {
+	Name           string `json:"name"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size,omitempty"`
+	Method         string `json:"method"`
+}
+
+// pageStats summarizes the dimensions of the pages of a book
+type pageStats struct {
+	Count        int `json:"count"`
+	MinWidth     int `json:"min_width"`
+	MaxWidth     int `json:"max_width"`
+	MedianWidth  int `json:"median_width"`
+	MinHeight    int `json:"min_height"`
+	MaxHeight    int `json:"max_height"`
+	MedianHeight int `json:"median_height"`
+	Landscape    int `json:"landscape"`  // pages wider than high, usually double-page spreads
+	Unreadable   int `json:"unreadable"` // pages whose image header couldn't be decoded
+}
+
+// bookReport is what the info command prints for a file
+type bookReport struct {
+	Path               string         `json:"path"`
+	Format             string         `json:"format"`
+	Error              string         `json:"error,omitempty"`
+	FileSize           int64          `json:"file_size"`
+	UncompressedSize   int64          `json:"uncompressed_size"`
+	Entries            int            `json:"entries"`
+	CompressionMethods map[string]int `json:"compression_methods,omitempty"`
+	ImageTypes         map[string]int `json:"image_types"`
+	OtherEntries       []string       `json:"other_entries"`
+	Chapter            string         `json:"chapter"`
+	ChapterSource      string         `json:"chapter_source"`
+	Volume             string         `json:"volume"`
+	VolumeSource       string         `json:"volume_source"`
+	Group              string         `json:"group"`
+	Chapters           []Chapter      `json:"chapters,omitempty"`
+	Pages              pageStats      `json:"pages"`
+	MetadataSource     string         `json:"metadata_source"`
+	IDs                []bookID       `json:"ids,omitempty"`
+	ComicInfo          ComicInfo      `json:"comic_info"`
+}
+
+// entryListers maps input extensions to functions listing their entries. Formats without entries, like PDF, are missing.
+var entryListers = map[string]func(path string) ([]archiveEntry, error){
+	".cbz":  listZipEntries,
+	".epub": listZipEntries,
+	".cbr":  listRAREntries,
+	".cb7":  list7zEntries,
+	".cbt":  listTarEntries,
+}
+
+// zipMethodNames names the common zip compression methods
+var zipMethodNames = map[uint16]string{
+	zip.Store:   "store",
+	zip.Deflate: "deflate",
+	12:          "bzip2",
+	14:          "lzma",
+	93:          "zstd",
+	95:          "xz",
+	99:          "aes",
+}
+
+func listZipEntries(filePath string) ([]archiveEntry, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var entries []archiveEntry
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		method, ok := zipMethodNames[f.Method]
+		if !ok {
+			method = fmt.Sprintf("method %d", f.Method)
+		}
+		entries = append(entries, archiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64), CompressedSize: int64(f.CompressedSize64), Method: method})
+	}
+	return entries, nil
+}
+
+func listRAREntries(filePath string) ([]archiveEntry, error) {
+	r, err := rardecode.OpenReader(filePath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var entries []archiveEntry
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !header.IsDir {
+			entries = append(entries, archiveEntry{Name: header.Name, Size: header.UnPackedSize, CompressedSize: header.PackedSize, Method: "rar"})
+		}
+	}
+}
+
+func list7zEntries(filePath string) ([]archiveEntry, error) {
+	r, err := sevenzip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var entries []archiveEntry
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			entries = append(entries, archiveEntry{Name: f.Name, Size: int64(f.UncompressedSize), Method: "7z"})
+		}
+	}
+	return entries, nil
+}
+
+func listTarEntries(filePath string) ([]archiveEntry, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []archiveEntry
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			entries = append(entries, archiveEntry{Name: header.Name, Size: header.Size, Method: "none"})
+		}
+	}
+}
+
+func listFolderEntries(dir string) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		entries = append(entries, archiveEntry{Name: filepath.ToSlash(rel), Size: info.Size(), Method: "none"})
+		return nil
+	})
+	return entries, err
+}
+
+// inputFormat names the format of an input path, "folder" for a directory
+func inputFormat(filePath string) string {
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		return "folder"
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), ".")
+}
+
+// median returns the middle value of a sorted copy of values
+func median(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// intRange returns the smallest and largest of values, which must not be empty
+func intRange(values []int) (int, int) {
+	low, high := values[0], values[0]
+	for _, v := range values[1:] {
+		if v < low {
+			low = v
+		}
+		if v > high {
+			high = v
+		}
+	}
+	return low, high
+}
+
+// newPageStats reads the image headers of all pages, counting them by image format
+func newPageStats(pages []Page) (pageStats, map[string]int) {
+	stats := pageStats{Count: len(pages)}
+	types := make(map[string]int)
+	var widths, heights []int
+	for _, page := range pages {
+		config, format, err := page.DecodeConfig()
+		if err != nil {
+			stats.Unreadable++
+			types["unknown"]++
+			continue
+		}
+		types[format]++
+		widths = append(widths, config.Width)
+		heights = append(heights, config.Height)
+		if config.Width > config.Height {
+			stats.Landscape++
+		}
+	}
+	if len(widths) > 0 {
+		stats.MedianWidth, stats.MedianHeight = median(widths), median(heights)
+		stats.MinWidth, stats.MaxWidth = intRange(widths)
+		stats.MinHeight, stats.MaxHeight = intRange(heights)
+	}
+	return stats, types
+}
+
+// newBookReport inspects an input file. Errors are recorded in the report, so one bad file doesn't stop the others.
+func newBookReport(filePath string) bookReport {
+	report := bookReport{Path: filePath, Format: inputFormat(filePath), ImageTypes: map[string]int{}}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.FileSize = stat.Size()
+
+	var entries []archiveEntry
+	if report.Format == "folder" {
+		entries, err = listFolderEntries(filePath)
+	} else if lister, ok := entryListers["."+report.Format]; ok {
+		entries, err = lister(filePath)
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if report.Format == "folder" {
+		report.FileSize = 0
+	}
+	report.Entries = len(entries)
+	if len(entries) > 0 {
+		report.CompressionMethods = make(map[string]int)
+	}
+	for _, entry := range entries {
+		report.UncompressedSize += entry.Size
+		if report.Format == "folder" {
+			report.FileSize += entry.Size
+		}
+		report.CompressionMethods[entry.Method]++
+		if !isImageExt(strings.ToLower(path.Ext(entry.Name))) {
+			report.OtherEntries = append(report.OtherEntries, entry.Name)
+		}
+	}
+
+	book, err := readBook(filePath)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer book.Close()
+	if report.UncompressedSize == 0 {
+		report.UncompressedSize = report.FileSize
+	}
+
+	report.MetadataSource = book.InfoSource
+	report.IDs = book.IDs
+	report.ComicInfo = book.Info
+	if len(book.Chapters) > 1 {
+		report.Chapters = book.Chapters
+	}
+	chapter := resolveChapter(filePath, book.Info)
+	report.Chapter, report.ChapterSource = chapter.Chapter, chapter.ChapterSource
+	report.Volume, report.VolumeSource = chapter.Volume, chapter.VolumeSource
+	report.Group = chapter.Group
+	report.Pages, report.ImageTypes = newPageStats(book.Pages)
+	return report
+}
+
+// formatSize formats a byte count for humans, like "1.5 MiB"
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// formatCounts formats counts by name, like "jpeg: 20, png: 2", most common first
+func formatCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %d", name, counts[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printBookReport prints the report as aligned "Key: value" lines followed by the ComicInfo XML
+func printBookReport(w io.Writer, report bookReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "File:\t%s\n", report.Path)
+	fmt.Fprintf(tw, "Format:\t%s\n", report.Format)
+	if report.Error != "" {
+		fmt.Fprintf(tw, "Error:\t%s\n", report.Error)
+		tw.Flush()
+		return
+	}
+	fmt.Fprintf(tw, "Size:\t%s (uncompressed %s)\n", formatSize(report.FileSize), formatSize(report.UncompressedSize))
+	if report.Entries > 0 {
+		fmt.Fprintf(tw, "Entries:\t%d (%s)\n", report.Entries, formatCounts(report.CompressionMethods))
+	}
+	fmt.Fprintf(tw, "Images:\t%d (%s)\n", report.Pages.Count, formatCounts(report.ImageTypes))
+	fmt.Fprintf(tw, "Chapter:\t%s (%s)\n", orDash(report.Chapter), report.ChapterSource)
+	fmt.Fprintf(tw, "Volume:\t%s (%s)\n", orDash(report.Volume), report.VolumeSource)
+	fmt.Fprintf(tw, "Group:\t%s\n", orDash(report.Group))
+	fmt.Fprintf(tw, "Metadata:\t%s\n", report.MetadataSource)
+	if len(report.IDs) > 0 {
+		ids := make([]string, len(report.IDs))
+		for i, id := range report.IDs {
+			ids[i] = id.String()
+		}
+		fmt.Fprintf(tw, "IDs:\t%s\n", strings.Join(ids, ", "))
+	}
+
+	stats := report.Pages
+	if stats.Count > stats.Unreadable {
+		fmt.Fprintf(tw, "Page size:\tmedian %dx%d, width %d-%d, height %d-%d\n",
+			stats.MedianWidth, stats.MedianHeight, stats.MinWidth, stats.MaxWidth, stats.MinHeight, stats.MaxHeight)
+		fmt.Fprintf(tw, "Landscape pages:\t%d\n", stats.Landscape)
+	}
+	if stats.Unreadable > 0 {
+		fmt.Fprintf(tw, "Unreadable pages:\t%d\n", stats.Unreadable)
+	}
+	for i, chapter := range report.Chapters {
+		fmt.Fprintf(tw, "Chapter %d:\tpage %d, %s\n", i+1, chapter.FirstPage+1, orDash(chapter.Title))
+	}
+	if len(report.OtherEntries) > 0 {
+		fmt.Fprintf(tw, "Other entries:\t%s\n", strings.Join(report.OtherEntries, ", "))
+	}
+	tw.Flush()
+
+	xmlBytes, _ := xml.MarshalIndent(report.ComicInfo, "", "  ")
+	fmt.Fprintf(w, "ComicInfo:\n%s\n", xmlBytes)
+}
+
+// cmdInfo prints what's inside archives, PDFs, EPUBs or image folders
+func cmdInfo(args []string) {
+	infoFlags := flag.NewFlagSet("info", flag.ExitOnError)
+	jsonOutput := infoFlags.Bool("json", false, "Print a JSON array with one object per file, for scripting")
+
+	infoFlags.Parse(args)
+
+	if infoFlags.NArg() == 0 {
+		fmt.Printf("cbztools info v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools info [flags] <file...>")
+		fmt.Println("Flags:")
+		infoFlags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	reports := make([]bookReport, 0, infoFlags.NArg())
+	failed := false
+	for _, filePath := range infoFlags.Args() {
+		report := newBookReport(filePath)
+		failed = failed || report.Error != ""
+		reports = append(reports, report)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(reports)
+	} else {
+		for i, report := range reports {
+			if i > 0 {
+				fmt.Println()
+			}
+			printBookReport(os.Stdout, report)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
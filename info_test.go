@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	testCases := []struct {
+		size     int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+
+	for _, tc := range testCases {
+		if result := formatSize(tc.size); result != tc.expected {
+			t.Errorf("Expected formatSize(%d) to be %q, got %q", tc.size, tc.expected, result)
+		}
+	}
+}
+
+func TestFormatCounts(t *testing.T) {
+	result := formatCounts(map[string]int{"png": 2, "jpeg": 20, "gif": 2})
+	if expected := "jpeg: 20, gif: 2, png: 2"; result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+func TestNewPageStats(t *testing.T) {
+	pages := []Page{
+		testPNGPage(t, "1.png", 100, 150),
+		testPNGPage(t, "2.png", 200, 150),
+		testPNGPage(t, "3.png", 110, 160),
+		newBytesPage("broken.jpg", []byte("not an image")),
+	}
+	stats, types := newPageStats(pages)
+
+	expected := pageStats{Count: 4, MinWidth: 100, MaxWidth: 200, MedianWidth: 110, MinHeight: 150, MaxHeight: 160, MedianHeight: 150, Landscape: 1, Unreadable: 1}
+	if stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+	if !reflect.DeepEqual(types, map[string]int{"png": 3, "unknown": 1}) {
+		t.Errorf("Expected image types by format, got %v", types)
+	}
+}
+
+func TestNewBookReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Series Vol.02 Ch.0015.cbz")
+	page, _ := testPNGPage(t, "page.png", 40, 60).ReadAll()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"001.png", "002.png"} {
+		w, _ := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		w.Write(page)
+	}
+	w, _ := zw.Create("notes.txt")
+	w.Write([]byte("some notes"))
+	w, _ = zw.Create("ComicInfo.xml")
+	w.Write([]byte("<ComicInfo><Series>Series</Series><Number>15</Number></ComicInfo>"))
+	zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report := newBookReport(path)
+	if report.Error != "" {
+		t.Fatalf("Unexpected error: %s", report.Error)
+	}
+	if report.Format != "cbz" || report.Entries != 4 || report.FileSize != int64(buf.Len()) {
+		t.Errorf("Expected 4 entries in a cbz of %d bytes, got %+v", buf.Len(), report)
+	}
+	if !reflect.DeepEqual(report.CompressionMethods, map[string]int{"store": 2, "deflate": 2}) {
+		t.Errorf("Expected compression methods, got %v", report.CompressionMethods)
+	}
+	if !reflect.DeepEqual(report.ImageTypes, map[string]int{"png": 2}) || report.Pages.Count != 2 || report.Pages.MedianWidth != 40 {
+		t.Errorf("Expected 2 PNG pages, got %v and %+v", report.ImageTypes, report.Pages)
+	}
+	if !reflect.DeepEqual(report.OtherEntries, []string{"notes.txt", "ComicInfo.xml"}) {
+		t.Errorf("Expected other entries, got %v", report.OtherEntries)
+	}
+	if report.Chapter != "15" || report.ChapterSource != sourceComicInfo || report.Volume != "02" || report.VolumeSource != sourceFilename {
+		t.Errorf("Expected the resolved chapter and volume, got %+v", report)
+	}
+	if report.ComicInfo.Series != "Series" || report.MetadataSource != metaSourceComicInfo {
+		t.Errorf("Expected the ComicInfo, got %+v", report.ComicInfo)
+	}
+
+	var out bytes.Buffer
+	printBookReport(&out, report)
+	for _, expected := range []string{"Entries:          4 (deflate: 2, store: 2)", "Images:           2 (png: 2)", "Chapter:          15 (ComicInfo)", "Metadata:         ComicInfo.xml", "<Series>Series</Series>"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the report to contain %q, got\n%s", expected, out.String())
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"comic_info":{"title":"","series":"Series","number":"15"`, `"page_count":0`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected the JSON report to contain %s, got %s", expected, data)
+		}
+	}
+
+	if missing := newBookReport(filepath.Join(t.TempDir(), "missing.cbz")); missing.Error == "" {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
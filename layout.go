@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Output layouts of concat
+const (
+	layoutFlat   = "flat"
+	layoutMihon  = "mihon"
+	layoutKomga  = "komga"
+	layoutKavita = "kavita"
+)
+
+// serverNameTemplate names the output the way Komga and Kavita parse file names, "Series Vol.01" or "Series Ch.001-010"
+const serverNameTemplate = `{{.Series}} {{if .Volume}}Vol.{{pad 2 .Volume}}{{else}}Ch.{{pad 3 .FirstChapter}}-{{pad 3 .LastChapter}}{{end}}`
+
+// layoutNameTemplates are the default output name templates of layouts, used unless --name-template is set
+var layoutNameTemplates = map[string]string{
+	layoutKomga:  serverNameTemplate,
+	layoutKavita: serverNameTemplate,
+}
+
+// mylarSeriesName is the Mylar series sidecar Komga reads from a series folder
+const mylarSeriesName = "series.json"
+
+// parseLayout checks the --layout flag value, and that the layout can hold the output format
+func parseLayout(value string, format string) (string, error) {
+	switch value {
+	case layoutFlat, layoutKomga, layoutKavita:
+		return value, nil
+	case layoutMihon:
+		for _, f := range mihonFormats {
+			if f == format {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("the %s layout needs a format Mihon can read: %s", value, strings.Join(mihonFormats, ", "))
+	}
+	return "", fmt.Errorf("invalid layout %q, expected %s, %s, %s or %s", value, layoutFlat, layoutKomga, layoutKavita, layoutMihon)
+}
+
+// layoutDir returns the folder the output goes in: outputDir itself for the flat layout, a folder for the series in it otherwise.
+// Media servers take every folder in the library root as a series, so the other layouts never write to outputDir itself.
+// The folder keeps the spaces and dots of the series name, like the server naming, and gets the profile's Finalize.
+func layoutDir(layout string, outputDir string, series string, profile sanitizeProfile) (string, error) {
+	if layout == layoutFlat {
+		return outputDir, nil
+	}
+	series = strings.TrimSpace(series)
+	if series == "" {
+		return "", fmt.Errorf("the %s layout needs a series name, but the chapters have none", layout)
+	}
+	name := profile.Finalize(series)
+	if len(name) > maxFilenameBytes {
+		name = profile.Finalize(truncateUTF8(name, maxFilenameBytes))
+	}
+	return filepath.Join(outputDir, name), nil
+}
+
+// writeLayoutFiles writes the series sidecars of the layout into the series folder, and returns the paths it wrote.
+// details are the Mihon series details of the input, if any.
+func writeLayoutFiles(layout string, book *Book, dir string, details mihonDetails) ([]string, error) {
+	switch layout {
+	case layoutMihon:
+		return writeMihonFiles(book, dir, details.Status)
+	case layoutKomga:
+		return writeMylarSeries(book.Info, dir, details.ended())
+	}
+	return nil, nil
+}
+
+// mylarSeries is the series.json Mylar writes and Komga reads
+type mylarSeries struct {
+	Version  string        `json:"version"`
+	Metadata mylarMetadata `json:"metadata"`
+}
+
+// mylarMetadata has every field of Mylar's schema, Komga rejects the file if the required ones are missing
+type mylarMetadata struct {
+	Type                 string  `json:"type"`
+	Publisher            string  `json:"publisher"`
+	Imprint              *string `json:"imprint"`
+	Name                 string  `json:"name"`
+	ComicID              int     `json:"comicid"`
+	Year                 int     `json:"year"`
+	DescriptionText      *string `json:"description_text"`
+	DescriptionFormatted *string `json:"description_formatted"`
+	Volume               *int    `json:"volume"`
+	BookType             string  `json:"booktype"`
+	AgeRating            *string `json:"age_rating"`
+	ComicImage           *string `json:"ComicImage"`
+	TotalIssues          int     `json:"total_issues"`
+	PublicationRun       string  `json:"publication_run"`
+	Status               string  `json:"status"`
+}
+
+// mylarAgeRatings maps ComicInfo age ratings to the ones Komga reads from series.json
+var mylarAgeRatings = map[string]string{
+	"Early Childhood": "All",
+	"Everyone":        "All",
+	"G":               "All",
+	"Kids to Adults":  "All",
+	"Everyone 10+":    "9+",
+	"PG":              "9+",
+	"Teen":            "12+",
+	"MA15+":           "15+",
+	"M":               "17+",
+	"Mature 17+":      "17+",
+	"R18+":            "Adult",
+	"Adults Only 18+": "Adult",
+	"X18+":            "Adult",
+}
+
+// optionalString is nil for an empty string, for the nullable fields of series.json
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// newMylarSeries maps ComicInfo to series.json. The volume is left out: in Mylar it's the volume of the series,
+// like the 2016 run of a comic, not the number of a collected volume.
+func newMylarSeries(info ComicInfo, ended bool) mylarSeries {
+	metadata := mylarMetadata{
+		Type:            "comicSeries",
+		Publisher:       info.Publisher,
+		Imprint:         optionalString(info.Imprint),
+		Name:            info.Series,
+		Year:            info.Year,
+		DescriptionText: optionalString(info.Summary),
+		BookType:        "Print",
+		AgeRating:       optionalString(mylarAgeRatings[info.AgeRating]),
+		TotalIssues:     info.Count,
+		Status:          "Continuing",
+	}
+	if ended {
+		metadata.Status = "Ended"
+	}
+	if info.Year > 0 {
+		metadata.PublicationRun = strconv.Itoa(info.Year)
+	}
+	return mylarSeries{Version: "1.0.2", Metadata: metadata}
+}
+
+// writeMylarSeries writes series.json into a series folder, unless there already is one
+func writeMylarSeries(info ComicInfo, dir string, ended bool) ([]string, error) {
+	path := filepath.Join(dir, mylarSeriesName)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil, nil
+	}
+	data, err := json.MarshalIndent(newMylarSeries(info, ended), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	testCases := []struct {
+		layout string
+		format string
+		valid  bool
+	}{
+		{layoutFlat, formatPDF, true},
+		{layoutMihon, formatCBZ, true},
+		{layoutMihon, formatEPUB, true},
+		{layoutMihon, formatPDF, false},
+		{layoutKomga, formatCBZ, true},
+		{layoutKavita, formatPDF, true},
+		{"nested", formatCBZ, false},
+	}
+
+	for _, tc := range testCases {
+		if _, err := parseLayout(tc.layout, tc.format); (err == nil) != tc.valid {
+			t.Errorf("Expected layout %s with %s to be valid: %v, got %v", tc.layout, tc.format, tc.valid, err)
+		}
+	}
+}
+
+func TestLayoutDir(t *testing.T) {
+	profile, _ := getSanitizeProfile(profileWindowsSafe)
+	testCases := []struct {
+		layout   string
+		series   string
+		expected string
+	}{
+		{layoutFlat, "Series", "out"},
+		{layoutFlat, "", "out"},
+		{layoutMihon, "Series: Part 2", filepath.Join("out", "Series_ Part 2")},
+		{layoutMihon, " ", ""},
+		{layoutKomga, "..", filepath.Join("out", "untitled")},
+		{layoutKavita, "Series", filepath.Join("out", "Series")},
+	}
+
+	for _, tc := range testCases {
+		dir, err := layoutDir(tc.layout, "out", tc.series, profile)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error for layout %s without a series, got %s", tc.layout, dir)
+			}
+			continue
+		}
+		if err != nil || dir != tc.expected {
+			t.Errorf("Expected layout %s for %q to be %s, got %s, %v", tc.layout, tc.series, tc.expected, dir, err)
+		}
+	}
+}
+
+func TestLayoutDirASCII(t *testing.T) {
+	profile, _ := getSanitizeProfile(profileASCII)
+	dir, err := layoutDir(layoutKomga, "out", "エルフ Vol.2", profile)
+	if expected := filepath.Join("out", "eruhu Vol.2"); err != nil || dir != expected {
+		t.Errorf("Expected the series folder to be transliterated to %s, got %s, %v", expected, dir, err)
+	}
+}
+
+func TestServerNameTemplate(t *testing.T) {
+	profile, _ := getSanitizeProfile(profileASCII)
+	tmpl, err := parseNameTemplate(serverNameTemplate, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		data     nameData
+		expected string
+	}{
+		{nameData{Series: "My Series", Volume: "1", FirstChapter: "1", LastChapter: "8"}, "My Series Vol.01"},
+		{nameData{Series: "My Series", FirstChapter: "1", LastChapter: "10.5"}, "My Series Ch.001-010.5"},
+		{nameData{Series: "What?", Volume: "12"}, "What_ Vol.12"},
+		{nameData{Series: "エルフ", Volume: "1"}, "eruhu Vol.01"},
+	}
+
+	for _, tc := range testCases {
+		if name, err := renderName(tmpl, tc.data, profile, maxFilenameBytes); err != nil || name != tc.expected {
+			t.Errorf("Expected %q, got %q, %v", tc.expected, name, err)
+		}
+	}
+}
+
+func TestServerNameSeveralVolumes(t *testing.T) {
+	profile, _ := getSanitizeProfile(profileASCII)
+	tmpl, err := parseNameTemplate(serverNameTemplate, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chapters := []chapterFile{
+		resolveChapter("a.cbz", ComicInfo{Series: "Series", Volume: "1", Number: "8"}),
+		resolveChapter("b.cbz", ComicInfo{Series: "Series", Volume: "2", Number: "9"}),
+	}
+	// Naming a merge across volumes after the first would replace that volume's file in the series folder
+	name, err := renderName(tmpl, newNameData(chapters), profile, maxFilenameBytes)
+	if expected := "Series Ch.008-009"; err != nil || name != expected {
+		t.Errorf("Expected %q, got %q, %v", expected, name, err)
+	}
+}
+
+func TestWriteMylarSeries(t *testing.T) {
+	dir := t.TempDir()
+	info := ComicInfo{Series: "Series", Publisher: "Pub", Year: 2020, Count: 12, Summary: "Summary", AgeRating: "Teen"}
+	written, err := writeMylarSeries(info, dir, true)
+	if err != nil || len(written) != 1 {
+		t.Fatalf("Expected series.json to be written, got %v, %v", written, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, mylarSeriesName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	// Komga needs all of these, even when they are null
+	for _, key := range []string{"type", "publisher", "imprint", "name", "comicid", "year", "description_text", "description_formatted",
+		"volume", "booktype", "age_rating", "ComicImage", "total_issues", "publication_run", "status"} {
+		if _, ok := raw.Metadata[key]; !ok {
+			t.Errorf("Expected series.json to have %s, got %s", key, data)
+		}
+	}
+	expected := map[string]interface{}{"name": "Series", "publisher": "Pub", "year": 2020.0, "total_issues": 12.0,
+		"description_text": "Summary", "age_rating": "12+", "status": "Ended", "imprint": nil}
+	for key, value := range expected {
+		if raw.Metadata[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, raw.Metadata[key])
+		}
+	}
+
+	// An existing series.json is kept
+	if written, err := writeMylarSeries(ComicInfo{Series: "Other"}, dir, false); err != nil || len(written) != 0 {
+		t.Errorf("Expected series.json to be kept, got %v, %v", written, err)
+	}
+}
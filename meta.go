@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cmdMeta dispatches the metadata subcommands
+func cmdMeta(args []string) {
+	if len(args) == 0 {
+		fmt.Printf("cbztools meta v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools meta <command> [flags] <file...>")
+		fmt.Println("Commands:")
+		fmt.Println("  export    Write the metadata of archives to sidecar files")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "export":
+		cmdMetaExport(args[1:])
+	default:
+		fmt.Printf("Unknown meta command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func cmdMetaExport(args []string) {
+	exportFlags := flag.NewFlagSet("meta export", flag.ExitOnError)
+	opf := exportFlags.Bool("opf", false, "Write a Calibre metadata.opf and cover.jpg next to each file, inside it for folders")
+	runSilent := exportFlags.Bool("s", false, "Silent mode, only print errors")
+	runVerbose := exportFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	exportFlags.Parse(args)
+
+	if exportFlags.NArg() == 0 {
+		fmt.Printf("cbztools meta export v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools meta export [flags] <file...>")
+		fmt.Println("Flags:")
+		exportFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	if !*opf {
+		fmt.Println("Nothing to export, choose a format like --opf")
+		os.Exit(1)
+	}
+
+	// Calibre keeps one book per folder, so two files in one folder would overwrite each other's sidecars
+	exported := make(map[string]string)
+	failed := 0
+	for _, filePath := range exportFlags.Args() {
+		dir := calibreDir(filePath)
+		if other, ok := exported[dir]; ok {
+			fmt.Printf("Error exporting %s: %s already has the metadata of %s\n", filePath, dir, other)
+			failed++
+			continue
+		}
+		if err := exportOPF(filePath, dir); err != nil {
+			fmt.Printf("Error exporting %s: %v\n", filePath, err)
+			failed++
+			continue
+		}
+		exported[dir] = filePath
+		printIfNotSilent(fmt.Sprintf("Exported %s to %s", filePath, filepath.Join(dir, calibreOPFName)), runSilent, runVerbose)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d files could not be exported\n", failed, exportFlags.NArg())
+		os.Exit(1)
+	}
+}
+
+// calibreDir is where the Calibre sidecars of an input go: the folder itself for chapter folders, next to it otherwise
+func calibreDir(filePath string) string {
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		return filepath.Clean(filePath)
+	}
+	return filepath.Dir(filePath)
+}
+
+// exportOPF reads a book and writes its metadata.opf and cover.jpg into dir
+func exportOPF(filePath string, dir string) error {
+	book, err := readBook(filePath)
+	if err != nil {
+		return err
+	}
+	defer book.Close()
+	return writeCalibreFiles(book, dir)
+}
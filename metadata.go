@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// Sources of a book's metadata, reported by info
+const (
+	metaSourceComicInfo     = "ComicInfo.xml"
+	metaSourceComicBookInfo = "ComicBookInfo"
+	metaSourceCoMet         = "CoMet"
+	metaSourceMetronInfo    = "MetronInfo.xml"
+	metaSourcePDF           = "PDF info"
+	metaSourceOPF           = "OPF"
+	metaSourceMihon         = "details.json"
+	metaSourceNone          = "none"
+)
+
+// metadataFileSources maps the lower-case names of metadata sidecar files to their source
+var metadataFileSources = map[string]string{
+	"comicinfo.xml":  metaSourceComicInfo,
+	"comet.xml":      metaSourceCoMet,
+	"metroninfo.xml": metaSourceMetronInfo,
+	"metadata.opf":   metaSourceOPF,
+}
+
+// isWrittenMetadata reports whether an archive entry is a metadata file the archive writers write themselves
+func isWrittenMetadata(name string) bool {
+	source := metadataFileSources[strings.ToLower(path.Base(filepath.ToSlash(name)))]
+	return source == metaSourceComicInfo || source == metaSourceMetronInfo
+}
+
+// metadataOrder is the order of preference of the metadata sources of an archive
+var metadataOrder = []string{metaSourceComicInfo, metaSourceComicBookInfo, metaSourceCoMet, metaSourceMetronInfo, metaSourceOPF}
+
+// metadataParsers map the metadata sources of an archive to their parsers
+var metadataParsers = map[string]func(data []byte) (ComicInfo, error){
+	metaSourceComicInfo:     parseComicInfo,
+	metaSourceComicBookInfo: parseComicBookInfo,
+	metaSourceCoMet:         parseCoMet,
+	metaSourceMetronInfo:    parseMetronInfo,
+	metaSourceOPF:           parseOPF,
+}
+
+// Extra metadata formats that can be written alongside ComicInfo.xml, see parseExtraMetadata
+const (
+	extraComicBookInfo = "comicbookinfo"
+	extraMetronInfo    = "metroninfo"
+)
+
+// extraMetadataFormats lists the extra metadata formats, in the order they are documented
+var extraMetadataFormats = []string{extraComicBookInfo, extraMetronInfo}
+
+// parseExtraMetadata parses the comma-separated --extra-metadata flag value
+func parseExtraMetadata(value string) ([]string, error) {
+	var formats []string
+	for _, format := range splitList(strings.ToLower(value)) {
+		known := false
+		for _, f := range extraMetadataFormats {
+			known = known || f == format
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown metadata format %q, expected %s", format, strings.Join(extraMetadataFormats, ", "))
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// writesMetadata reports whether writers should add the extra metadata format
+func (b *Book) writesMetadata(format string) bool {
+	for _, f := range b.ExtraMetadata {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataFile is a metadata sidecar found in an archive
+type metadataFile struct {
+	Name string
+	Data []byte
+}
+
+// metadataFiles collects the metadata sidecars of an archive while its entries are listed.
+// Sidecars are matched by name at any depth; the one closest to the root wins, then the first one.
+type metadataFiles struct {
+	files   map[string]metadataFile // by source
+	Comment string                  // the zip comment, which can hold ComicBookInfo
+}
+
+// entryDepth is the number of directories an archive entry is in
+func entryDepth(name string) int {
+	return strings.Count(strings.Trim(filepath.ToSlash(name), "/"), "/")
+}
+
+// offer considers an archive entry. It is only read if it's a metadata sidecar closer to the root than the one
+// found so far.
+func (m *metadataFiles) offer(name string, read func() ([]byte, error)) error {
+	source, ok := metadataFileSources[strings.ToLower(path.Base(filepath.ToSlash(name)))]
+	if !ok {
+		return nil
+	}
+	if current, found := m.files[source]; found && entryDepth(current.Name) <= entryDepth(name) {
+		return nil
+	}
+	data, err := read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if m.files == nil {
+		m.files = make(map[string]metadataFile)
+	}
+	m.files[source] = metadataFile{Name: name, Data: data}
+	return nil
+}
+
+// data returns the raw metadata of a source
+func (m metadataFiles) data(source string) ([]byte, bool) {
+	if source == metaSourceComicBookInfo {
+		return []byte(m.Comment), m.Comment != ""
+	}
+	f, ok := m.files[source]
+	return f.Data, ok
+}
+
+// resolve parses the most preferred metadata source, see metadataOrder. Sources that can't be parsed are passed over.
+// A MetronInfo.xml next to the preferred source fills in the fields that source leaves empty.
+func (m metadataFiles) resolve() (ComicInfo, string) {
+	for _, source := range metadataOrder {
+		data, ok := m.data(source)
+		if !ok {
+			continue
+		}
+		info, err := metadataParsers[source](data)
+		if err != nil {
+			continue
+		}
+		if data, ok := m.data(metaSourceMetronInfo); ok && source != metaSourceMetronInfo {
+			if metron, err := parseMetronInfo(data); err == nil {
+				fillComicInfo(&info, metron)
+			}
+		}
+		return info, source
+	}
+	return ComicInfo{}, metaSourceNone
+}
+
+// ids returns the database IDs from the MetronInfo.xml, the only source that has them
+func (m metadataFiles) ids() []bookID {
+	if data, ok := m.data(metaSourceMetronInfo); ok {
+		return metronIDs(data)
+	}
+	return nil
+}
+
+// fillComicInfo sets the empty fields of info to those of other
+func fillComicInfo(info *ComicInfo, other ComicInfo) {
+	dst, src := reflect.ValueOf(info).Elem(), reflect.ValueOf(other)
+	for i := 0; i < dst.NumField(); i++ {
+		if dst.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// readOpened returns a function reading all of an entry, for metadataFiles.offer
+func readOpened(open func() (io.ReadCloser, error)) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+}
+
+// creditFields maps lower-case credit roles of ComicBookInfo, CoMet and MetronInfo to ComicInfo creator fields
+var creditFields = map[string]func(c *ComicInfo) *string{
+	"writer":          func(c *ComicInfo) *string { return &c.Writer },
+	"author":          func(c *ComicInfo) *string { return &c.Writer },
+	"story":           func(c *ComicInfo) *string { return &c.Writer },
+	"script":          func(c *ComicInfo) *string { return &c.Writer },
+	"plotter":         func(c *ComicInfo) *string { return &c.Writer },
+	"scripter":        func(c *ComicInfo) *string { return &c.Writer },
+	"plot":            func(c *ComicInfo) *string { return &c.Writer },
+	"penciller":       func(c *ComicInfo) *string { return &c.Penciller },
+	"penciler":        func(c *ComicInfo) *string { return &c.Penciller },
+	"artist":          func(c *ComicInfo) *string { return &c.Penciller },
+	"illustrator":     func(c *ComicInfo) *string { return &c.Penciller },
+	"breakdowns":      func(c *ComicInfo) *string { return &c.Penciller },
+	"layouts":         func(c *ComicInfo) *string { return &c.Penciller },
+	"inker":           func(c *ComicInfo) *string { return &c.Inker },
+	"embellisher":     func(c *ComicInfo) *string { return &c.Inker },
+	"finishes":        func(c *ComicInfo) *string { return &c.Inker },
+	"colorist":        func(c *ComicInfo) *string { return &c.Colorist },
+	"colourist":       func(c *ComicInfo) *string { return &c.Colorist },
+	"colorer":         func(c *ComicInfo) *string { return &c.Colorist },
+	"letterer":        func(c *ComicInfo) *string { return &c.Letterer },
+	"cover":           func(c *ComicInfo) *string { return &c.CoverArtist },
+	"cover artist":    func(c *ComicInfo) *string { return &c.CoverArtist },
+	"coverartist":     func(c *ComicInfo) *string { return &c.CoverArtist },
+	"coverdesigner":   func(c *ComicInfo) *string { return &c.CoverArtist },
+	"editor":          func(c *ComicInfo) *string { return &c.Editor },
+	"editor in chief": func(c *ComicInfo) *string { return &c.Editor },
+	"translator":      func(c *ComicInfo) *string { return &c.Translator },
+}
+
+// addCredit adds a person to the creator field of the role, unless the role is unknown or the person already listed
+func addCredit(info *ComicInfo, role string, person string) {
+	field, ok := creditFields[strings.ToLower(strings.TrimSpace(role))]
+	person = strings.TrimSpace(person)
+	if !ok || person == "" {
+		return
+	}
+	names := splitList(*field(info))
+	for _, name := range names {
+		if name == person {
+			return
+		}
+	}
+	*field(info) = strings.Join(append(names, person), ", ")
+}
+
+// joinList joins the non-empty values with ", "
+func joinList(values []string) string {
+	var result []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return strings.Join(result, ", ")
+}
+
+// dateYear returns the year of an ISO 8601 date like "2021-03-04", or 0
+func dateYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, _ := strconv.Atoi(date[:4])
+	return year
+}
+
+// looseString is a JSON value written as either a string or a number, like ComicBookInfo issue numbers
+type looseString string
+
+func (s *looseString) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = looseString(str)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*s = looseString(number.String())
+	return nil
+}
+
+// looseNumber is a number that is read like looseString, but written as a JSON number when it is one
+type looseNumber string
+
+func (n *looseNumber) UnmarshalJSON(data []byte) error {
+	return (*looseString)(n).UnmarshalJSON(data)
+}
+
+func (n looseNumber) MarshalJSON() ([]byte, error) {
+	if _, err := strconv.ParseFloat(string(n), 64); err == nil {
+		return []byte(n), nil
+	}
+	return json.Marshal(string(n))
+}
+
+// comicBookInfoKey is the key of the metadata in the ComicBookInfo JSON
+const comicBookInfoKey = "ComicBookInfo/1.0"
+
+// comicBookInfo is the ComicBookInfo metadata, stored as JSON in the zip comment
+type comicBookInfo struct {
+	Series           string                `json:"series,omitempty"`
+	Title            string                `json:"title,omitempty"`
+	Publisher        string                `json:"publisher,omitempty"`
+	PublicationMonth looseNumber           `json:"publicationMonth,omitempty"`
+	PublicationYear  looseNumber           `json:"publicationYear,omitempty"`
+	Issue            looseString           `json:"issue,omitempty"`
+	NumberOfIssues   looseNumber           `json:"numberOfIssues,omitempty"`
+	Volume           looseNumber           `json:"volume,omitempty"`
+	NumberOfVolumes  looseNumber           `json:"numberOfVolumes,omitempty"`
+	Rating           looseNumber           `json:"rating,omitempty"`
+	Genre            string                `json:"genre,omitempty"`
+	Language         string                `json:"language,omitempty"`
+	Country          string                `json:"country,omitempty"`
+	Credits          []comicBookInfoCredit `json:"credits,omitempty"`
+	Tags             []string              `json:"tags,omitempty"`
+	Comments         string                `json:"comments,omitempty"`
+}
+
+type comicBookInfoCredit struct {
+	Person  string `json:"person"`
+	Role    string `json:"role"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// parseComicBookInfo parses ComicBookInfo JSON from a zip comment
+func parseComicBookInfo(data []byte) (ComicInfo, error) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return ComicInfo{}, err
+	}
+	raw, ok := envelope[comicBookInfoKey]
+	if !ok {
+		return ComicInfo{}, fmt.Errorf("no %s in the JSON", comicBookInfoKey)
+	}
+	var cbi comicBookInfo
+	if err := json.Unmarshal(raw, &cbi); err != nil {
+		return ComicInfo{}, err
+	}
+
+	info := ComicInfo{
+		Title:     cbi.Title,
+		Series:    cbi.Series,
+		Number:    string(cbi.Issue),
+		Volume:    string(cbi.Volume),
+		Publisher: cbi.Publisher,
+		Genre:     cbi.Genre,
+		Tags:      strings.Join(cbi.Tags, ", "),
+		Summary:   cbi.Comments,
+	}
+	info.Count, _ = strconv.Atoi(string(cbi.NumberOfIssues))
+	info.Year, _ = strconv.Atoi(string(cbi.PublicationYear))
+	if month, err := strconv.Atoi(string(cbi.PublicationMonth)); err == nil && month >= 1 && month <= 12 {
+		info.Month = month
+	}
+	info.LanguageISO = languageCode(cbi.Language)
+	for _, credit := range cbi.Credits {
+		addCredit(&info, credit.Role, credit.Person)
+	}
+	return info, nil
+}
+
+// creditRoles are the ComicBookInfo and MetronInfo credit roles of the ComicInfo creator fields, in the order they
+// are written
+var creditRoles = []struct {
+	Role  string
+	Field func(c *ComicInfo) *string
+}{
+	{"Writer", func(c *ComicInfo) *string { return &c.Writer }},
+	{"Penciller", func(c *ComicInfo) *string { return &c.Penciller }},
+	{"Inker", func(c *ComicInfo) *string { return &c.Inker }},
+	{"Colorist", func(c *ComicInfo) *string { return &c.Colorist }},
+	{"Letterer", func(c *ComicInfo) *string { return &c.Letterer }},
+	{"Cover", func(c *ComicInfo) *string { return &c.CoverArtist }},
+	{"Editor", func(c *ComicInfo) *string { return &c.Editor }},
+	{"Translator", func(c *ComicInfo) *string { return &c.Translator }},
+}
+
+// comicBookInfoJSON returns the ComicBookInfo JSON for a zip comment, the counterpart of parseComicBookInfo
+func comicBookInfoJSON(info ComicInfo, modified time.Time) ([]byte, error) {
+	cbi := comicBookInfo{
+		Series:    info.Series,
+		Title:     info.Title,
+		Publisher: info.Publisher,
+		Issue:     looseString(info.Number),
+		Volume:    looseNumber(info.Volume),
+		Genre:     info.Genre,
+		Language:  languageName(info.LanguageISO),
+		Tags:      splitList(info.Tags),
+		Comments:  info.Summary,
+	}
+	if info.Year > 0 {
+		cbi.PublicationYear = looseNumber(strconv.Itoa(info.Year))
+	}
+	if info.Month >= 1 && info.Month <= 12 {
+		cbi.PublicationMonth = looseNumber(strconv.Itoa(info.Month))
+	}
+	if info.Count > 0 {
+		cbi.NumberOfIssues = looseNumber(strconv.Itoa(info.Count))
+	}
+	for _, role := range creditRoles {
+		for _, person := range splitList(*role.Field(&info)) {
+			cbi.Credits = append(cbi.Credits, comicBookInfoCredit{Person: person, Role: role.Role})
+		}
+	}
+
+	return json.Marshal(struct {
+		AppID        string        `json:"appID"`
+		LastModified string        `json:"lastModified"`
+		Info         comicBookInfo `json:"ComicBookInfo/1.0"`
+	}{
+		AppID:        "cbztools/" + Version,
+		LastModified: modified.Format("2006-01-02 15:04:05"),
+		Info:         cbi,
+	})
+}
+
+// comicBookInfoLanguages are the languages whose English names are recognized in ComicBookInfo
+var comicBookInfoLanguages = []string{"en", "ja", "ko", "zh", "fr", "de", "es", "it", "pt", "ru", "pl", "nl", "sv",
+	"id", "vi", "th", "ar", "tr", "uk", "cs", "hu"}
+
+// languageName returns the English name of an ISO language code, which ComicBookInfo uses, or the code itself
+func languageName(code string) string {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return code
+	}
+	if name := display.English.Languages().Name(tag); name != "" {
+		return name
+	}
+	return code
+}
+
+// languageCode returns the ISO code of a ComicBookInfo language, which is either a code or an English name.
+// Unknown names give "".
+func languageCode(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) == 2 {
+		return strings.ToLower(name)
+	}
+	for _, code := range comicBookInfoLanguages {
+		if strings.EqualFold(languageName(code), name) {
+			return code
+		}
+	}
+	return ""
+}
+
+// coMet is the CoMet metadata format, a comet.xml in the archive
+type coMet struct {
+	XMLName          xml.Name `xml:"comet"`
+	Title            string   `xml:"title"`
+	Description      string   `xml:"description"`
+	Series           string   `xml:"series"`
+	Issue            string   `xml:"issue"`
+	Volume           string   `xml:"volume"`
+	Publisher        string   `xml:"publisher"`
+	Date             string   `xml:"date"`
+	Genres           []string `xml:"genre"`
+	Language         string   `xml:"language"`
+	Pages            string   `xml:"pages"`
+	Writers          []string `xml:"writer"`
+	Pencillers       []string `xml:"penciller"`
+	Inkers           []string `xml:"inker"`
+	Colorists        []string `xml:"colorist"`
+	Letterers        []string `xml:"letterer"`
+	CoverDesigners   []string `xml:"coverDesigner"`
+	Editors          []string `xml:"editor"`
+	ReadingDirection string   `xml:"readingDirection"`
+}
+
+// parseCoMet parses a CoMet comet.xml
+func parseCoMet(data []byte) (ComicInfo, error) {
+	var comet coMet
+	if err := xml.Unmarshal(data, &comet); err != nil {
+		return ComicInfo{}, err
+	}
+	info := ComicInfo{
+		Title:       strings.TrimSpace(comet.Title),
+		Series:      strings.TrimSpace(comet.Series),
+		Number:      strings.TrimSpace(comet.Issue),
+		Volume:      strings.TrimSpace(comet.Volume),
+		Summary:     strings.TrimSpace(comet.Description),
+		Year:        dateYear(strings.TrimSpace(comet.Date)),
+		Writer:      joinList(comet.Writers),
+		Penciller:   joinList(comet.Pencillers),
+		Inker:       joinList(comet.Inkers),
+		Colorist:    joinList(comet.Colorists),
+		Letterer:    joinList(comet.Letterers),
+		CoverArtist: joinList(comet.CoverDesigners),
+		Editor:      joinList(comet.Editors),
+		Publisher:   strings.TrimSpace(comet.Publisher),
+		Genre:       joinList(comet.Genres),
+		LanguageISO: strings.TrimSpace(comet.Language),
+	}
+	info.PageCount, _ = strconv.Atoi(strings.TrimSpace(comet.Pages))
+	if strings.TrimSpace(comet.ReadingDirection) == "rtl" {
+		info.Manga = mangaRightToLeft
+	}
+	return info, nil
+}
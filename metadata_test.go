@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetadataFilesResolve(t *testing.T) {
+	comicInfo := func(series string) []byte {
+		return []byte("<ComicInfo><Series>" + series + "</Series></ComicInfo>")
+	}
+	comicBookInfo := `{"appID":"test","ComicBookInfo/1.0":{"series":"CBI","issue":3}}`
+
+	testCases := []struct {
+		description string
+		entries     []string
+		contents    map[string][]byte
+		comment     string
+		series      string
+		source      string
+	}{
+		{
+			description: "root ComicInfo wins over nested",
+			entries:     []string{"extra/ComicInfo.xml", "comicinfo.XML"},
+			contents:    map[string][]byte{"extra/ComicInfo.xml": comicInfo("Nested"), "comicinfo.XML": comicInfo("Root")},
+			series:      "Root",
+			source:      metaSourceComicInfo,
+		},
+		{
+			description: "nested ComicInfo",
+			entries:     []string{"a/b/ComicInfo.xml", "a/ComicInfo.xml"},
+			contents:    map[string][]byte{"a/b/ComicInfo.xml": comicInfo("Deeper"), "a/ComicInfo.xml": comicInfo("Shallower")},
+			series:      "Shallower",
+			source:      metaSourceComicInfo,
+		},
+		{
+			description: "names merely containing .xml are ignored",
+			entries:     []string{"foo.xml.jpg", "other.xml"},
+			contents:    map[string][]byte{"foo.xml.jpg": comicInfo("Page"), "other.xml": comicInfo("Other")},
+			source:      metaSourceNone,
+		},
+		{
+			description: "ComicBookInfo in the zip comment",
+			entries:     []string{"CoMet.xml"},
+			contents:    map[string][]byte{"CoMet.xml": []byte("<comet><series>CoMet</series></comet>")},
+			comment:     comicBookInfo,
+			series:      "CBI",
+			source:      metaSourceComicBookInfo,
+		},
+		{
+			description: "broken ComicInfo falls back",
+			entries:     []string{"ComicInfo.xml", "MetronInfo.xml"},
+			contents:    map[string][]byte{"ComicInfo.xml": []byte("<ComicInfo><Series>"), "MetronInfo.xml": []byte("<MetronInfo><Series><Name>Metron</Name></Series></MetronInfo>")},
+			comment:     "Not JSON",
+			series:      "Metron",
+			source:      metaSourceMetronInfo,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			meta := metadataFiles{Comment: tc.comment}
+			for _, name := range tc.entries {
+				data := tc.contents[name]
+				if err := meta.offer(name, func() ([]byte, error) { return data, nil }); err != nil {
+					t.Fatal(err)
+				}
+			}
+			info, source := meta.resolve()
+			if info.Series != tc.series || source != tc.source {
+				t.Errorf("Expected series %q from %s, got %q from %s", tc.series, tc.source, info.Series, source)
+			}
+		})
+	}
+}
+
+func TestParseComicBookInfo(t *testing.T) {
+	data := []byte(`{"appID":"ComicTagger/1.0","lastModified":"2020-01-01 00:00:00","ComicBookInfo/1.0":{
+		"series":"Series","title":"Title","publisher":"Pub","publicationMonth":"4","publicationYear":2020,"issue":"12.5","numberOfIssues":20,
+		"volume":2,"genre":"Action","language":"EN","tags":["Isekai","Magic"],"comments":"Summary",
+		"credits":[{"person":"A","role":"Writer","primary":true},{"person":"B","role":"Artist"},{"person":"C","role":"Cover"},{"person":"D","role":"Unknown"}]}}`)
+	info, err := parseComicBookInfo(data)
+	if err != nil {
+		t.Fatalf("Failed to parse ComicBookInfo: %v", err)
+	}
+	expected := ComicInfo{Title: "Title", Series: "Series", Number: "12.5", Count: 20, Volume: "2", Summary: "Summary", Year: 2020,
+		Month: 4, Writer: "A", Penciller: "B", CoverArtist: "C", Publisher: "Pub", Genre: "Action", Tags: "Isekai, Magic", LanguageISO: "en"}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+
+	if _, err := parseComicBookInfo([]byte(`{"other":{}}`)); err == nil {
+		t.Errorf("Expected an error for JSON without ComicBookInfo")
+	}
+}
+
+func TestParseCoMet(t *testing.T) {
+	data := []byte(`<?xml version="1.0"?>
+<comet xmlns="http://www.denvog.com/comet/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <title>Title</title><description>Summary</description><series>Series</series><issue>4</issue><volume>1</volume>
+  <publisher>Pub</publisher><date>2019-05-01</date><genre>Action</genre><genre>Drama</genre><language>ja</language>
+  <pages>24</pages><writer>A</writer><writer>B</writer><penciller>C</penciller><coverDesigner>D</coverDesigner>
+  <readingDirection>rtl</readingDirection>
+</comet>`)
+	info, err := parseCoMet(data)
+	if err != nil {
+		t.Fatalf("Failed to parse CoMet: %v", err)
+	}
+	expected := ComicInfo{Title: "Title", Series: "Series", Number: "4", Volume: "1", Summary: "Summary", Year: 2019,
+		Writer: "A, B", Penciller: "C", CoverArtist: "D", Publisher: "Pub", Genre: "Action, Drama", PageCount: 24,
+		LanguageISO: "ja", Manga: mangaRightToLeft}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+}
+
+func TestReadCBZMetadata(t *testing.T) {
+	page, _ := testPNGPage(t, "page.png", 10, 20).ReadAll()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{"foo.xml.jpg": page, "nested/ComicInfo.xml": []byte("<ComicInfo><Series>Nested</Series></ComicInfo>")} {
+		w, _ := zw.Create(name)
+		w.Write(data)
+	}
+	zw.SetComment(`{"ComicBookInfo/1.0":{"series":"Comment"}}`)
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := readBook(path)
+	if err != nil {
+		t.Fatalf("Failed to read CBZ: %v", err)
+	}
+	defer book.Close()
+	if book.Info.Series != "Nested" || book.InfoSource != metaSourceComicInfo {
+		t.Errorf("Expected the nested ComicInfo.xml, got %q from %s", book.Info.Series, book.InfoSource)
+	}
+	if len(book.Pages) != 1 || book.Pages[0].Name != "foo.xml.jpg" {
+		t.Errorf("Expected foo.xml.jpg as a page, got %v", book.Pages)
+	}
+}
+
+func TestParseExtraMetadata(t *testing.T) {
+	if formats, err := parseExtraMetadata(" ComicBookInfo, "); err != nil || !reflect.DeepEqual(formats, []string{extraComicBookInfo}) {
+		t.Errorf("Expected [%s], got %v, %v", extraComicBookInfo, formats, err)
+	}
+	if formats, err := parseExtraMetadata(""); err != nil || formats != nil {
+		t.Errorf("Expected no formats, got %v, %v", formats, err)
+	}
+	if _, err := parseExtraMetadata("comicbookinfo,xmp"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestLanguageCode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"English", "en"},
+		{"japanese", "ja"},
+		{"FR", "fr"},
+		{"Klingon", ""},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		if result := languageCode(tc.name); result != tc.expected {
+			t.Errorf("Expected languageCode(%q) to be %q, got %q", tc.name, tc.expected, result)
+		}
+	}
+	if name := languageName("ja"); name != "Japanese" {
+		t.Errorf("Expected Japanese, got %q", name)
+	}
+}
+
+func TestComicBookInfoRoundTrip(t *testing.T) {
+	info := ComicInfo{Title: "Title", Series: "Series", Number: "12.5", Count: 20, Volume: "2", Summary: "Summary", Year: 2020,
+		Month: 11, Tags: "Isekai, Magic", Writer: "A, B", Penciller: "C", Inker: "D", Colorist: "E", Letterer: "F", CoverArtist: "G", Editor: "H", Translator: "I",
+		Publisher: "Pub", Genre: "Action", LanguageISO: "ja"}
+	data, err := comicBookInfoJSON(info, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to write ComicBookInfo: %v", err)
+	}
+	for _, expected := range []string{`"lastModified":"2024-05-06 07:08:09"`, `"issue":"12.5"`, `"volume":2`, `"publicationYear":2020`, `"publicationMonth":11`, `"tags":["Isekai","Magic"]`, `"language":"Japanese"`, `{"person":"B","role":"Writer"}`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected the JSON to contain %s, got %s", expected, data)
+		}
+	}
+
+	result, err := parseComicBookInfo(data)
+	if err != nil {
+		t.Fatalf("Failed to parse ComicBookInfo: %v", err)
+	}
+	if result != info {
+		t.Errorf("Expected %+v to round trip, got %+v", info, result)
+	}
+}
+
+func TestWriteCBZComicBookInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	book := testBook(t, ComicInfo{Series: "Series", Number: "1"}, 1)
+	book.ExtraMetadata = []string{extraComicBookInfo}
+	if err := writeBook(book, path, formatCBZ); err != nil {
+		t.Fatalf("Failed to write CBZ: %v", err)
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	info, err := parseComicBookInfo([]byte(r.Comment))
+	if err != nil || info.Series != "Series" || info.Number != "1" {
+		t.Errorf("Expected ComicBookInfo in the zip comment, got %+v, %v (%q)", info, err, r.Comment)
+	}
+}
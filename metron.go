@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bookID identifies a book in an online database, like Metron or Comic Vine
+type bookID struct {
+	Source  string `json:"source"`
+	ID      string `json:"id"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+func (id bookID) String() string {
+	if id.Primary {
+		return fmt.Sprintf("%s %s (primary)", id.Source, id.ID)
+	}
+	return fmt.Sprintf("%s %s", id.Source, id.ID)
+}
+
+// metronInfo is the Metron project's MetronInfo.xml, as far as it maps to ComicInfo and database IDs
+type metronInfo struct {
+	XMLName    xml.Name         `xml:"MetronInfo"`
+	IDs        []metronID       `xml:"IDS>ID,omitempty"`
+	Publisher  *metronPublisher `xml:"Publisher,omitempty"`
+	Series     metronSeries     `xml:"Series"`
+	Number     string           `xml:"Number,omitempty"`
+	Stories    []string         `xml:"Stories>Story,omitempty"`
+	Summary    string           `xml:"Summary,omitempty"`
+	CoverDate  string           `xml:"CoverDate,omitempty"`
+	PageCount  string           `xml:"PageCount,omitempty"`
+	Notes      string           `xml:"Notes,omitempty"`
+	Genres     []string         `xml:"Genres>Genre,omitempty"`
+	Tags       []string         `xml:"Tags>Tag,omitempty"`
+	Arcs       []metronArc      `xml:"Arcs>Arc,omitempty"`
+	Characters []string         `xml:"Characters>Character,omitempty"`
+	Teams      []string         `xml:"Teams>Team,omitempty"`
+	Locations  []string         `xml:"Locations>Location,omitempty"`
+	GTIN       *metronGTIN      `xml:"GTIN,omitempty"`
+	AgeRating  string           `xml:"AgeRating,omitempty"`
+	URLs       []metronURL      `xml:"URLs>URL,omitempty"`
+	Credits    []metronCredit   `xml:"Credits>Credit,omitempty"`
+}
+
+type metronID struct {
+	Source  string `xml:"source,attr"`
+	Primary bool   `xml:"primary,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type metronPublisher struct {
+	Name    string `xml:"Name"`
+	Imprint string `xml:"Imprint,omitempty"`
+}
+
+type metronSeries struct {
+	Lang   string `xml:"lang,attr,omitempty"`
+	Name   string `xml:"Name"`
+	Volume string `xml:"Volume,omitempty"`
+}
+
+type metronArc struct {
+	Name   string `xml:"Name"`
+	Number string `xml:"Number,omitempty"`
+}
+
+type metronGTIN struct {
+	ISBN string `xml:"ISBN,omitempty"`
+	UPC  string `xml:"UPC,omitempty"`
+}
+
+type metronURL struct {
+	Primary bool   `xml:"primary,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type metronCredit struct {
+	Creator string   `xml:"Creator"`
+	Roles   []string `xml:"Roles>Role"`
+}
+
+// metronAgeRatings maps MetronInfo age ratings to ComicInfo ones
+var metronAgeRatings = map[string]string{
+	"Unknown":   "Unknown",
+	"Everyone":  "Everyone",
+	"Teen":      "Teen",
+	"Teen Plus": "Teen",
+	"Mature":    "Mature 17+",
+	"Explicit":  "X18+",
+	"Adult":     "Adults Only 18+",
+}
+
+// comicInfoAgeRatings maps ComicInfo age ratings to MetronInfo ones
+var comicInfoAgeRatings = map[string]string{
+	"Unknown":         "Unknown",
+	"Rating Pending":  "Unknown",
+	"Early Childhood": "Everyone",
+	"Everyone":        "Everyone",
+	"Everyone 10+":    "Everyone",
+	"G":               "Everyone",
+	"Kids to Adults":  "Everyone",
+	"PG":              "Teen",
+	"Teen":            "Teen",
+	"M":               "Mature",
+	"MA15+":           "Mature",
+	"Mature 17+":      "Mature",
+	"R18+":            "Adult",
+	"Adults Only 18+": "Adult",
+	"X18+":            "Explicit",
+}
+
+// parseMetronInfo parses a MetronInfo.xml into ComicInfo. Arc names and numbers become StoryArc and StoryArcNumber,
+// URLs become Web. Database IDs have no place in ComicInfo, see metronIDs.
+func parseMetronInfo(data []byte) (ComicInfo, error) {
+	var metron metronInfo
+	if err := xml.Unmarshal(data, &metron); err != nil {
+		return ComicInfo{}, err
+	}
+	info := ComicInfo{
+		Title:       joinList(metron.Stories),
+		Series:      strings.TrimSpace(metron.Series.Name),
+		Number:      strings.TrimSpace(metron.Number),
+		Volume:      strings.TrimSpace(metron.Series.Volume),
+		Summary:     strings.TrimSpace(metron.Summary),
+		Notes:       strings.TrimSpace(metron.Notes),
+		Genre:       joinList(metron.Genres),
+		Tags:        joinList(metron.Tags),
+		LanguageISO: strings.TrimSpace(metron.Series.Lang),
+		Characters:  joinList(metron.Characters),
+		Teams:       joinList(metron.Teams),
+		Locations:   joinList(metron.Locations),
+		AgeRating:   metronAgeRatings[strings.TrimSpace(metron.AgeRating)],
+	}
+	if metron.Publisher != nil {
+		info.Publisher = strings.TrimSpace(metron.Publisher.Name)
+		info.Imprint = strings.TrimSpace(metron.Publisher.Imprint)
+	}
+	if date, err := time.Parse("2006-01-02", strings.TrimSpace(metron.CoverDate)); err == nil {
+		info.Year, info.Month, info.Day = date.Year(), int(date.Month()), date.Day()
+	}
+	info.PageCount, _ = strconv.Atoi(strings.TrimSpace(metron.PageCount))
+
+	var arcs, numbers []string
+	for _, arc := range metron.Arcs {
+		if name := strings.TrimSpace(arc.Name); name != "" {
+			arcs = append(arcs, name)
+			numbers = append(numbers, strings.TrimSpace(arc.Number))
+		}
+	}
+	info.StoryArc = strings.Join(arcs, ", ")
+	// StoryArcNumber lines up with StoryArc, so it's only set if every arc has a number
+	if joined := joinList(numbers); len(splitList(joined)) == len(arcs) {
+		info.StoryArcNumber = joined
+	}
+
+	if metron.GTIN != nil {
+		info.GTIN = strings.TrimSpace(metron.GTIN.ISBN)
+		if info.GTIN == "" {
+			info.GTIN = strings.TrimSpace(metron.GTIN.UPC)
+		}
+	}
+	var urls []string
+	for _, url := range metron.URLs {
+		if url := strings.TrimSpace(url.Value); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	info.Web = strings.Join(urls, " ")
+	for _, credit := range metron.Credits {
+		for _, role := range credit.Roles {
+			addCredit(&info, role, credit.Creator)
+		}
+	}
+	return info, nil
+}
+
+// metronIDs returns the database IDs of a MetronInfo.xml, nil if it can't be parsed
+func metronIDs(data []byte) []bookID {
+	var metron metronInfo
+	if err := xml.Unmarshal(data, &metron); err != nil {
+		return nil
+	}
+	var ids []bookID
+	for _, id := range metron.IDs {
+		if value := strings.TrimSpace(id.Value); value != "" {
+			ids = append(ids, bookID{Source: strings.TrimSpace(id.Source), ID: value, Primary: id.Primary})
+		}
+	}
+	return ids
+}
+
+// metronInfoXML returns the MetronInfo.xml for the book's ComicInfo and database IDs, the counterpart of parseMetronInfo
+func metronInfoXML(book *Book) ([]byte, error) {
+	info := book.Info
+	metron := metronInfo{
+		Series:     metronSeries{Lang: info.LanguageISO, Name: info.Series, Volume: info.Volume},
+		Number:     info.Number,
+		Summary:    info.Summary,
+		Notes:      info.Notes,
+		Genres:     splitList(info.Genre),
+		Tags:       splitList(info.Tags),
+		Characters: splitList(info.Characters),
+		Teams:      splitList(info.Teams),
+		Locations:  splitList(info.Locations),
+		AgeRating:  comicInfoAgeRatings[info.AgeRating],
+	}
+	for _, id := range book.IDs {
+		metron.IDs = append(metron.IDs, metronID{Source: id.Source, Primary: id.Primary, Value: id.ID})
+	}
+	if info.Publisher != "" || info.Imprint != "" {
+		metron.Publisher = &metronPublisher{Name: info.Publisher, Imprint: info.Imprint}
+	}
+	if info.Title != "" {
+		metron.Stories = []string{info.Title}
+	}
+	if info.Year > 0 {
+		month, day := info.Month, info.Day
+		if month < 1 || month > 12 {
+			month = 1
+		}
+		if day < 1 || day > 31 {
+			day = 1
+		}
+		metron.CoverDate = fmt.Sprintf("%04d-%02d-%02d", info.Year, month, day)
+	}
+	if info.PageCount > 0 {
+		metron.PageCount = strconv.Itoa(info.PageCount)
+	}
+
+	arcs, numbers := splitList(info.StoryArc), splitList(info.StoryArcNumber)
+	for i, name := range arcs {
+		arc := metronArc{Name: name}
+		if len(numbers) == len(arcs) {
+			arc.Number = numbers[i]
+		}
+		metron.Arcs = append(metron.Arcs, arc)
+	}
+	// A 12 digit GTIN is a UPC, anything else is taken as an ISBN
+	if gtin := strings.TrimSpace(info.GTIN); len(gtin) == 12 {
+		metron.GTIN = &metronGTIN{UPC: gtin}
+	} else if gtin != "" {
+		metron.GTIN = &metronGTIN{ISBN: gtin}
+	}
+	for i, url := range strings.Fields(info.Web) {
+		metron.URLs = append(metron.URLs, metronURL{Primary: i == 0, Value: url})
+	}
+
+	// One credit per person, with all their roles
+	credits := make(map[string]int)
+	for _, role := range creditRoles {
+		for _, person := range splitList(*role.Field(&info)) {
+			i, ok := credits[person]
+			if !ok {
+				i = len(metron.Credits)
+				credits[person] = i
+				metron.Credits = append(metron.Credits, metronCredit{Creator: person})
+			}
+			metron.Credits[i].Roles = append(metron.Credits[i].Roles, role.Role)
+		}
+	}
+
+	xmlBytes, err := xml.MarshalIndent(metron, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), xmlBytes...), nil
+}
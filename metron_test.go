@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/zip"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMetronInfo = `<?xml version="1.0" encoding="UTF-8"?>
+<MetronInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <IDS>
+    <ID source="Metron" primary="true">290431</ID>
+    <ID source="Comic Vine">140529</ID>
+  </IDS>
+  <Publisher id="1"><Name>Pub</Name><Imprint id="2">Imprint</Imprint></Publisher>
+  <Series lang="en" id="3"><Name>Series</Name><Volume>3</Volume></Series>
+  <Number>7</Number>
+  <Stories><Story>First</Story><Story>Second</Story></Stories>
+  <Summary>Summary</Summary>
+  <CoverDate>2021-02-03</CoverDate>
+  <PageCount>30</PageCount>
+  <Notes>Notes</Notes>
+  <Genres><Genre id="1">Horror</Genre></Genres>
+  <Tags><Tag id="2">Zombies</Tag></Tags>
+  <Arcs>
+    <Arc id="4"><Name>Big Event</Name><Number>2</Number></Arc>
+    <Arc id="5"><Name>Small Event</Name><Number>1</Number></Arc>
+  </Arcs>
+  <Characters><Character id="6">Hero</Character><Character id="7">Villain</Character></Characters>
+  <Teams><Team id="8">Team</Team></Teams>
+  <Locations><Location id="9">City</Location></Locations>
+  <GTIN><UPC>123456789012</UPC></GTIN>
+  <AgeRating>Teen Plus</AgeRating>
+  <URLs><URL primary="true">https://metron.cloud/issue/series-7/</URL><URL>https://example.com/7</URL></URLs>
+  <Credits>
+    <Credit><Creator id="10">A</Creator><Roles><Role id="1">Writer</Role><Role id="2">Artist</Role></Roles></Credit>
+    <Credit><Creator id="11">B</Creator><Roles><Role id="3">Colorist</Role><Role id="4">Editor In Chief</Role></Roles></Credit>
+  </Credits>
+</MetronInfo>`
+
+func TestParseMetronInfo(t *testing.T) {
+	info, err := parseMetronInfo([]byte(testMetronInfo))
+	if err != nil {
+		t.Fatalf("Failed to parse MetronInfo: %v", err)
+	}
+	expected := ComicInfo{Title: "First, Second", Series: "Series", Number: "7", Volume: "3", Summary: "Summary", Notes: "Notes",
+		Year: 2021, Month: 2, Day: 3, Writer: "A", Penciller: "A", Colorist: "B", Editor: "B", Publisher: "Pub", Imprint: "Imprint",
+		Genre: "Horror", Tags: "Zombies", Web: "https://metron.cloud/issue/series-7/ https://example.com/7", PageCount: 30,
+		LanguageISO: "en", Characters: "Hero, Villain", Teams: "Team", Locations: "City", StoryArc: "Big Event, Small Event",
+		StoryArcNumber: "2, 1", AgeRating: "Teen", GTIN: "123456789012"}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+
+	ids := metronIDs([]byte(testMetronInfo))
+	expectedIDs := []bookID{{Source: "Metron", ID: "290431", Primary: true}, {Source: "Comic Vine", ID: "140529"}}
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("Expected IDs %v, got %v", expectedIDs, ids)
+	}
+}
+
+func TestParseMetronInfoArcNumbers(t *testing.T) {
+	info, err := parseMetronInfo([]byte(`<MetronInfo><Series><Name>S</Name></Series><Arcs><Arc><Name>A</Name><Number>1</Number></Arc><Arc><Name>B</Name></Arc></Arcs></MetronInfo>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.StoryArc != "A, B" || info.StoryArcNumber != "" {
+		t.Errorf("Expected arcs without numbers when some are missing, got %q and %q", info.StoryArc, info.StoryArcNumber)
+	}
+}
+
+func TestMetronInfoRoundTrip(t *testing.T) {
+	info, _ := parseMetronInfo([]byte(testMetronInfo))
+	book := &Book{Info: info, IDs: metronIDs([]byte(testMetronInfo))}
+	data, err := metronInfoXML(book)
+	if err != nil {
+		t.Fatalf("Failed to write MetronInfo: %v", err)
+	}
+	for _, expected := range []string{`<ID source="Metron" primary="true">290431</ID>`, `<CoverDate>2021-02-03</CoverDate>`,
+		`<UPC>123456789012</UPC>`, `<AgeRating>Teen</AgeRating>`, `<Role>Writer</Role>`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected MetronInfo to contain %s, got\n%s", expected, data)
+		}
+	}
+
+	result, err := parseMetronInfo(data)
+	if err != nil {
+		t.Fatalf("Failed to parse the written MetronInfo: %v", err)
+	}
+	// Stories are written as the single title, and roles are mapped to ComicInfo names
+	info.Title = "First, Second"
+	if result != info {
+		t.Errorf("Expected %+v to round trip, got %+v", info, result)
+	}
+	if ids := metronIDs(data); !reflect.DeepEqual(ids, book.IDs) {
+		t.Errorf("Expected IDs %v to round trip, got %v", book.IDs, ids)
+	}
+}
+
+func TestMetronInfoFillsComicInfo(t *testing.T) {
+	meta := metadataFiles{files: map[string]metadataFile{
+		metaSourceComicInfo:  {Name: "ComicInfo.xml", Data: []byte("<ComicInfo><Series>From ComicInfo</Series></ComicInfo>")},
+		metaSourceMetronInfo: {Name: "MetronInfo.xml", Data: []byte(testMetronInfo)},
+	}}
+	info, source := meta.resolve()
+	if source != metaSourceComicInfo || info.Series != "From ComicInfo" || info.StoryArc != "Big Event, Small Event" {
+		t.Errorf("Expected ComicInfo with the gaps filled from MetronInfo, got %+v from %s", info, source)
+	}
+	if ids := meta.ids(); len(ids) != 2 {
+		t.Errorf("Expected the MetronInfo IDs, got %v", ids)
+	}
+}
+
+func TestWriteMetronInfo(t *testing.T) {
+	for _, format := range []string{formatCBZ, formatCBT} {
+		path := filepath.Join(t.TempDir(), "book."+format)
+		book := testBook(t, ComicInfo{Series: "Series", Number: "1", StoryArc: "Arc"}, 1)
+		book.IDs = []bookID{{Source: "Metron", ID: "1", Primary: true}}
+		book.ExtraMetadata = []string{extraMetronInfo}
+		if err := writeBook(book, path, format); err != nil {
+			t.Fatalf("Failed to write %s: %v", format, err)
+		}
+
+		result, err := readBook(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", format, err)
+		}
+		if result.InfoSource != metaSourceComicInfo || result.Info.StoryArc != "Arc" || !reflect.DeepEqual(result.IDs, book.IDs) {
+			t.Errorf("Expected ComicInfo and the MetronInfo IDs in %s, got %+v from %s with %v", format, result.Info, result.InfoSource, result.IDs)
+		}
+		result.Close()
+	}
+
+	// Without the extra format, no MetronInfo.xml is written
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	writeBook(testBook(t, ComicInfo{Series: "Series"}, 1), path, formatCBZ)
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name == "MetronInfo.xml" {
+			t.Errorf("Expected no MetronInfo.xml by default")
+		}
+	}
+}
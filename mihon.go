@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the files Mihon's (formerly Tachiyomi's) local source reads from a series folder
+const (
+	mihonDetailsName = "details.json"
+	mihonCoverName   = "cover.jpg"
+)
+
+// mihonFormats are the output formats Mihon's local source can read
+var mihonFormats = []string{formatCBZ, formatEPUB}
+
+// mihonDetails is the details.json of a series in Mihon's local source
+type mihonDetails struct {
+	Title       string      `json:"title,omitempty"`
+	Author      string      `json:"author,omitempty"`
+	Artist      string      `json:"artist,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Genre       []string    `json:"genre,omitempty"`
+	Status      looseString `json:"status,omitempty"` // 0 unknown, 1 ongoing, 2 completed, 3 licensed, 4 publishing finished, 5 cancelled, 6 on hiatus
+}
+
+// parseMihonDetails parses a details.json
+func parseMihonDetails(data []byte) (mihonDetails, error) {
+	var details mihonDetails
+	err := json.Unmarshal(data, &details)
+	return details, err
+}
+
+// readMihonDetails reads the details.json in dir
+func readMihonDetails(dir string) (mihonDetails, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, mihonDetailsName))
+	if err != nil {
+		return mihonDetails{}, false
+	}
+	details, err := parseMihonDetails(data)
+	return details, err == nil
+}
+
+// comicInfo maps the series details to ComicInfo. The title is the series name, the status has no ComicInfo field.
+func (d mihonDetails) comicInfo() ComicInfo {
+	return ComicInfo{
+		Series:    strings.TrimSpace(d.Title),
+		Writer:    strings.TrimSpace(d.Author),
+		Penciller: strings.TrimSpace(d.Artist),
+		Summary:   strings.TrimSpace(d.Description),
+		Genre:     joinList(d.Genre),
+	}
+}
+
+// ended reports whether the status is one of the ones where no more chapters come out
+func (d mihonDetails) ended() bool {
+	switch strings.TrimSpace(string(d.Status)) {
+	case "2", "4", "5":
+		return true
+	}
+	return false
+}
+
+// newMihonDetails maps ComicInfo to the series details, keeping the status of the original details
+func newMihonDetails(info ComicInfo, status looseString) mihonDetails {
+	details := mihonDetails{
+		Title:       info.Series,
+		Author:      info.Writer,
+		Artist:      info.Penciller,
+		Description: info.Summary,
+		Genre:       splitList(info.Genre),
+		Status:      status,
+	}
+	if details.Title == "" {
+		details.Title = info.Title
+	}
+	if details.Status == "" {
+		details.Status = "0"
+	}
+	return details
+}
+
+// writeMihonFiles writes details.json and, from the front cover, cover.jpg into a series folder.
+// Files that are already there are kept, so merging more volumes into a series doesn't replace its cover.
+// It returns the names of the files it wrote.
+func writeMihonFiles(book *Book, dir string, status looseString) ([]string, error) {
+	var written []string
+	detailsPath := filepath.Join(dir, mihonDetailsName)
+	if _, err := os.Stat(detailsPath); os.IsNotExist(err) {
+		data, err := json.MarshalIndent(newMihonDetails(book.Info, status), "", "  ")
+		if err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(detailsPath, append(data, '\n'), 0o644); err != nil {
+			return written, err
+		}
+		written = append(written, detailsPath)
+	}
+
+	coverPath := filepath.Join(dir, mihonCoverName)
+	if _, err := os.Stat(coverPath); os.IsNotExist(err) && len(book.Pages) > 0 {
+		page, err := book.frontCover()
+		if err != nil {
+			return written, err
+		}
+		cover, err := coverImage(page, 0)
+		if err != nil {
+			return written, fmt.Errorf("cover %s: %w", page.Name, err)
+		}
+		if err := os.WriteFile(coverPath, cover, 0o644); err != nil {
+			return written, err
+		}
+		written = append(written, coverPath)
+	}
+	return written, nil
+}
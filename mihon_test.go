@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMihonDetails(t *testing.T) {
+	testCases := []struct {
+		description string
+		data        string
+		expected    ComicInfo
+		status      looseString
+	}{
+		{
+			description: "status as a string",
+			data: `{"title":"Series","author":"A","artist":"B","description":"Summary","genre":["Action","Drama"],"status":"1",
+				"_status values":["0 = Unknown","1 = Ongoing"]}`,
+			expected: ComicInfo{Series: "Series", Writer: "A", Penciller: "B", Summary: "Summary", Genre: "Action, Drama"},
+			status:   "1",
+		},
+		{
+			description: "status as a number",
+			data:        `{"title":"Series","status":2}`,
+			expected:    ComicInfo{Series: "Series"},
+			status:      "2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			details, err := parseMihonDetails([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("Failed to parse details.json: %v", err)
+			}
+			if info := details.comicInfo(); info != tc.expected || details.Status != tc.status {
+				t.Errorf("Expected %+v with status %q, got %+v with status %q", tc.expected, tc.status, info, details.Status)
+			}
+		})
+	}
+}
+
+func TestWriteMihonFiles(t *testing.T) {
+	dir := t.TempDir()
+	book := testBook(t, ComicInfo{Series: "Series", Writer: "A", Genre: "Action, Drama"}, 2)
+	written, err := writeMihonFiles(book, dir, "2")
+	if err != nil || len(written) != 2 {
+		t.Fatalf("Expected details.json and cover.jpg to be written, got %v, %v", written, err)
+	}
+
+	details, ok := readMihonDetails(dir)
+	if !ok || details.Title != "Series" || details.Author != "A" || len(details.Genre) != 2 || details.Status != "2" {
+		t.Errorf("Expected the written details.json to be read back, got %+v", details)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, mihonDetailsName))
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil || raw["status"] != "2" {
+		t.Errorf("Expected the status as a string like Mihon writes it, got %s", data)
+	}
+
+	// Files already in the series folder are kept
+	written, err = writeMihonFiles(testBook(t, ComicInfo{Series: "Other"}, 1), dir, "")
+	if err != nil || len(written) != 0 {
+		t.Errorf("Expected nothing to be written, got %v, %v", written, err)
+	}
+	if details, _ := readMihonDetails(dir); details.Title != "Series" {
+		t.Errorf("Expected details.json to be kept, got %+v", details)
+	}
+}
+
+func TestReadBookMihonDetails(t *testing.T) {
+	dir := t.TempDir()
+	chapter := filepath.Join(dir, "Ch.001")
+	if err := os.Mkdir(chapter, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := testPNGPage(t, "001.png", 10, 20).ReadAll()
+	os.WriteFile(filepath.Join(chapter, "001.png"), page, 0o644)
+	os.WriteFile(filepath.Join(dir, mihonDetailsName), []byte(`{"title":"Series","author":"A"}`), 0o644)
+
+	book, err := readBook(chapter)
+	if err != nil {
+		t.Fatalf("Failed to read chapter folder: %v", err)
+	}
+	defer book.Close()
+	if book.Info.Series != "Series" || book.Info.Writer != "A" || book.InfoSource != metaSourceMihon {
+		t.Errorf("Expected the series details.json, got %+v from %s", book.Info, book.InfoSource)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/mozillazg/go-unidecode"
+)
+
+// defaultNameTemplate reproduces the original "Series Ch.first-last" output name
+const defaultNameTemplate = `{{sanitize (printf "%s Ch.%s-%s" .Series .FirstChapter .LastChapter)}}`
+
+// illegalPathRegex matches characters that can't appear in a file name on common filesystems
+var illegalPathRegex = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]+`)
+
+// nameData is the metadata available to --name-template
+type nameData struct {
+	Series       string
+	Volume       string
+	FirstChapter string
+	LastChapter  string
+	Year         string
+	Publisher    string
+	Count        int // number of merged chapters
+}
+
+// nameFuncs returns the helper functions available to --name-template.
+// `sanitize` uses the selected sanitize profile.
+func nameFuncs(profile sanitizeProfile) template.FuncMap {
+	return template.FuncMap{
+		"pad":      padNumber,
+		"sanitize": profile.Sanitize,
+		"ascii":    unidecode.Unidecode,
+	}
+}
+
+// newNameData collects the template data from the resolved chapters, which are expected to be sorted.
+// Series, Year and Publisher come from the first chapter, the volume only if all chapters share it.
+func newNameData(chapters []chapterFile) nameData {
+	if len(chapters) == 0 {
+		return nameData{}
+	}
+	first, last := chapters[0], chapters[len(chapters)-1]
+	data := nameData{
+		Series:       first.Info.Series,
+		Volume:       sharedVolume(chapters),
+		FirstChapter: first.Chapter,
+		LastChapter:  last.Chapter,
+		Publisher:    first.Info.Publisher,
+		Count:        len(chapters),
+	}
+	if first.Info.Year > 0 {
+		data.Year = strconv.Itoa(first.Info.Year)
+	}
+	return data
+}
+
+// padNumber zero-pads the integer part of a number like "15.5" to width digits, "015.5" for width 3.
+// Leading zeros are normalized first, so "0015" with width 3 becomes "015". Non-numbers are returned as is.
+func padNumber(width int, value string) string {
+	if !chapterNumberRegex.MatchString(value) {
+		return value
+	}
+	integer, rest, hasRest := strings.Cut(value, ".")
+	n, err := strconv.Atoi(integer)
+	if err != nil {
+		return value
+	}
+	result := fmt.Sprintf("%0*d", width, n)
+	if hasRest {
+		result += "." + rest
+	}
+	return result
+}
+
+// parseNameTemplate parses a --name-template value
+func parseNameTemplate(text string, profile sanitizeProfile) (*template.Template, error) {
+	return template.New("name").Funcs(nameFuncs(profile)).Option("missingkey=error").Parse(text)
+}
+
+// renderName executes the template and makes the result safe to use as a file name of at most maxBytes bytes with
+// the profile's Finalize. For most profiles only path separators and filesystem-illegal characters are removed, other
+// sanitizing is up to the template.
+func renderName(tmpl *template.Template, data nameData, profile sanitizeProfile, maxBytes int) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	name := profile.Finalize(buf.String())
+	if len(name) > maxBytes {
+		// Finalized again, the cut may leave a trailing dot or space
+		name = profile.Finalize(truncateUTF8(name, maxBytes))
+	}
+	return name, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPadNumber(t *testing.T) {
+	testCases := []struct {
+		width       int
+		value       string
+		expected    string
+		description string
+	}{
+		{3, "15", "015", "Integer is padded"},
+		{3, "15.5", "015.5", "Decimal part is kept"},
+		{3, "0015", "015", "Leading zeros are normalized"},
+		{2, "1234", "1234", "Wider numbers are not truncated"},
+		{0, "007", "7", "Zero width strips leading zeros"},
+		{3, "15.5.5", "015.5.5", "Multi-part numbers"},
+		{3, "", "", "Empty value stays empty"},
+		{3, "Extra", "Extra", "Non-numbers are returned as is"},
+	}
+
+	for _, tc := range testCases {
+		result := padNumber(tc.width, tc.value)
+		if result != tc.expected {
+			t.Errorf("Test '%s': Expected pad %d '%s' to be '%s', got '%s'",
+				tc.description, tc.width, tc.value, tc.expected, result)
+		}
+	}
+}
+
+func TestNewNameData(t *testing.T) {
+	chapters := []chapterFile{
+		resolveChapter("a.cbz", ComicInfo{Series: "Series", Number: "1", Volume: "2", Year: 2019, Publisher: "Pub"}),
+		resolveChapter("b.cbz", ComicInfo{Series: "Other", Number: "2", Volume: "3", Year: 2020}),
+		resolveChapter("c.cbz", ComicInfo{Series: "Other", Number: "3.5"}),
+	}
+	data := newNameData(chapters)
+	// Chapters from several volumes have no volume
+	expected := nameData{Series: "Series", FirstChapter: "1", LastChapter: "3.5", Year: "2019", Publisher: "Pub", Count: 3}
+	if data != expected {
+		t.Errorf("Expected %+v, got %+v", expected, data)
+	}
+
+	if newNameData(nil) != (nameData{}) {
+		t.Errorf("Expected empty data without chapters")
+	}
+	if data := newNameData(chapters[:1]); data.Volume != "2" {
+		t.Errorf("Expected the volume of a single chapter, got %q", data.Volume)
+	}
+	if newNameData(chapters[2:]).Year != "" {
+		t.Errorf("Expected empty year when it is not set")
+	}
+}
+
+func TestRenderName(t *testing.T) {
+	data := nameData{Series: "Elf-san wa Yaserarenai", Volume: "1", FirstChapter: "0000", LastChapter: "0047.6", Year: "2018", Publisher: "Pub", Count: 48}
+
+	testCases := []struct {
+		template    string
+		data        nameData
+		expected    string
+		description string
+	}{
+		{defaultNameTemplate, data, "Elf-san_wa_Yaserarenai_Ch_0000-0047_6", "Default template matches the original naming"},
+		{defaultNameTemplate, nameData{Series: "あなた", FirstChapter: "1", LastChapter: "2"}, "anata_Ch_1-2", "Default template transliterates"},
+		{defaultNameTemplate, nameData{}, "Ch_-", "Default template with no data"},
+		{"{{.Series}} v{{pad 2 .Volume}} c{{pad 3 .FirstChapter}}-{{pad 3 .LastChapter}}", data, "Elf-san wa Yaserarenai v01 c000-047.6", "Padding helpers"},
+		{"{{.Series}}{{with .Volume}} Vol.{{.}}{{end}} ({{.Year}})", nameData{Series: "S", Year: "2020"}, "S (2020)", "Optional volume"},
+		{"{{.Publisher}}/{{.Series}}", data, "Pub_Elf-san wa Yaserarenai", "Path separators are replaced"},
+		{`{{.Series}}: "{{.Count}}"?`, data, "Elf-san wa Yaserarenai_ _48_", "Runs of illegal characters are replaced"},
+		{"{{ascii .Series}}", nameData{Series: "Ёлка 漫画"}, "Iolka Man Hua", "ASCII transliteration without other sanitizing"},
+		{"{{.Series}}", nameData{Series: " .. "}, "untitled", "Empty result falls back to untitled"},
+		{"{{sanitize .Series}}", nameData{Series: "a\tb\nc"}, "a_b_c", "Control characters are replaced"},
+	}
+
+	for _, tc := range testCases {
+		tmpl, err := parseNameTemplate(tc.template, sanitizeProfiles[profileASCII])
+		if err != nil {
+			t.Errorf("Test '%s': Unexpected parse error %v", tc.description, err)
+			continue
+		}
+		result, err := renderName(tmpl, tc.data, sanitizeProfiles[profileASCII], maxFilenameBytes)
+		if err != nil {
+			t.Errorf("Test '%s': Unexpected render error %v", tc.description, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Test '%s': Expected '%s', got '%s'", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestNameTemplateErrors(t *testing.T) {
+	profile := sanitizeProfiles[profileASCII]
+	if _, err := parseNameTemplate("{{.Series", profile); err == nil {
+		t.Errorf("Expected a parse error for an unclosed action")
+	}
+	if _, err := parseNameTemplate("{{unknown .Series}}", profile); err == nil {
+		t.Errorf("Expected a parse error for an unknown function")
+	}
+
+	tmpl, err := parseNameTemplate("{{.Author}}", profile)
+	if err != nil {
+		t.Fatalf("Unexpected parse error %v", err)
+	}
+	if _, err := renderName(tmpl, nameData{}, profile, maxFilenameBytes); err == nil {
+		t.Errorf("Expected a render error for an unknown field")
+	}
+}
+
+func TestRenderNameProfiles(t *testing.T) {
+	data := nameData{Series: "エルフさんは痩せられない。", FirstChapter: "1", LastChapter: "2"}
+
+	testCases := []struct {
+		profile     string
+		template    string
+		expected    string
+		description string
+	}{
+		{profileASCII, defaultNameTemplate, "eruhusanhaShou_serarenai___Ch_1-2", "ASCII profile transliterates"},
+		{profileASCII, "{{.Series}} Ch.{{.FirstChapter}}", "eruhusanhaShou serarenai.  Ch.1", "ASCII profile transliterates templates without sanitize"},
+		{profileUnicode, defaultNameTemplate, "エルフさんは痩せられない。 Ch.1-2", "Unicode profile keeps the script"},
+		{profileWindowsSafe, "{{sanitize .Series}}.", "エルフさんは痩せられない。", "Windows-safe profile finalizes the rendered name"},
+		{profilePosixMin, "{{.Series}}: Ch.{{.FirstChapter}}/{{.LastChapter}}", "エルフさんは痩せられない。: Ch.1_2", "POSIX profile only replaces slashes"},
+	}
+
+	for _, tc := range testCases {
+		profile := sanitizeProfiles[tc.profile]
+		tmpl, err := parseNameTemplate(tc.template, profile)
+		if err != nil {
+			t.Errorf("Test '%s': Unexpected parse error %v", tc.description, err)
+			continue
+		}
+		result, err := renderName(tmpl, data, profile, maxFilenameBytes)
+		if err != nil {
+			t.Errorf("Test '%s': Unexpected render error %v", tc.description, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Test '%s': Expected '%s', got '%s'", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestRenderNameTruncation(t *testing.T) {
+	profile := sanitizeProfiles[profileWindowsSafe]
+	tmpl, err := parseNameTemplate("{{.Series}}", profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := renderName(tmpl, nameData{Series: "Series. Name"}, profile, 8)
+	if err != nil || result != "Series" {
+		t.Errorf("Expected the cut name to lose its trailing dot, got %q, %v", result, err)
+	}
+}
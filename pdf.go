@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const formatPDF = "pdf"
+
+// Reserved PDF object numbers, page objects follow
+const (
+	pdfCatalogObject  = 1
+	pdfPagesObject    = 2
+	pdfOutlinesObject = 3
+	pdfInfoObject     = 4
+)
+
+// pdfWriter writes numbered objects and remembers their offsets for the cross-reference table
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int64
+	offsets map[int]int64
+	next    int
+}
+
+// pdfImage is a page image ready to be embedded as an image XObject
+type pdfImage struct {
+	Width      int
+	Height     int
+	ColorSpace string
+	Filter     string
+	Data       []byte
+	SMask      []byte // Flate-compressed 8-bit alpha channel, nil if the image is opaque
+}
+
+func newPDFWriter(w io.Writer) *pdfWriter {
+	return &pdfWriter{
+		w:       bufio.NewWriter(w),
+		offsets: make(map[int]int64),
+		next:    pdfInfoObject + 1,
+	}
+}
+
+func (pw *pdfWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return n, err
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(pw, format, args...)
+}
+
+// newObject allocates an object number
+func (pw *pdfWriter) newObject() int {
+	n := pw.next
+	pw.next++
+	return n
+}
+
+// object writes an object with a dictionary or other direct value
+func (pw *pdfWriter) object(n int, value string) {
+	pw.offsets[n] = pw.offset
+	pw.printf("%d 0 obj\n%s\nendobj\n", n, value)
+}
+
+// stream writes a stream object, dict must not include the Length
+func (pw *pdfWriter) stream(n int, dict string, data []byte) {
+	pw.offsets[n] = pw.offset
+	pw.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+	pw.Write(data)
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// finish writes the cross-reference table and trailer
+func (pw *pdfWriter) finish() error {
+	xref := pw.offset
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", pw.next)
+	for n := 1; n < pw.next; n++ {
+		if offset, ok := pw.offsets[n]; ok {
+			pw.printf("%010d 00000 n \n", offset)
+		} else {
+			pw.printf("0000000000 65535 f \n")
+		}
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		pw.next, pdfCatalogObject, pdfInfoObject, xref)
+	return pw.w.Flush()
+}
+
+// pdfTextString encodes a string as a PDF text string, a literal for printable ASCII and UTF-16BE hex otherwise
+func pdfTextString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		replacer := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+		return "(" + replacer.Replace(s) + ")"
+	}
+
+	var buf strings.Builder
+	buf.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&buf, "%04X", unit)
+	}
+	buf.WriteString(">")
+	return buf.String()
+}
+
+// pdfDate formats a time as a PDF date string
+func pdfDate(t time.Time) string {
+	return "(D:" + t.UTC().Format("20060102150405") + "Z)"
+}
+
+// flateCompress compresses data for a FlateDecode stream
+func flateCompress(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// newPDFImage prepares a page image for embedding. Baseline and progressive RGB or grayscale JPEGs
+// are embedded as they are (DCTDecode), everything else is decoded and stored losslessly (FlateDecode).
+func newPDFImage(page Page) (pdfImage, error) {
+	data, err := page.ReadAll()
+	if err != nil {
+		return pdfImage{}, err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, err
+	}
+
+	if format == "jpeg" {
+		switch config.ColorModel {
+		case color.YCbCrModel:
+			return pdfImage{Width: config.Width, Height: config.Height, ColorSpace: "/DeviceRGB", Filter: "/DCTDecode", Data: data}, nil
+		case color.GrayModel:
+			return pdfImage{Width: config.Width, Height: config.Height, ColorSpace: "/DeviceGray", Filter: "/DCTDecode", Data: data}, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, err
+	}
+	return newPDFImageFromDecoded(img), nil
+}
+
+// newPDFImageFromDecoded stores a decoded image as Flate-compressed samples, with an alpha soft mask if needed
+func newPDFImageFromDecoded(img image.Image) pdfImage {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	result := pdfImage{Width: width, Height: height, Filter: "/FlateDecode"}
+
+	if gray, ok := img.(*image.Gray); ok {
+		samples := make([]byte, 0, width*height)
+		for y := 0; y < height; y++ {
+			row := gray.Pix[y*gray.Stride : y*gray.Stride+width]
+			samples = append(samples, row...)
+		}
+		result.ColorSpace = "/DeviceGray"
+		result.Data = flateCompress(samples)
+		return result
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	samples := make([]byte, 0, width*height*3)
+	alpha := make([]byte, 0, width*height)
+	opaque := true
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		r, g, b, a := rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2], rgba.Pix[i+3]
+		if a != 0xff {
+			opaque = false
+			if a != 0 {
+				// The soft mask expects colors that aren't premultiplied by alpha
+				r = uint8(uint16(r) * 0xff / uint16(a))
+				g = uint8(uint16(g) * 0xff / uint16(a))
+				b = uint8(uint16(b) * 0xff / uint16(a))
+			}
+		}
+		samples = append(samples, r, g, b)
+		alpha = append(alpha, a)
+	}
+	result.ColorSpace = "/DeviceRGB"
+	result.Data = flateCompress(samples)
+	if !opaque {
+		result.SMask = flateCompress(alpha)
+	}
+	return result
+}
+
+// writePDF writes the book as a PDF with one page per image, each page sized to its image at 72 dpi.
+// Chapters become outline entries and the ComicInfo is mapped to the document information dictionary.
+func writePDF(book *Book, path string) (err error) {
+	if len(book.Pages) == 0 {
+		return fmt.Errorf("cannot write a PDF without pages")
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	pw := newPDFWriter(out)
+	// The binary comment marks the file as binary for transfer tools
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	pageObjects := make([]int, len(book.Pages))
+	for i, page := range book.Pages {
+		img, err := newPDFImage(page)
+		if err != nil {
+			return fmt.Errorf("page %d (%s): %w", i+1, page.Name, err)
+		}
+
+		imageObject := pw.newObject()
+		imageDict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter %s",
+			img.Width, img.Height, img.ColorSpace, img.Filter)
+		if img.SMask != nil {
+			smaskObject := pw.newObject()
+			pw.stream(smaskObject, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+				img.Width, img.Height), img.SMask)
+			imageDict += fmt.Sprintf(" /SMask %d 0 R", smaskObject)
+		}
+		pw.stream(imageObject, imageDict, img.Data)
+
+		contentObject := pw.newObject()
+		pw.stream(contentObject, "", []byte(fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", img.Width, img.Height)))
+
+		pageObjects[i] = pw.newObject()
+		pw.object(pageObjects[i], fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+			pdfPagesObject, img.Width, img.Height, imageObject, contentObject))
+	}
+
+	kids := make([]string, len(pageObjects))
+	for i, n := range pageObjects {
+		kids[i] = fmt.Sprintf("%d 0 R", n)
+	}
+	pw.object(pdfPagesObject, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+
+	writePDFOutline(pw, book, pageObjects)
+
+	catalog := fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /Outlines %d 0 R /PageMode /UseOutlines", pdfPagesObject, pdfOutlinesObject)
+	if book.Info.Manga == mangaRightToLeft {
+		catalog += " /ViewerPreferences << /Direction /R2L >>"
+	}
+	pw.object(pdfCatalogObject, catalog+" >>")
+
+	pw.object(pdfInfoObject, pdfInfoDict(book.Info))
+
+	return pw.finish()
+}
+
+// writePDFOutline writes the outline root and one entry per chapter, pointing at its first page
+func writePDFOutline(pw *pdfWriter, book *Book, pageObjects []int) {
+	type entry struct {
+		title string
+		page  int
+	}
+	var entries []entry
+	for i, chapter := range book.Chapters {
+		if chapter.FirstPage >= len(pageObjects) {
+			continue
+		}
+		title := chapter.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		entries = append(entries, entry{title, pageObjects[chapter.FirstPage]})
+	}
+	if len(entries) == 0 {
+		pw.object(pdfOutlinesObject, "<< /Type /Outlines /Count 0 >>")
+		return
+	}
+
+	objects := make([]int, len(entries))
+	for i := range entries {
+		objects[i] = pw.newObject()
+	}
+	for i, e := range entries {
+		item := fmt.Sprintf("<< /Title %s /Parent %d 0 R /Dest [%d 0 R /Fit]", pdfTextString(e.title), pdfOutlinesObject, e.page)
+		if i > 0 {
+			item += fmt.Sprintf(" /Prev %d 0 R", objects[i-1])
+		}
+		if i < len(entries)-1 {
+			item += fmt.Sprintf(" /Next %d 0 R", objects[i+1])
+		}
+		pw.object(objects[i], item+" >>")
+	}
+	pw.object(pdfOutlinesObject, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+		objects[0], objects[len(objects)-1], len(objects)))
+}
+
+// pdfInfoDict maps ComicInfo to the document information dictionary
+func pdfInfoDict(info ComicInfo) string {
+	fields := []string{
+		"/Title " + pdfTextString(bookTitle(info)),
+		"/Creator " + pdfTextString("cbztools "+Version),
+		"/Producer " + pdfTextString("cbztools "+Version),
+		"/CreationDate " + pdfDate(time.Now()),
+	}
+	if info.Writer != "" {
+		fields = append(fields, "/Author "+pdfTextString(info.Writer))
+	}
+	switch {
+	case info.Summary != "":
+		fields = append(fields, "/Subject "+pdfTextString(info.Summary))
+	case info.Series != "":
+		fields = append(fields, "/Subject "+pdfTextString(info.Series))
+	}
+	if info.Genre != "" {
+		fields = append(fields, "/Keywords "+pdfTextString(info.Genre))
+	}
+	return "<< " + strings.Join(fields, " ") + " >>"
+}
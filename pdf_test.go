@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Helper function to create an in-memory JPEG page of the given size
+func testJPEGPage(t *testing.T, name string, width int, height int) (Page, []byte) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		img.Set(0, y, color.RGBA{0, 0, 255, 255})
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	return newBytesPage(name, buf.Bytes()), buf.Bytes()
+}
+
+func TestPDFTextString(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected string
+	}{
+		{"", "()"},
+		{"Plain Title", "(Plain Title)"},
+		{`a(b)c\d`, `(a\(b\)c\\d)`},
+		{"漫画", "<FEFF6F2B753B>"},
+		{"é", "<FEFF00E9>"},
+		{"😀", "<FEFFD83DDE00>"},
+		{"tab\t", "<FEFF0074006100620009>"},
+	}
+
+	for _, tc := range testCases {
+		if result := pdfTextString(tc.s); result != tc.expected {
+			t.Errorf("Expected pdfTextString(%q) to be %s, got %s", tc.s, tc.expected, result)
+		}
+	}
+}
+
+func TestNewPDFImageFromDecoded(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 3, 2))
+	if img := newPDFImageFromDecoded(gray); img.ColorSpace != "/DeviceGray" || img.SMask != nil {
+		t.Errorf("Expected a grayscale image without mask, got %s", img.ColorSpace)
+	}
+
+	opaque := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+	if img := newPDFImageFromDecoded(opaque); img.ColorSpace != "/DeviceRGB" || img.SMask != nil || img.Width != 3 || img.Height != 2 {
+		t.Errorf("Expected an RGB image without mask, got %+v", img)
+	}
+
+	transparent := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	if img := newPDFImageFromDecoded(transparent); img.SMask == nil {
+		t.Errorf("Expected a soft mask for a transparent image")
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pdf")
+	book := testBook(t, ComicInfo{Title: "Title (1)", Writer: "作者", Manga: mangaRightToLeft}, 1, 1)
+	jpegPage, jpegData := testJPEGPage(t, "page.jpg", 30, 50)
+	book.Pages = append(book.Pages, jpegPage)
+	book.Chapters[1].Title = "Second"
+
+	if err := writePDF(book, path); err != nil {
+		t.Fatalf("Failed to write PDF: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+
+	if !strings.HasPrefix(content, "%PDF-1.4\n") || !strings.HasSuffix(content, "%%EOF\n") {
+		t.Errorf("Expected a PDF header and trailer")
+	}
+	if !bytes.Contains(data, jpegData) || !strings.Contains(content, "/DCTDecode") {
+		t.Errorf("Expected the JPEG to be embedded without recompression")
+	}
+	if strings.Count(content, "/Type /Page ") != 3 || !strings.Contains(content, "/MediaBox [0 0 30 50]") {
+		t.Errorf("Expected one page per image sized to the image")
+	}
+	for _, expected := range []string{"/Title (Title \\(1\\))", "/Author <FEFF4F5C8005>", "/Title (Second)", "/Direction /R2L", "/Type /Outlines /First"} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected PDF to contain %s", expected)
+		}
+	}
+
+	// Every in-use cross-reference entry must point at its object
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(content)
+	if startxref == nil {
+		t.Fatalf("Expected startxref")
+	}
+	xrefOffset, _ := strconv.Atoi(startxref[1])
+	if !strings.HasPrefix(content[xrefOffset:], "xref\n") {
+		t.Fatalf("startxref doesn't point at the cross-reference table")
+	}
+	lines := strings.Split(content[xrefOffset:], "\n")
+	size, _ := strconv.Atoi(strings.TrimPrefix(lines[1], "0 "))
+	if size < 5 {
+		t.Fatalf("Expected at least 5 cross-reference entries, got %q", lines[1])
+	}
+	for n := 1; n < size; n++ {
+		line := lines[n+2]
+		if !strings.HasSuffix(line, " n ") {
+			continue
+		}
+		offset, _ := strconv.Atoi(line[:10])
+		if !strings.HasPrefix(content[offset:], strconv.Itoa(n)+" 0 obj") {
+			t.Errorf("Cross-reference entry for object %d points at %q", n, content[offset:offset+10])
+		}
+	}
+}
+
+func TestWritePDFWithoutPages(t *testing.T) {
+	if err := writePDF(&Book{}, filepath.Join(t.TempDir(), "out.pdf")); err == nil {
+		t.Errorf("Expected an error for a book without pages")
+	}
+}
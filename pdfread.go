@@ -0,0 +1,1376 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf16"
+)
+
+// PDF object types. Integers are int, reals float64, booleans bool and null nil.
+type (
+	pdfName    string
+	pdfString  string // raw bytes, see pdfDecodeText for text strings
+	pdfKeyword string
+	pdfArray   []interface{}
+	pdfDict    map[pdfName]interface{}
+)
+
+type pdfRef struct {
+	Num int
+	Gen int
+}
+
+type pdfStream struct {
+	Dict pdfDict
+	Data []byte // still encoded
+}
+
+// pdfXrefEntry locates an object, either at an offset in the file or inside an object stream
+type pdfXrefEntry struct {
+	Offset     int
+	Compressed bool
+	Stream     int
+	Index      int
+}
+
+// pdfDocument is a parsed PDF file. Objects are loaded lazily through the cross-reference table, into cache, so a
+// document must only be used by one goroutine at a time. Pages are extracted from several, under mu.
+type pdfDocument struct {
+	data    []byte
+	xref    map[int]pdfXrefEntry
+	trailer pdfDict
+	cache   map[int]interface{}
+	mu      sync.Mutex
+}
+
+// pdfLexer parses PDF objects from a byte slice
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+var errPDFSyntax = errors.New("malformed PDF")
+
+// pdfMaxImageSide is the largest image width or height read, more than any page scan and JPEG's own limit
+const pdfMaxImageSide = 1 << 16
+
+// pdfMaxImagePixels is the most pixels of an image whose size bounds its decoded data, see streamLimit. It is far
+// more than any page scan, and small enough that the size of the samples can't overflow an int.
+const pdfMaxImagePixels = 1 << 26
+
+// pdfMaxStreamSize is the most any other stream may decode to. Object streams and cross-reference streams are far
+// smaller.
+const pdfMaxStreamSize = 64 << 20
+
+// recoverPDF turns a panic of the parser on a malformed file into an error, so one bad PDF doesn't stop a whole batch.
+// It must be deferred directly.
+func recoverPDF(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: %v", errPDFSyntax, r)
+	}
+}
+
+// pdfObjectHeaderRegex finds "12 0 obj" when the cross-reference table is broken
+var pdfObjectHeaderRegex = regexp.MustCompile(`(?m)(?:^|[\r\n\s])(\d+)\s+(\d+)\s+obj\b`)
+
+func isPDFWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (l *pdfLexer) skipWhitespace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPDFWhitespace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// regular reads a run of regular characters, like a number or keyword
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// hasPrefix reports whether the input continues with s
+func (l *pdfLexer) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.data[l.pos:], []byte(s))
+}
+
+func (l *pdfLexer) parseObject() (interface{}, error) {
+	l.skipWhitespace()
+	if l.pos >= len(l.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		return l.parseName(), nil
+	case c == '(':
+		return l.parseLiteralString()
+	case c == '<' && l.hasPrefix("<<"):
+		return l.parseDict()
+	case c == '<':
+		return l.parseHexString()
+	case c == '[':
+		return l.parseArray()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.parseNumberOrRef()
+	case isPDFDelimiter(c):
+		return nil, fmt.Errorf("%w: unexpected %q at %d", errPDFSyntax, c, l.pos)
+	}
+
+	switch word := l.regular(); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return pdfKeyword(word), nil
+	}
+}
+
+func (l *pdfLexer) parseName() pdfName {
+	l.pos++ // "/"
+	raw := l.regular()
+	var name []byte
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			if b, err := hex.DecodeString(raw[i+1 : i+3]); err == nil {
+				name = append(name, b[0])
+				i += 2
+				continue
+			}
+		}
+		name = append(name, raw[i])
+	}
+	return pdfName(name)
+}
+
+func (l *pdfLexer) parseLiteralString() (pdfString, error) {
+	l.pos++ // "("
+	var result []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(result), nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return "", io.ErrUnexpectedEOF
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// Line continuation
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		result = append(result, c)
+	}
+	return "", io.ErrUnexpectedEOF
+}
+
+func (l *pdfLexer) parseHexString() (pdfString, error) {
+	l.pos++ // "<"
+	var digits []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			result, err := hex.DecodeString(string(digits))
+			if err != nil {
+				return "", fmt.Errorf("%w: %v", errPDFSyntax, err)
+			}
+			return pdfString(result), nil
+		}
+		if !isPDFWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	return "", io.ErrUnexpectedEOF
+}
+
+func (l *pdfLexer) parseDict() (pdfDict, error) {
+	l.pos += 2 // "<<"
+	result := pdfDict{}
+	for {
+		l.skipWhitespace()
+		if l.pos >= len(l.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if l.hasPrefix(">>") {
+			l.pos += 2
+			return result, nil
+		}
+		key, err := l.parseObject()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, fmt.Errorf("%w: dictionary key %v is not a name", errPDFSyntax, key)
+		}
+		value, err := l.parseObject()
+		if err != nil {
+			return nil, err
+		}
+		result[name] = value
+	}
+}
+
+func (l *pdfLexer) parseArray() (pdfArray, error) {
+	l.pos++ // "["
+	var result pdfArray
+	for {
+		l.skipWhitespace()
+		if l.pos >= len(l.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if l.data[l.pos] == ']' {
+			l.pos++
+			return result, nil
+		}
+		value, err := l.parseObject()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+}
+
+// parseNumberOrRef parses a number, or a "12 0 R" reference
+func (l *pdfLexer) parseNumberOrRef() (interface{}, error) {
+	word := l.regular()
+	n, err := strconv.Atoi(word)
+	if err != nil {
+		f, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad number %q", errPDFSyntax, word)
+		}
+		return f, nil
+	}
+
+	// Look ahead for "<gen> R"
+	saved := l.pos
+	l.skipWhitespace()
+	if gen, err := strconv.Atoi(l.regular()); err == nil && gen >= 0 {
+		l.skipWhitespace()
+		if l.pos < len(l.data) && l.data[l.pos] == 'R' &&
+			(l.pos+1 == len(l.data) || isPDFWhitespace(l.data[l.pos+1]) || isPDFDelimiter(l.data[l.pos+1])) {
+			l.pos++
+			return pdfRef{n, gen}, nil
+		}
+	}
+	l.pos = saved
+	return n, nil
+}
+
+// parsePDF parses the structure of a PDF file. Objects are read on demand.
+func parsePDF(data []byte) (*pdfDocument, error) {
+	header := data
+	if len(header) > 1024 {
+		header = header[:1024]
+	}
+	if !bytes.Contains(header, []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+
+	doc := &pdfDocument{data: data, xref: make(map[int]pdfXrefEntry), cache: make(map[int]interface{})}
+	if err := doc.loadXref(); err != nil || doc.trailer["Root"] == nil {
+		// Broken or missing cross-reference table, find the objects by scanning the file
+		doc.xref = make(map[int]pdfXrefEntry)
+		doc.cache = make(map[int]interface{})
+		if err := doc.scanObjects(); err != nil {
+			return nil, err
+		}
+	}
+	if doc.trailer["Encrypt"] != nil {
+		return nil, fmt.Errorf("encrypted PDFs are not supported")
+	}
+	return doc, nil
+}
+
+// loadXref reads the cross-reference sections starting at startxref and following /Prev
+func (doc *pdfDocument) loadXref() error {
+	index := bytes.LastIndex(doc.data, []byte("startxref"))
+	if index < 0 {
+		return fmt.Errorf("%w: no startxref", errPDFSyntax)
+	}
+	l := &pdfLexer{data: doc.data, pos: index + len("startxref")}
+	offset, err := l.parseObject()
+	if err != nil {
+		return err
+	}
+
+	visited := make(map[int]bool)
+	next, ok := offset.(int)
+	for ok && !visited[next] {
+		visited[next] = true
+		trailer, err := doc.loadXrefSection(next)
+		if err != nil {
+			return err
+		}
+		if doc.trailer == nil {
+			doc.trailer = trailer
+		}
+		// Hybrid files keep the compressed objects in an additional cross-reference stream
+		if stm, isInt := trailer["XRefStm"].(int); isInt && !visited[stm] {
+			visited[stm] = true
+			if _, err := doc.loadXrefSection(stm); err != nil {
+				return err
+			}
+		}
+		next, ok = trailer["Prev"].(int)
+	}
+	return nil
+}
+
+// loadXrefSection reads a cross-reference table or stream at the offset. Entries already known are newer and kept.
+func (doc *pdfDocument) loadXrefSection(offset int) (pdfDict, error) {
+	if offset < 0 || offset >= len(doc.data) {
+		return nil, fmt.Errorf("%w: cross-reference offset %d out of range", errPDFSyntax, offset)
+	}
+	l := &pdfLexer{data: doc.data, pos: offset}
+	l.skipWhitespace()
+	if !l.hasPrefix("xref") {
+		return doc.loadXrefStream(offset)
+	}
+	l.pos += len("xref")
+
+	for {
+		l.skipWhitespace()
+		if l.hasPrefix("trailer") {
+			l.pos += len("trailer")
+			obj, err := l.parseObject()
+			if err != nil {
+				return nil, err
+			}
+			trailer, ok := obj.(pdfDict)
+			if !ok {
+				return nil, fmt.Errorf("%w: trailer is not a dictionary", errPDFSyntax)
+			}
+			return trailer, nil
+		}
+
+		start, err1 := l.parseObject()
+		count, err2 := l.parseObject()
+		first, ok1 := start.(int)
+		n, ok2 := count.(int)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 {
+			return nil, fmt.Errorf("%w: bad cross-reference subsection", errPDFSyntax)
+		}
+		for i := 0; i < n; i++ {
+			entryOffset, _ := l.parseObject()
+			l.parseObject() // generation
+			kind, err := l.parseObject()
+			if err != nil {
+				return nil, err
+			}
+			num := first + i
+			if _, known := doc.xref[num]; known {
+				continue
+			}
+			if off, ok := entryOffset.(int); ok && kind == pdfKeyword("n") {
+				doc.xref[num] = pdfXrefEntry{Offset: off}
+			}
+		}
+	}
+}
+
+// loadXrefStream reads a cross-reference stream (PDF 1.5+) at the offset
+func (doc *pdfDocument) loadXrefStream(offset int) (pdfDict, error) {
+	_, obj, err := doc.readObjectAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := obj.(pdfStream)
+	if !ok || stream.Dict["Type"] != pdfName("XRef") {
+		return nil, fmt.Errorf("%w: no cross-reference at offset %d", errPDFSyntax, offset)
+	}
+	data, _, err := doc.decodeStream(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	widthsArray, _ := stream.Dict["W"].(pdfArray)
+	if len(widthsArray) != 3 {
+		return nil, fmt.Errorf("%w: bad cross-reference stream widths", errPDFSyntax)
+	}
+	// Fields wider than 8 bytes don't fit an offset
+	var widths [3]int
+	rowLength := 0
+	for i, w := range widthsArray {
+		widths[i], _ = w.(int)
+		if widths[i] < 0 || widths[i] > 8 {
+			return nil, fmt.Errorf("%w: bad cross-reference stream widths", errPDFSyntax)
+		}
+		rowLength += widths[i]
+	}
+	if rowLength == 0 {
+		return nil, fmt.Errorf("%w: bad cross-reference stream widths", errPDFSyntax)
+	}
+
+	index, _ := stream.Dict["Index"].(pdfArray)
+	if index == nil {
+		size, _ := stream.Dict["Size"].(int)
+		index = pdfArray{0, size}
+	}
+
+	readField := func(row []byte, start int, width int) int {
+		value := 0
+		for _, b := range row[start : start+width] {
+			value = value<<8 | int(b)
+		}
+		return value
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		first, _ := index[i].(int)
+		count, _ := index[i+1].(int)
+		for j := 0; j < count && pos+rowLength <= len(data); j++ {
+			row := data[pos : pos+rowLength]
+			pos += rowLength
+
+			kind := 1
+			if widths[0] > 0 {
+				kind = readField(row, 0, widths[0])
+			}
+			field2 := readField(row, widths[0], widths[1])
+			field3 := readField(row, widths[0]+widths[1], widths[2])
+
+			num := first + j
+			if _, known := doc.xref[num]; known {
+				continue
+			}
+			switch kind {
+			case 1:
+				doc.xref[num] = pdfXrefEntry{Offset: field2}
+			case 2:
+				doc.xref[num] = pdfXrefEntry{Compressed: true, Stream: field2, Index: field3}
+			}
+		}
+	}
+	return stream.Dict, nil
+}
+
+// scanObjects rebuilds the cross-reference table by looking for object headers in the whole file
+func (doc *pdfDocument) scanObjects() error {
+	for _, match := range pdfObjectHeaderRegex.FindAllSubmatchIndex(doc.data, -1) {
+		num, _ := strconv.Atoi(string(doc.data[match[2]:match[3]]))
+		doc.xref[num] = pdfXrefEntry{Offset: match[2]}
+	}
+
+	// Objects inside object streams, unless they also exist uncompressed
+	direct := make([]int, 0, len(doc.xref))
+	for num := range doc.xref {
+		direct = append(direct, num)
+	}
+	sort.Ints(direct)
+	for _, num := range direct {
+		stream, ok := doc.getObject(num).(pdfStream)
+		if !ok || stream.Dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		if nums, _, _, err := doc.parseObjectStreamHeader(stream); err == nil {
+			for i, n := range nums {
+				if _, known := doc.xref[n]; !known {
+					doc.xref[n] = pdfXrefEntry{Compressed: true, Stream: num, Index: i}
+				}
+			}
+		}
+	}
+
+	// The trailer is the last trailer dictionary, or else the catalog is found by type
+	if index := bytes.LastIndex(doc.data, []byte("trailer")); index >= 0 {
+		l := &pdfLexer{data: doc.data, pos: index + len("trailer")}
+		if trailer, err := l.parseObject(); err == nil {
+			doc.trailer, _ = trailer.(pdfDict)
+		}
+	}
+	if doc.trailer == nil || doc.trailer["Root"] == nil {
+		doc.trailer = pdfDict{}
+		for num := range doc.xref {
+			if dict := doc.dict(pdfRef{Num: num}); dict["Type"] == pdfName("Catalog") {
+				doc.trailer["Root"] = pdfRef{Num: num}
+				break
+			}
+		}
+	}
+	if doc.trailer["Root"] == nil {
+		return fmt.Errorf("%w: no document catalog", errPDFSyntax)
+	}
+	return nil
+}
+
+// readObjectAt parses the indirect object "num gen obj ... endobj" at the offset
+func (doc *pdfDocument) readObjectAt(offset int) (int, interface{}, error) {
+	if offset < 0 || offset >= len(doc.data) {
+		return 0, nil, fmt.Errorf("%w: object offset %d out of range", errPDFSyntax, offset)
+	}
+	l := &pdfLexer{data: doc.data, pos: offset}
+	num, err := l.parseObject()
+	if err != nil {
+		return 0, nil, err
+	}
+	l.parseObject() // generation
+	if keyword, _ := l.parseObject(); keyword != pdfKeyword("obj") {
+		return 0, nil, fmt.Errorf("%w: no object at offset %d", errPDFSyntax, offset)
+	}
+	obj, err := l.parseObject()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, _ := num.(int)
+
+	dict, isDict := obj.(pdfDict)
+	if !isDict {
+		return n, obj, nil
+	}
+	l.skipWhitespace()
+	if !l.hasPrefix("stream") {
+		return n, obj, nil
+	}
+	l.pos += len("stream")
+	if l.hasPrefix("\r\n") {
+		l.pos += 2
+	} else if l.hasPrefix("\n") || l.hasPrefix("\r") {
+		l.pos++
+	}
+	start := l.pos
+
+	// Trust /Length if it ends right before "endstream", otherwise look for the keyword
+	if length, ok := doc.resolve(dict["Length"]).(int); ok && length >= 0 && start+length <= len(doc.data) {
+		rest := bytes.TrimLeft(doc.data[start+length:], "\r\n \t")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			return n, pdfStream{Dict: dict, Data: doc.data[start : start+length]}, nil
+		}
+	}
+	end := bytes.Index(doc.data[start:], []byte("endstream"))
+	if end < 0 {
+		return 0, nil, fmt.Errorf("%w: unterminated stream at offset %d", errPDFSyntax, offset)
+	}
+	data := bytes.TrimRight(doc.data[start:start+end], "\r\n")
+	return n, pdfStream{Dict: dict, Data: data}, nil
+}
+
+// parseObjectStreamHeader decodes an object stream and returns the object numbers and offsets it contains
+func (doc *pdfDocument) parseObjectStreamHeader(stream pdfStream) ([]int, []int, []byte, error) {
+	data, _, err := doc.decodeStream(stream)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	count, _ := doc.resolve(stream.Dict["N"]).(int)
+	first, _ := doc.resolve(stream.Dict["First"]).(int)
+	// Each object takes at least two bytes of the header
+	if first < 0 || first > len(data) || count < 0 || count > first/2+1 {
+		return nil, nil, nil, fmt.Errorf("%w: bad object stream", errPDFSyntax)
+	}
+
+	l := &pdfLexer{data: data[:first]}
+	nums := make([]int, 0, count)
+	offsets := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		num, err1 := l.parseObject()
+		offset, err2 := l.parseObject()
+		n, ok1 := num.(int)
+		o, ok2 := offset.(int)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 || o < 0 {
+			return nil, nil, nil, fmt.Errorf("%w: bad object stream header", errPDFSyntax)
+		}
+		nums = append(nums, n)
+		offsets = append(offsets, first+o)
+	}
+	return nums, offsets, data, nil
+}
+
+// getObject loads an object by number, nil if it doesn't exist or can't be parsed
+func (doc *pdfDocument) getObject(num int) interface{} {
+	if obj, ok := doc.cache[num]; ok {
+		return obj
+	}
+	doc.cache[num] = nil // guards against reference cycles while loading
+
+	entry, ok := doc.xref[num]
+	if !ok {
+		return nil
+	}
+	var obj interface{}
+	if entry.Compressed {
+		stream, isStream := doc.getObject(entry.Stream).(pdfStream)
+		if !isStream {
+			return nil
+		}
+		_, offsets, data, err := doc.parseObjectStreamHeader(stream)
+		if err != nil || entry.Index >= len(offsets) || offsets[entry.Index] > len(data) {
+			return nil
+		}
+		l := &pdfLexer{data: data, pos: offsets[entry.Index]}
+		obj, _ = l.parseObject()
+	} else {
+		_, obj, _ = doc.readObjectAt(entry.Offset)
+	}
+	doc.cache[num] = obj
+	return obj
+}
+
+// resolve follows references until it reaches a direct object
+func (doc *pdfDocument) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = doc.getObject(ref.Num)
+	}
+	return nil
+}
+
+// dict resolves obj to a dictionary, the dictionary of a stream, or nil
+func (doc *pdfDocument) dict(obj interface{}) pdfDict {
+	switch v := doc.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case pdfStream:
+		return v.Dict
+	}
+	return nil
+}
+
+// pdfFilters returns the filter names of a stream and their parameters
+func (doc *pdfDocument) pdfFilters(dict pdfDict) ([]pdfName, []pdfDict) {
+	var names []pdfName
+	var params []pdfDict
+	switch filter := doc.resolve(dict["Filter"]).(type) {
+	case pdfName:
+		names = []pdfName{filter}
+	case pdfArray:
+		for _, f := range filter {
+			if name, ok := doc.resolve(f).(pdfName); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	switch parms := doc.resolve(dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = []pdfDict{parms}
+	case pdfArray:
+		for _, p := range parms {
+			params = append(params, doc.dict(p))
+		}
+	}
+	for len(params) < len(names) {
+		params = append(params, nil)
+	}
+	return names, params
+}
+
+// decodeStream applies the stream's filters until it reaches an image codec, which is returned undecoded
+// along with its name (e.g. DCTDecode), or "" if the data is fully decoded
+func (doc *pdfDocument) decodeStream(stream pdfStream) ([]byte, pdfName, error) {
+	data := stream.Data
+	names, params := doc.pdfFilters(stream.Dict)
+	for i, name := range names {
+		var err error
+		switch name {
+		case "FlateDecode", "Fl":
+			data, err = pdfFlateDecode(data, params[i], doc.streamLimit(stream.Dict))
+		case "ASCIIHexDecode", "AHx":
+			data, err = pdfASCIIHexDecode(data)
+		case "ASCII85Decode", "A85":
+			data, err = pdfASCII85Decode(data)
+		default:
+			if i != len(names)-1 {
+				return nil, "", fmt.Errorf("unsupported filter %s", name)
+			}
+			return data, name, nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return data, "", nil
+}
+
+// streamLimit returns the most a stream may decode to, so a small file can't inflate into gigabytes: for an image,
+// the size of its samples with a byte per row for PNG predictors, else pdfMaxStreamSize
+func (doc *pdfDocument) streamLimit(dict pdfDict) int {
+	if subtype, _ := doc.resolve(dict["Subtype"]).(pdfName); subtype != "Image" {
+		return pdfMaxStreamSize
+	}
+	width, _ := doc.resolve(dict["Width"]).(int)
+	height, _ := doc.resolve(dict["Height"]).(int)
+	if width <= 0 || height <= 0 || width > pdfMaxImagePixels/height {
+		return pdfMaxStreamSize
+	}
+	components, bpc := 4, 16 // the most an image can have
+	if mask, _ := doc.resolve(dict["ImageMask"]).(bool); mask {
+		components, bpc = 1, 1
+	} else {
+		if cs, err := doc.imageColorSpace(dict["ColorSpace"]); err == nil && cs.Components >= 1 && cs.Components <= components {
+			components = cs.Components
+		}
+		if b, ok := doc.resolve(dict["BitsPerComponent"]).(int); ok && b > 0 && b < bpc {
+			bpc = b
+		}
+	}
+	return height * ((width*components*bpc+7)/8 + 1)
+}
+
+// pdfFlateDecode inflates a stream, failing if it decodes to more than limit bytes
+func pdfFlateDecode(data []byte, params pdfDict, limit int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	decoded, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if len(decoded) > limit {
+		return nil, fmt.Errorf("decodes to more than %d bytes", limit)
+	}
+	// Many PDFs have truncated or unterminated Flate streams, keep whatever could be decoded
+	if err != nil && len(decoded) == 0 {
+		return nil, err
+	}
+	return pdfUnpredict(decoded, params)
+}
+
+func pdfASCIIHexDecode(data []byte) ([]byte, error) {
+	var digits []byte
+	for _, c := range data {
+		if c == '>' {
+			break
+		}
+		if !isPDFWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	return hex.DecodeString(string(digits))
+}
+
+func pdfASCII85Decode(data []byte) ([]byte, error) {
+	var result []byte
+	var group [5]byte
+	n := 0
+	flush := func(count int) {
+		value := uint32(0)
+		for i := 0; i < 5; i++ {
+			value = value*85 + uint32(group[i])
+		}
+		for i := 0; i < count-1; i++ {
+			result = append(result, byte(value>>(24-8*i)))
+		}
+	}
+	for _, c := range data {
+		switch {
+		case c == '~':
+			if n > 0 {
+				for i := n; i < 5; i++ {
+					group[i] = 84
+				}
+				flush(n)
+			}
+			return result, nil
+		case c == 'z' && n == 0:
+			result = append(result, 0, 0, 0, 0)
+		case c >= '!' && c <= 'u':
+			group[n] = c - '!'
+			n++
+			if n == 5 {
+				flush(5)
+				n = 0
+			}
+		case isPDFWhitespace(c):
+		default:
+			return nil, fmt.Errorf("invalid character %q", c)
+		}
+	}
+	return result, nil
+}
+
+// pdfUnpredict reverses TIFF (2) and PNG (10-15) predictors of Flate-compressed data
+func pdfUnpredict(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int)
+	if predictor < 2 {
+		return data, nil
+	}
+	colors, bpc, columns := 1, 8, 1
+	if v, ok := params["Colors"].(int); ok && v > 0 {
+		colors = v
+	}
+	if v, ok := params["BitsPerComponent"].(int); ok && v > 0 {
+		bpc = v
+	}
+	if v, ok := params["Columns"].(int); ok && v > 0 {
+		columns = v
+	}
+	if colors > 4 || bpc > 16 || columns > pdfMaxImageSide {
+		return nil, fmt.Errorf("%w: bad predictor parameters", errPDFSyntax)
+	}
+	bytesPerPixel := (colors*bpc + 7) / 8
+	rowLength := (colors*bpc*columns + 7) / 8
+
+	if predictor == 2 {
+		if bpc != 8 {
+			return nil, fmt.Errorf("unsupported TIFF predictor with %d bits per component", bpc)
+		}
+		for row := 0; row+rowLength <= len(data); row += rowLength {
+			for i := row + bytesPerPixel; i < row+rowLength; i++ {
+				data[i] += data[i-bytesPerPixel]
+			}
+		}
+		return data, nil
+	}
+
+	result := make([]byte, 0, len(data)/(rowLength+1)*rowLength)
+	previous := make([]byte, rowLength)
+	for pos := 0; pos+rowLength+1 <= len(data); pos += rowLength + 1 {
+		filter := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowLength]...)
+		for i := range row {
+			var left, up, upLeft byte
+			if i >= bytesPerPixel {
+				left = row[i-bytesPerPixel]
+				upLeft = previous[i-bytesPerPixel]
+			}
+			up = previous[i]
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		result = append(result, row...)
+		previous = row
+	}
+	return result, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// pdfDecodeText decodes a PDF text string, UTF-16BE with a byte order mark, UTF-8 with one (PDF 2.0),
+// or PDFDocEncoding, which is treated as Latin-1
+func pdfDecodeText(s pdfString) string {
+	b := []byte(s)
+	switch {
+	case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	case len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf:
+		return string(b[3:])
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// pdfPages returns the page dictionaries in order, with inheritable Resources filled in
+func (doc *pdfDocument) pdfPages() ([]pdfDict, []int) {
+	var pages []pdfDict
+	var objects []int
+	visited := make(map[int]bool)
+
+	var walk func(node interface{}, resources interface{}, depth int)
+	walk = func(node interface{}, resources interface{}, depth int) {
+		ref, isRef := node.(pdfRef)
+		if isRef {
+			if visited[ref.Num] {
+				return
+			}
+			visited[ref.Num] = true
+		}
+		dict := doc.dict(node)
+		if dict == nil || depth > 64 {
+			return
+		}
+		if r, ok := dict["Resources"]; ok {
+			resources = r
+		}
+		if kids, ok := doc.resolve(dict["Kids"]).(pdfArray); ok && dict["Type"] != pdfName("Page") {
+			for _, kid := range kids {
+				walk(kid, resources, depth+1)
+			}
+			return
+		}
+		page := pdfDict{}
+		for k, v := range dict {
+			page[k] = v
+		}
+		page["Resources"] = resources
+		pages = append(pages, page)
+		objects = append(objects, ref.Num)
+	}
+
+	root := doc.dict(doc.trailer["Root"])
+	walk(root["Pages"], nil, 0)
+	return pages, objects
+}
+
+// pdfPageImage finds the image of a page: the largest image XObject, also looking inside form XObjects
+func (doc *pdfDocument) pdfPageImage(page pdfDict) (pdfStream, bool) {
+	var best pdfStream
+	bestArea := -1
+
+	var search func(resources interface{}, depth int)
+	search = func(resources interface{}, depth int) {
+		xobjects := doc.dict(doc.dict(resources)["XObject"])
+		for _, value := range xobjects {
+			stream, ok := doc.resolve(value).(pdfStream)
+			if !ok {
+				continue
+			}
+			switch stream.Dict["Subtype"] {
+			case pdfName("Image"):
+				width, _ := doc.resolve(stream.Dict["Width"]).(int)
+				height, _ := doc.resolve(stream.Dict["Height"]).(int)
+				if width*height > bestArea {
+					best, bestArea = stream, width*height
+				}
+			case pdfName("Form"):
+				if depth < 3 {
+					search(stream.Dict["Resources"], depth+1)
+				}
+			}
+		}
+	}
+	search(page["Resources"], 0)
+	return best, bestArea >= 0
+}
+
+// pdfImageColorSpace describes how to turn samples into colors
+type pdfImageColorSpace struct {
+	Components int
+	Palette    color.Palette // for Indexed color spaces
+}
+
+// imageColorSpace resolves an image color space to a device color space or a palette
+func (doc *pdfDocument) imageColorSpace(cs interface{}) (pdfImageColorSpace, error) {
+	cs = doc.resolve(cs)
+	if arr, ok := cs.(pdfArray); ok && len(arr) > 0 {
+		name, _ := doc.resolve(arr[0]).(pdfName)
+		switch name {
+		case "ICCBased":
+			if len(arr) > 1 {
+				dict := doc.dict(arr[1])
+				if n, ok := doc.resolve(dict["N"]).(int); ok && (n == 1 || n == 3 || n == 4) {
+					return pdfImageColorSpace{Components: n}, nil
+				}
+				if alternate, ok := dict["Alternate"]; ok {
+					return doc.imageColorSpace(alternate)
+				}
+			}
+		case "CalGray", "CalRGB", "DeviceGray", "DeviceRGB", "DeviceCMYK":
+			return doc.imageColorSpace(name)
+		case "Indexed", "I":
+			if len(arr) < 4 {
+				break
+			}
+			base, err := doc.imageColorSpace(arr[1])
+			if err != nil || base.Palette != nil {
+				return pdfImageColorSpace{}, fmt.Errorf("unsupported indexed base color space")
+			}
+			hival, _ := doc.resolve(arr[2]).(int)
+			if hival < 0 || hival > 255 {
+				return pdfImageColorSpace{}, fmt.Errorf("bad indexed color space size %d", hival)
+			}
+			var lookup []byte
+			switch v := doc.resolve(arr[3]).(type) {
+			case pdfString:
+				lookup = []byte(v)
+			case pdfStream:
+				lookup, _, err = doc.decodeStream(v)
+				if err != nil {
+					return pdfImageColorSpace{}, err
+				}
+			}
+			palette := make(color.Palette, 0, hival+1)
+			for i := 0; i <= hival && (i+1)*base.Components <= len(lookup); i++ {
+				palette = append(palette, pdfSampleColor(lookup[i*base.Components:(i+1)*base.Components]))
+			}
+			return pdfImageColorSpace{Components: 1, Palette: palette}, nil
+		}
+		return pdfImageColorSpace{}, fmt.Errorf("unsupported color space %v", arr[0])
+	}
+
+	switch cs {
+	case pdfName("DeviceGray"), pdfName("CalGray"), pdfName("G"):
+		return pdfImageColorSpace{Components: 1}, nil
+	case pdfName("DeviceRGB"), pdfName("CalRGB"), pdfName("RGB"):
+		return pdfImageColorSpace{Components: 3}, nil
+	case pdfName("DeviceCMYK"), pdfName("CMYK"):
+		return pdfImageColorSpace{Components: 4}, nil
+	}
+	return pdfImageColorSpace{}, fmt.Errorf("unsupported color space %v", cs)
+}
+
+// pdfSampleColor converts 8-bit samples of a gray, RGB or CMYK color to a color
+func pdfSampleColor(samples []byte) color.Color {
+	switch len(samples) {
+	case 1:
+		return color.Gray{samples[0]}
+	case 3:
+		return color.NRGBA{samples[0], samples[1], samples[2], 0xff}
+	case 4:
+		return color.CMYK{samples[0], samples[1], samples[2], samples[3]}
+	}
+	return color.Black
+}
+
+// pdfSamples unpacks rows of 1, 2, 4, 8 or 16-bit samples into one byte per sample, scaled to 0-255
+func pdfSamples(data []byte, width int, height int, components int, bpc int, scale bool) ([]byte, error) {
+	if bpc != 1 && bpc != 2 && bpc != 4 && bpc != 8 && bpc != 16 {
+		return nil, fmt.Errorf("unsupported %d bits per component", bpc)
+	}
+	// Bounded so the row length can't overflow
+	if width <= 0 || height <= 0 || width > pdfMaxImageSide || height > pdfMaxImageSide || components < 1 || components > 4 {
+		return nil, fmt.Errorf("bad image size %dx%d with %d components", width, height, components)
+	}
+	rowLength := (width*components*bpc + 7) / 8
+	if len(data)/rowLength < height {
+		return nil, fmt.Errorf("image data too short, %d bytes for %dx%d", len(data), width, height)
+	}
+	samples := make([]byte, 0, width*height*components)
+	maxValue := (1 << bpc) - 1
+	for y := 0; y < height; y++ {
+		row := data[y*rowLength : (y+1)*rowLength]
+		for i := 0; i < width*components; i++ {
+			var value int
+			switch bpc {
+			case 8:
+				value = int(row[i])
+			case 16:
+				value = int(row[2*i])
+			default:
+				bit := i * bpc
+				value = int(row[bit/8]>>(8-bpc-bit%8)) & maxValue
+			}
+			if scale && bpc < 8 {
+				value = value * 255 / maxValue
+			}
+			samples = append(samples, byte(value))
+		}
+	}
+	return samples, nil
+}
+
+// pdfImageExt returns the extension an image XObject is extracted with: JPEGs are kept as they are,
+// other images are decoded from their samples and stored as PNG. "" means the image can't be extracted.
+func pdfImageExt(filters []pdfName) string {
+	ext := ".png"
+	for _, filter := range filters {
+		switch filter {
+		case "FlateDecode", "Fl", "ASCIIHexDecode", "AHx", "ASCII85Decode", "A85":
+		case "DCTDecode", "DCT":
+			ext = ".jpg"
+		default:
+			return ""
+		}
+	}
+	return ext
+}
+
+// pdfExtractImage returns the image of an image XObject as JPEG or PNG data, see pdfImageExt. It is safe to call
+// from several goroutines.
+func (doc *pdfDocument) pdfExtractImage(stream pdfStream) ([]byte, error) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	data, codec, err := doc.decodeStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	switch codec {
+	case "DCTDecode", "DCT":
+		return data, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("unsupported image filter %s", codec)
+	}
+
+	img, err := doc.pdfDecodeImage(stream.Dict, data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfDecodeImage builds an image from decoded samples, applying Decode arrays and soft masks
+func (doc *pdfDocument) pdfDecodeImage(dict pdfDict, data []byte) (image.Image, error) {
+	width, _ := doc.resolve(dict["Width"]).(int)
+	height, _ := doc.resolve(dict["Height"]).(int)
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("bad image size %dx%d", width, height)
+	}
+
+	var cs pdfImageColorSpace
+	bpc, _ := doc.resolve(dict["BitsPerComponent"]).(int)
+	if mask, _ := doc.resolve(dict["ImageMask"]).(bool); mask {
+		cs, bpc = pdfImageColorSpace{Components: 1}, 1
+	} else {
+		var err error
+		if cs, err = doc.imageColorSpace(dict["ColorSpace"]); err != nil {
+			return nil, err
+		}
+	}
+	if bpc == 0 {
+		bpc = 8
+	}
+
+	samples, err := pdfSamples(data, width, height, cs.Components, bpc, cs.Palette == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// An inverted Decode array, common for 1-bit scans
+	if decode, ok := doc.resolve(dict["Decode"]).(pdfArray); ok && cs.Palette == nil && len(decode) >= 2 {
+		low, _ := decode[0].(int)
+		high, _ := decode[1].(int)
+		if low == 1 && high == 0 {
+			for i := range samples {
+				samples[i] = 255 - samples[i]
+			}
+		}
+	}
+
+	var alpha []byte
+	if smask, ok := doc.resolve(dict["SMask"]).(pdfStream); ok {
+		maskWidth, _ := doc.resolve(smask.Dict["Width"]).(int)
+		maskHeight, _ := doc.resolve(smask.Dict["Height"]).(int)
+		maskBPC, _ := doc.resolve(smask.Dict["BitsPerComponent"]).(int)
+		if maskData, codec, err := doc.decodeStream(smask); err == nil && codec == "" && maskWidth == width && maskHeight == height {
+			if maskBPC == 0 {
+				maskBPC = 8
+			}
+			alpha, _ = pdfSamples(maskData, width, height, 1, maskBPC, true)
+		}
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	switch {
+	case cs.Palette != nil && alpha == nil:
+		img := image.NewPaletted(rect, cs.Palette)
+		for i, s := range samples {
+			if int(s) >= len(cs.Palette) {
+				s = 0
+			}
+			img.Pix[i] = s
+		}
+		return img, nil
+	case cs.Components == 1 && alpha == nil:
+		img := image.NewGray(rect)
+		copy(img.Pix, samples)
+		return img, nil
+	}
+
+	img := image.NewNRGBA(rect)
+	for i := 0; i < width*height; i++ {
+		var c color.Color
+		if cs.Palette != nil {
+			index := int(samples[i])
+			if index >= len(cs.Palette) {
+				index = 0
+			}
+			c = cs.Palette[index]
+		} else {
+			c = pdfSampleColor(samples[i*cs.Components : (i+1)*cs.Components])
+		}
+		nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if alpha != nil {
+			nrgba.A = alpha[i]
+		}
+		img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3] = nrgba.R, nrgba.G, nrgba.B, nrgba.A
+	}
+	return img, nil
+}
+
+// pdfOutlineChapters maps the top-level outline entries to chapters, using the page object numbers
+func (doc *pdfDocument) pdfOutlineChapters(pageObjects []int) []Chapter {
+	pageIndex := make(map[int]int)
+	for i, num := range pageObjects {
+		pageIndex[num] = i
+	}
+
+	var chapters []Chapter
+	root := doc.dict(doc.trailer["Root"])
+	item := doc.dict(doc.dict(root["Outlines"])["First"])
+	for i := 0; item != nil && i < 10000; i++ {
+		dest := doc.resolve(item["Dest"])
+		if dest == nil {
+			dest = doc.resolve(doc.dict(item["A"])["D"])
+		}
+		if arr, ok := dest.(pdfArray); ok && len(arr) > 0 {
+			if ref, ok := arr[0].(pdfRef); ok {
+				if page, ok := pageIndex[ref.Num]; ok {
+					title, _ := doc.resolve(item["Title"]).(pdfString)
+					chapters = append(chapters, Chapter{Title: pdfDecodeText(title), FirstPage: page})
+				}
+			}
+		}
+		item = doc.dict(item["Next"])
+	}
+	return chapters
+}
+
+// pdfComicInfo maps the document information dictionary to ComicInfo
+func (doc *pdfDocument) pdfComicInfo() ComicInfo {
+	info := doc.dict(doc.trailer["Info"])
+	text := func(key pdfName) string {
+		s, _ := doc.resolve(info[key]).(pdfString)
+		return pdfDecodeText(s)
+	}
+	return ComicInfo{
+		Title:   text("Title"),
+		Writer:  text("Author"),
+		Summary: text("Subject"),
+		Genre:   text("Keywords"),
+	}
+}
+
+// readPDF reads an image-only PDF, taking the image of every page in page order without rasterizing.
+// Pages must consist of a single embedded JPEG or losslessly compressed image.
+func readPDF(path string) (book *Book, err error) {
+	defer recoverPDF(&err)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parsePDF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pages, pageObjects := doc.pdfPages()
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages found")
+	}
+
+	book = &Book{Info: doc.pdfComicInfo(), InfoSource: metaSourcePDF}
+	if book.Info == (ComicInfo{}) {
+		book.InfoSource = metaSourceNone
+	}
+	for i, page := range pages {
+		stream, ok := doc.pdfPageImage(page)
+		if !ok {
+			return nil, fmt.Errorf("page %d has no image", i+1)
+		}
+		filters, _ := doc.pdfFilters(stream.Dict)
+		ext := pdfImageExt(filters)
+		if ext == "" {
+			return nil, fmt.Errorf("page %d: unsupported image filter %v", i+1, filters)
+		}
+
+		// Images are extracted when the page is read
+		book.Pages = append(book.Pages, Page{Name: fmt.Sprintf("%05d%s", i+1, ext), open: func() (r io.ReadCloser, err error) {
+			defer recoverPDF(&err)
+			data, err := doc.pdfExtractImage(stream)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}})
+	}
+
+	book.setChapters(doc.pdfOutlineChapters(pageObjects))
+	return book, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPDFLexer(t *testing.T) {
+	testCases := []struct {
+		description string
+		input       string
+		expected    interface{}
+	}{
+		{"integer", "42", 42},
+		{"negative real", "-1.5", -1.5},
+		{"reference", "12 0 R", pdfRef{12, 0}},
+		{"name with escape", "/A#20B", pdfName("A B")},
+		{"literal string with escapes", `(a\(b\)\n\101\
+c)`, pdfString("a(b)\nAc")},
+		{"nested parentheses", "(a (b) c)", pdfString("a (b) c")},
+		{"hex string", "<48 65 6C6C 6F>", pdfString("Hello")},
+		{"odd hex string", "<414>", pdfString("A@")},
+		{"boolean", "true", true},
+		{"null", "null", nil},
+		{"array with references", "[1 0 R 2 3 R 4]", pdfArray{pdfRef{1, 0}, pdfRef{2, 3}, 4}},
+		{"dictionary", "<< /Type /Page % comment\n /Kids [] /Count 0 >>", pdfDict{"Type": pdfName("Page"), "Kids": pdfArray(nil), "Count": 0}},
+		{"keyword", "endobj", pdfKeyword("endobj")},
+	}
+
+	for _, tc := range testCases {
+		l := &pdfLexer{data: []byte(tc.input)}
+		result, err := l.parseObject()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.description, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%s: expected %#v, got %#v", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestPDFDecodeText(t *testing.T) {
+	testCases := []struct {
+		description string
+		input       pdfString
+		expected    string
+	}{
+		{"PDFDocEncoding", "Plain", "Plain"},
+		{"Latin-1 byte", "caf\xe9", "café"},
+		{"UTF-16BE", "\xfe\xff\x6f\x2b\x75\x3b", "漫画"},
+		{"UTF-8 with BOM", "\xef\xbb\xbf漫画", "漫画"},
+	}
+
+	for _, tc := range testCases {
+		if result := pdfDecodeText(tc.input); result != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestPDFUnpredict(t *testing.T) {
+	// Two rows of 3 bytes with the PNG Sub and Up filters
+	data := []byte{1, 1, 2, 3, 2, 1, 1, 1}
+	result, err := pdfUnpredict(data, pdfDict{"Predictor": 12, "Columns": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{1, 3, 6, 2, 4, 7}; !bytes.Equal(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestPDFRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.pdf")
+	book := testBook(t, ComicInfo{Title: "Title", Writer: "作者", Summary: "Summary"}, 2, 1)
+	jpegPage, jpegData := testJPEGPage(t, "page.jpg", 30, 50)
+	book.Pages = append(book.Pages, jpegPage)
+	book.Pages[len(book.Pages)-1].Chapter = 1
+	book.Chapters[0].Title = "First"
+	book.Chapters[1].Title = "Second"
+	if err := writePDF(book, path); err != nil {
+		t.Fatalf("Failed to write PDF: %v", err)
+	}
+
+	result, err := readPDF(path)
+	if err != nil {
+		t.Fatalf("Failed to read PDF: %v", err)
+	}
+	if len(result.Pages) != 4 {
+		t.Fatalf("Expected 4 pages, got %d", len(result.Pages))
+	}
+
+	expectedSizes := [][2]int{{40, 60}, {40, 61}, {41, 60}, {30, 50}}
+	for i, page := range result.Pages {
+		config, _, err := page.DecodeConfig()
+		if err != nil {
+			t.Fatalf("Page %d: %v", i, err)
+		}
+		if config.Width != expectedSizes[i][0] || config.Height != expectedSizes[i][1] {
+			t.Errorf("Expected page %d to be %v, got %dx%d", i, expectedSizes[i], config.Width, config.Height)
+		}
+	}
+	if result.Pages[0].Ext() != ".png" || result.Pages[3].Ext() != ".jpg" {
+		t.Errorf("Expected PNG and JPEG pages, got %s and %s", result.Pages[0].Ext(), result.Pages[3].Ext())
+	}
+	if data, _ := result.Pages[3].ReadAll(); !bytes.Equal(data, jpegData) {
+		t.Errorf("Expected the JPEG to be extracted unchanged")
+	}
+
+	// The first PNG page has a red top row
+	data, _ := result.Pages[0].ReadAll()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 != 255 || g != 0 || b != 0 {
+		t.Errorf("Expected a red pixel, got %v", img.At(0, 0))
+	}
+
+	expectedChapters := []Chapter{{Title: "First", FirstPage: 0}, {Title: "Second", FirstPage: 2}}
+	if !reflect.DeepEqual(result.Chapters, expectedChapters) {
+		t.Errorf("Expected chapters %v, got %v", expectedChapters, result.Chapters)
+	}
+	if result.Pages[3].Chapter != 1 {
+		t.Errorf("Expected the last page in chapter 1, got %d", result.Pages[3].Chapter)
+	}
+	if result.Info.Title != "Title" || result.Info.Writer != "作者" || result.Info.Summary != "Summary" {
+		t.Errorf("Expected metadata from the information dictionary, got %+v", result.Info)
+	}
+}
+
+func TestReadPDFBrokenXref(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.pdf")
+	if err := writePDF(testBook(t, ComicInfo{}, 3), path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	index := bytes.LastIndex(data, []byte("startxref\n"))
+	data = append(data[:index], []byte("startxref\n12\n%%EOF\n")...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := readPDF(path)
+	if err != nil {
+		t.Fatalf("Expected the objects to be found by scanning, got %v", err)
+	}
+	if len(book.Pages) != 3 {
+		t.Errorf("Expected 3 pages, got %d", len(book.Pages))
+	}
+}
+
+// Helper function to build a PDF 1.5 file with the page objects in an object stream and a
+// Flate-compressed cross-reference stream with the PNG Up predictor
+func testPDFWithObjectStreams(t *testing.T, image string, imageData []byte) []byte {
+	compressed := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [4 0 R] /Count 1 /Resources << /XObject << /Im0 5 0 R >> >> >>",
+		"<< /Title <FEFF6F2B753B> >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 8 2] >>",
+	}
+	var header, body strings.Builder
+	for i, obj := range compressed {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(obj + "\n")
+	}
+	objStm := flateCompress([]byte(header.String() + body.String()))
+
+	var buf bytes.Buffer
+	offsets := map[int]int{}
+	buf.WriteString("%PDF-1.5\n")
+	offsets[5] = buf.Len()
+	fmt.Fprintf(&buf, "5 0 obj\n<< %s /Length 6 0 R >>\nstream\n", image)
+	buf.Write(imageData)
+	buf.WriteString("\nendstream\nendobj\n")
+	offsets[6] = buf.Len()
+	fmt.Fprintf(&buf, "6 0 obj\n%d\nendobj\n", len(imageData))
+	offsets[7] = buf.Len()
+	fmt.Fprintf(&buf, "7 0 obj\n<< /Type /ObjStm /N %d /First %d /Filter /FlateDecode /Length %d >>\nstream\n", len(compressed), header.Len(), len(objStm))
+	buf.Write(objStm)
+	buf.WriteString("\nendstream\nendobj\n")
+
+	// Rows of type, offset or stream (2 bytes), index, each predicted with the Up filter
+	rows := [][]byte{{0, 0, 0, 0}}
+	for n := 1; n <= 4; n++ {
+		rows = append(rows, []byte{2, 0, 7, byte(n - 1)})
+	}
+	for n := 5; n <= 7; n++ {
+		rows = append(rows, []byte{1, byte(offsets[n] >> 8), byte(offsets[n]), 0})
+	}
+	xrefOffset := buf.Len()
+	rows = append(rows, []byte{1, byte(xrefOffset >> 8), byte(xrefOffset), 0})
+	var predicted []byte
+	previous := make([]byte, 4)
+	for _, row := range rows {
+		predicted = append(predicted, 2)
+		for i := range row {
+			predicted = append(predicted, row[i]-previous[i])
+		}
+		previous = row
+	}
+	xref := flateCompress(predicted)
+	fmt.Fprintf(&buf, "8 0 obj\n<< /Type /XRef /Size 9 /W [1 2 1] /Root 1 0 R /Info 3 0 R /Filter /FlateDecode /DecodeParms << /Columns 4 /Predictor 12 >> /Length %d >>\nstream\n", len(xref))
+	buf.Write(xref)
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+	return buf.Bytes()
+}
+
+func TestReadPDFObjectStreams(t *testing.T) {
+	testCases := []struct {
+		description string
+		image       string
+		data        []byte
+		expected    []uint8 // gray values of the first row
+	}{
+		{
+			"1-bit image with inverted Decode array",
+			"/Type /XObject /Subtype /Image /Width 8 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 1 /Decode [1 0]",
+			[]byte{0xf0, 0x00},
+			[]uint8{0, 0, 0, 0, 255, 255, 255, 255},
+		},
+		{
+			"indexed image",
+			"/Type /XObject /Subtype /Image /Width 8 /Height 2 /ColorSpace [/Indexed /DeviceGray 1 <10E0>] /BitsPerComponent 8 /Filter /ASCIIHexDecode",
+			[]byte("0001000100010001 0000000000000000>"),
+			[]uint8{0x10, 0xe0, 0x10, 0xe0, 0x10, 0xe0, 0x10, 0xe0},
+		},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(t.TempDir(), "stream.pdf")
+		if err := os.WriteFile(path, testPDFWithObjectStreams(t, tc.image, tc.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		book, err := readPDF(path)
+		if err != nil {
+			t.Errorf("%s: failed to read PDF: %v", tc.description, err)
+			continue
+		}
+		if len(book.Pages) != 1 || book.Info.Title != "漫画" {
+			t.Errorf("%s: expected 1 page titled 漫画, got %d pages titled %q", tc.description, len(book.Pages), book.Info.Title)
+			continue
+		}
+		data, err := book.Pages[0].ReadAll()
+		if err != nil {
+			t.Errorf("%s: %v", tc.description, err)
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %v", tc.description, err)
+			continue
+		}
+		var row []uint8
+		for x := 0; x < 8; x++ {
+			row = append(row, color.GrayModel.Convert(img.At(x, 0)).(color.Gray).Y)
+		}
+		if img.Bounds() != image.Rect(0, 0, 8, 2) || !bytes.Equal(row, tc.expected) {
+			t.Errorf("%s: expected first row %v, got %v (%v)", tc.description, tc.expected, row, img.Bounds())
+		}
+	}
+}
+
+func TestReadPDFUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	encrypted := filepath.Join(dir, "encrypted.pdf")
+	os.WriteFile(encrypted, []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\ntrailer\n<< /Root 1 0 R /Encrypt 2 0 R >>\n%%EOF\n"), 0o644)
+	notPDF := filepath.Join(dir, "not.pdf")
+	os.WriteFile(notPDF, []byte("hello"), 0o644)
+
+	for _, path := range []string{encrypted, notPDF} {
+		if _, err := readPDF(path); err == nil {
+			t.Errorf("Expected an error reading %s", filepath.Base(path))
+		}
+	}
+}
+
+func TestReadPDFMalformed(t *testing.T) {
+	image := "/Type /XObject /Subtype /Image /Width 8 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 1"
+	valid := string(testPDFWithObjectStreams(t, image, []byte{0xf0, 0x00}))
+
+	testCases := []struct {
+		description string
+		old         string
+		new         string
+		expectError bool
+	}{
+		{"negative cross-reference stream width, found by scanning", "/W [1 2 1]", "/W [-1 2 1]", false},
+		{"negative object count", "/Type /ObjStm /N 4", "/Type /ObjStm /N -4", true},
+		{"negative first offset", "/Type /ObjStm /N 4 /First ", "/Type /ObjStm /N 4 /First -", true},
+		{"negative image width", "/Width 8", "/Width -8", true},
+	}
+
+	for _, tc := range testCases {
+		if !strings.Contains(valid, tc.old) {
+			t.Fatalf("%s: %q not found in the test PDF", tc.description, tc.old)
+		}
+		path := filepath.Join(t.TempDir(), "malformed.pdf")
+		if err := os.WriteFile(path, []byte(strings.Replace(valid, tc.old, tc.new, 1)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		book, err := readPDF(path)
+		if err == nil {
+			for _, page := range book.Pages {
+				if _, pageErr := page.ReadAll(); pageErr != nil {
+					err = pageErr
+				}
+			}
+		}
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: expected error %v, got %v", tc.description, tc.expectError, err)
+		}
+	}
+
+	if _, err := pdfSamples(make([]byte, 16), pdfMaxImageSide+1, 1, 4, 16, true); err == nil {
+		t.Errorf("Expected an error for an image too wide for its row length")
+	}
+}
+
+func TestReadPDFFlateLimit(t *testing.T) {
+	image := "/Type /XObject /Subtype /Image /Width 8 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 1 /Filter /FlateDecode"
+	testCases := []struct {
+		description string
+		samples     []byte
+		expectError bool
+	}{
+		{"the declared size", []byte{0xf0, 0x00}, false},
+		// A few kilobytes in the file, a megabyte decoded, for 4 bytes of samples
+		{"far more than the declared size", make([]byte, 1<<20), true},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(t.TempDir(), "flate.pdf")
+		if err := os.WriteFile(path, testPDFWithObjectStreams(t, image, flateCompress(tc.samples)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		book, err := readPDF(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.description, err)
+		}
+		if _, err := book.Pages[0].ReadAll(); (err != nil) != tc.expectError {
+			t.Errorf("%s: expected error %v, got %v", tc.description, tc.expectError, err)
+		}
+	}
+}
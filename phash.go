@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+)
+
+// Perceptual hashes of pages, 64 bits each
+const (
+	hashDHash = "dhash" // difference hash: finds resized and recompressed copies
+	hashPHash = "phash" // DCT hash: also finds copies with changed contrast or light edits
+	hashBoth  = "both"  // both hashes must be close, as for the blocklist: fewer false matches
+)
+
+// defaultDuplicateThreshold is the largest Hamming distance between the hashes of two pages that are the same page
+const defaultDuplicateThreshold = 4
+
+// parsePageHash checks a hash name flag value
+func parsePageHash(value string) (string, error) {
+	switch value {
+	case hashDHash, hashPHash, hashBoth:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid hash %q, expected %s, %s or %s", value, hashDHash, hashPHash, hashBoth)
+}
+
+// grayPixels scales an image to width×height and returns its luminance, row by row
+func grayPixels(img image.Image, width int, height int) []float64 {
+	small := resizeArea(img, width, height)
+	gray := make([]float64, 0, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := small.RGBAAt(x, y)
+			gray = append(gray, 0.299*float64(c.R)+0.587*float64(c.G)+0.114*float64(c.B))
+		}
+	}
+	return gray
+}
+
+// dHash compares each pixel of a 9×8 grayscale image with its right neighbour, one bit per pair
+func dHash(img image.Image) uint64 {
+	gray := grayPixels(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y*9+x] < gray[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// pHashCos are the DCT-II factors of a 32 point transform, for the 8 lowest frequencies
+var pHashCos = func() [8][32]float64 {
+	var table [8][32]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < 32; x++ {
+			table[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / 64)
+		}
+	}
+	return table
+}()
+
+// pHash takes the 8×8 lowest frequencies of the DCT of a 32×32 grayscale image, one bit per frequency set
+// when it is above the median. The constant term is left out of the median, it only measures brightness.
+func pHash(img image.Image) uint64 {
+	gray := grayPixels(img, 32, 32)
+	// Rows first, then columns, keeping only the frequencies used
+	var rows [32][8]float64
+	for y := 0; y < 32; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 32; x++ {
+				sum += gray[y*32+x] * pHashCos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	var coefficients [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < 32; y++ {
+				sum += rows[y][u] * pHashCos[v][y]
+			}
+			coefficients[v*8+u] = sum
+		}
+	}
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	var hash uint64
+	for _, c := range coefficients {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// hashDistance is the Hamming distance between two hashes, the number of bits that differ
+func hashDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// uniformPageDeviation is the largest standard deviation of the luminance of a page, scaled to 64×64, that is still
+// blank. Hashes of blank pages are only noise, so they would match each other and anything faint.
+const uniformPageDeviation = 2.0
+
+// pageHash holds both hashes of a page, or why it has none
+type pageHash struct {
+	DHash   uint64
+	PHash   uint64
+	Uniform bool // a blank page, see uniformPageDeviation
+	Err     error
+}
+
+// distance returns the Hamming distance to another page's hash of the given kind, the larger of the two for both
+func (h pageHash) distance(other pageHash, kind string) int {
+	switch kind {
+	case hashDHash:
+		return hashDistance(h.DHash, other.DHash)
+	case hashPHash:
+		return hashDistance(h.PHash, other.PHash)
+	}
+	d, p := hashDistance(h.DHash, other.DHash), hashDistance(h.PHash, other.PHash)
+	if d > p {
+		return d
+	}
+	return p
+}
+
+// hashImageData decodes an image and hashes it both ways. Both hashes start from a 64×64 copy, scaling the full page
+// takes longer than decoding it.
+func hashImageData(data []byte) pageHash {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return pageHash{Err: err}
+	}
+	small := resizeArea(img, 64, 64)
+	return pageHash{DHash: dHash(small), PHash: pHash(small), Uniform: isUniform(grayPixels(small, 64, 64))}
+}
+
+// isUniform reports whether the standard deviation of the pixels is within uniformPageDeviation
+func isUniform(gray []float64) bool {
+	mean := 0.0
+	for _, g := range gray {
+		mean += g
+	}
+	mean /= float64(len(gray))
+	variance := 0.0
+	for _, g := range gray {
+		variance += (g - mean) * (g - mean)
+	}
+	return variance/float64(len(gray)) <= uniformPageDeviation*uniformPageDeviation
+}
+
+// hashPages hashes every page, in parallel
+func hashPages(pages []Page) []pageHash {
+	hashes := make([]pageHash, len(pages))
+	processPages(pages, func(i int, data []byte, err error) {
+		if err != nil {
+			hashes[i].Err = err
+			return
+		}
+		hashes[i] = hashImageData(data)
+	})
+	return hashes
+}
+
+// pageDuplicate is a page that is the same as an earlier one
+type pageDuplicate struct {
+	Page     int // index into Book.Pages
+	Original int // the earlier page it repeats
+	Distance int // Hamming distance of their hashes
+}
+
+// findDuplicatePages finds pages whose hash is within threshold of an earlier page's, by their hashes from hashPages.
+// The first copy is the original. Blank pages are never duplicates or originals. Pages that can't be decoded are never
+// duplicates either, their errors are returned by page index.
+func findDuplicatePages(hashes []pageHash, kind string, threshold int) ([]pageDuplicate, map[int]error) {
+	var duplicates []pageDuplicate
+	failed := make(map[int]error)
+	var originals []int
+	for i, h := range hashes {
+		if h.Err != nil {
+			failed[i] = h.Err
+			continue
+		}
+		if h.Uniform {
+			continue
+		}
+		best, bestDistance := -1, 0
+		for _, o := range originals {
+			if d := h.distance(hashes[o], kind); d <= threshold && (best < 0 || d < bestDistance) {
+				best, bestDistance = o, d
+			}
+		}
+		if best < 0 {
+			originals = append(originals, i)
+			continue
+		}
+		duplicates = append(duplicates, pageDuplicate{Page: i, Original: best, Distance: bestDistance})
+	}
+	return duplicates, failed
+}
+
+// describePage names a page for reports, with its 1-based number, file name and chapter
+func (b *Book) describePage(i int) string {
+	page := b.Pages[i]
+	description := fmt.Sprintf("page %d (%s)", i+1, page.Name)
+	if len(b.Chapters) > 1 && b.Chapters[page.Chapter].Title != "" {
+		description += fmt.Sprintf(" of %q", b.Chapters[page.Chapter].Title)
+	}
+	return description
+}
+
+// printDuplicatePages writes a line for each duplicate page, naming the page it repeats. Call it before the
+// duplicates are removed, the pages are numbered as they were found.
+func printDuplicatePages(w io.Writer, book *Book, duplicates []pageDuplicate) {
+	for _, d := range duplicates {
+		fmt.Fprintf(w, "  %s is a duplicate of %s (distance %d)\n", book.describePage(d.Page), book.describePage(d.Original), d.Distance)
+	}
+}
+
+// dropDuplicatePages removes the duplicates from the book
+func (b *Book) dropDuplicatePages(duplicates []pageDuplicate) {
+	indexes := make([]int, 0, len(duplicates))
+	for _, d := range duplicates {
+		indexes = append(indexes, d.Page)
+	}
+	b.removePages(indexes)
+}
+
+func cmdDedupePages(args []string) {
+	dedupeFlags := flag.NewFlagSet("dedupe-pages", flag.ExitOnError)
+	kind := dedupeFlags.String("hash", hashDHash, "Perceptual hash: dhash, phash which tolerates more edits, or both which must both be close")
+	threshold := dedupeFlags.Int("threshold", defaultDuplicateThreshold, "Largest number of differing hash bits, out of 64, for two pages to be the same")
+	drop := dedupeFlags.Bool("drop", false, "Rewrite the files without the duplicate pages")
+	runSilent := dedupeFlags.Bool("s", false, "Only print files with duplicates")
+	runVerbose := dedupeFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	dedupeFlags.Parse(args)
+
+	if dedupeFlags.NArg() == 0 {
+		fmt.Printf("cbztools dedupe-pages v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools dedupe-pages [flags] <file...>")
+		fmt.Println("Flags:")
+		dedupeFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	if _, err := parsePageHash(*kind); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *threshold < 0 || *threshold > 64 {
+		fmt.Printf("Invalid threshold %d, expected 0 to 64\n", *threshold)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, input := range dedupeFlags.Args() {
+		format := outputFormat(input)
+		if *drop && format == "" {
+			fmt.Printf("Can't rewrite %s, convert it to CBZ first\n", input)
+			failed++
+			continue
+		}
+		book, err := readBook(input)
+		if err != nil {
+			fmt.Printf("Could not read %s: %v\n", input, err)
+			failed++
+			continue
+		}
+		duplicates, errs := findDuplicatePages(hashPages(book.Pages), *kind, *threshold)
+		for i, err := range errs {
+			printIfVerbose(fmt.Sprintf("%s: can't hash %s: %v", input, book.describePage(i), err), runVerbose)
+		}
+		if len(duplicates) == 0 {
+			book.Close()
+			printIfNotSilent(fmt.Sprintf("%s: no duplicate pages", input), runSilent, runVerbose)
+			continue
+		}
+		fmt.Printf("%s: %d duplicate pages\n", input, len(duplicates))
+		printDuplicatePages(os.Stdout, book, duplicates)
+		if !*drop {
+			book.Close()
+			continue
+		}
+
+		book.dropDuplicatePages(duplicates)
+		book.Info.PageCount = len(book.Pages)
+		err = rewriteBook(book, input, format)
+		book.Close()
+		if err != nil {
+			fmt.Printf("Could not rewrite %s: %v\n", input, err)
+			failed++
+			continue
+		}
+		printIfNotSilent(fmt.Sprintf("Rewrote %s without them, %d pages left", input, len(book.Pages)), runSilent, runVerbose)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testPatternImage draws a page of overlapping waves and a panel picked by seed, so different seeds make clearly
+// different pages
+func testPatternImage(seed int, width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fx, fy := float64(seed%3+1)*2*math.Pi, float64(seed%5+1)*2*math.Pi
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u, v := float64(x)/float64(width), float64(y)/float64(height)
+			l := 128 + 50*math.Sin(fx*u+float64(seed)) + 50*math.Cos(fy*v*u+float64(seed*seed))
+			// A dark panel, placed by the seed
+			if x > width*(seed%4)/5 && x < width*(seed%4+1)/5 && y > height*(seed%3)/4 && y < height*(seed%3+2)/4 {
+				l = 20
+			}
+			img.SetRGBA(x, y, color.RGBA{uint8(l), uint8(l), uint8(l), 255})
+		}
+	}
+	return img
+}
+
+func testPatternPage(t *testing.T, name string, seed int, width int, height int) Page {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testPatternImage(seed, width, height)); err != nil {
+		t.Fatal(err)
+	}
+	return newBytesPage(name, buf.Bytes())
+}
+
+// testBlankPage is a page of a single light gray
+func testBlankPage(t *testing.T, name string, width int, height int) Page {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 250
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return newBytesPage(name, buf.Bytes())
+}
+
+func TestHashDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     uint64
+		expected int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xff, 0x0f, 4},
+		{0, ^uint64(0), 64},
+	}
+	for _, tc := range testCases {
+		if d := hashDistance(tc.a, tc.b); d != tc.expected {
+			t.Errorf("Expected distance %d between %x and %x, got %d", tc.expected, tc.a, tc.b, d)
+		}
+	}
+}
+
+func TestPageHashes(t *testing.T) {
+	hashes := map[string]func(image.Image) uint64{hashDHash: dHash, hashPHash: pHash}
+	for name, hash := range hashes {
+		for seed := 0; seed < 4; seed++ {
+			original := testPatternImage(seed, 400, 600)
+			// A smaller, recompressed copy is the same page
+			data, err := encodeJPEG(resizeArea(original, 300, 450))
+			if err != nil {
+				t.Fatal(err)
+			}
+			copied, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := hashDistance(hash(original), hash(copied)); d > defaultDuplicateThreshold {
+				t.Errorf("%s: expected a copy of pattern %d to be within %d, got %d", name, seed, defaultDuplicateThreshold, d)
+			}
+			other := testPatternImage(seed+1, 400, 600)
+			if d := hashDistance(hash(original), hash(other)); d <= defaultDuplicateThreshold {
+				t.Errorf("%s: expected patterns %d and %d to differ by more than %d, got %d", name, seed, seed+1, defaultDuplicateThreshold, d)
+			}
+		}
+	}
+}
+
+func TestFindDuplicatePages(t *testing.T) {
+	book := &Book{}
+	for i, chapter := range [][]int{{0, 1}, {0, 2}, {3, 1}} {
+		c := &Book{}
+		for j, seed := range chapter {
+			c.Pages = append(c.Pages, testPatternPage(t, string(rune('a'+j))+".png", seed, 80+i*10, 120+i*15))
+		}
+		book.Append(c, "Ch."+string(rune('1'+i)))
+	}
+	book.Pages = append(book.Pages, newBytesPage("broken.png", []byte("not an image")))
+	book.Pages[len(book.Pages)-1].Chapter = 2
+
+	for _, kind := range []string{hashDHash, hashPHash, hashBoth} {
+		duplicates, errs := findDuplicatePages(hashPages(book.Pages), kind, defaultDuplicateThreshold)
+		if len(errs) != 1 || errs[6] == nil {
+			t.Errorf("%s: expected the broken page to fail, got %v", kind, errs)
+		}
+		if len(duplicates) != 2 || duplicates[0].Page != 2 || duplicates[0].Original != 0 || duplicates[1].Page != 5 || duplicates[1].Original != 1 {
+			t.Errorf("%s: expected pages 3 and 6 to repeat 1 and 2, got %+v", kind, duplicates)
+		}
+	}
+
+	// Pages that only look alike to one hash are duplicates for that hash, but not for both
+	similar := []pageHash{{DHash: 0, PHash: 0}, {DHash: 1, PHash: 0xffff}, {DHash: 7, PHash: 1}}
+	if duplicates, _ := findDuplicatePages(similar, hashDHash, defaultDuplicateThreshold); len(duplicates) != 2 {
+		t.Errorf("Expected pages 2 and 3 to repeat page 1 by dhash, got %+v", duplicates)
+	}
+	if duplicates, _ := findDuplicatePages(similar, hashBoth, defaultDuplicateThreshold); len(duplicates) != 1 || duplicates[0].Page != 2 || duplicates[0].Distance != 3 {
+		t.Errorf("Expected only page 3 to repeat page 1 by both hashes at distance 3, got %+v", duplicates)
+	}
+
+	duplicates, _ := findDuplicatePages(hashPages(book.Pages), hashDHash, defaultDuplicateThreshold)
+	var report strings.Builder
+	printDuplicatePages(&report, book, duplicates)
+	if !strings.Contains(report.String(), `page 3 (a.png) of "Ch.2" is a duplicate of page 1 (a.png) of "Ch.1"`) {
+		t.Errorf("Expected the report to name pages and chapters, got:\n%s", report.String())
+	}
+
+	book.dropDuplicatePages(duplicates)
+	if len(book.Pages) != 5 || len(book.Chapters) != 3 || book.Chapters[1].FirstPage != 2 || book.Chapters[2].FirstPage != 3 {
+		t.Errorf("Expected 5 pages in 3 chapters starting at 0, 2 and 3, got %d pages in %+v", len(book.Pages), book.Chapters)
+	}
+}
+
+func TestFindDuplicatePagesBlank(t *testing.T) {
+	book := &Book{Pages: []Page{
+		testBlankPage(t, "blank.png", 80, 120),
+		testPatternPage(t, "a.png", 0, 80, 120),
+		testBlankPage(t, "blank2.png", 100, 150),
+		testPatternPage(t, "b.png", 0, 100, 150),
+	}}
+
+	for _, kind := range []string{hashDHash, hashPHash} {
+		duplicates, errs := findDuplicatePages(hashPages(book.Pages), kind, defaultDuplicateThreshold)
+		if len(errs) != 0 || len(duplicates) != 1 || duplicates[0].Page != 3 || duplicates[0].Original != 1 {
+			t.Errorf("%s: expected only page 4 to repeat page 2, the blank pages to be left alone, got %+v, %v", kind, duplicates, errs)
+		}
+	}
+}
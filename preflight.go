@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Policies for inputs that fail pre-flight validation in concat
+const (
+	onErrorAbort = "abort"
+	onErrorSkip  = "skip"
+	onErrorWarn  = "warn"
+)
+
+// inputProblem is a reason an input failed pre-flight validation. Fatal problems mean the input can't be merged at all.
+type inputProblem struct {
+	Path   string
+	Reason string
+	Fatal  bool
+}
+
+// parseOnErrorPolicy checks the --on-error flag value
+func parseOnErrorPolicy(value string) (string, error) {
+	switch value {
+	case onErrorAbort, onErrorSkip, onErrorWarn:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid on-error policy %q, expected %s, %s or %s", value, onErrorAbort, onErrorSkip, onErrorWarn)
+}
+
+// validateInput opens an input and checks that it can be merged: it must be readable, have pages, every page must
+// be a valid image and archives must have a ComicInfo.xml or another metadata sidecar. The book is nil if it
+// couldn't be read.
+func validateInput(filePath string) (*Book, []inputProblem) {
+	book, err := readBook(filePath)
+	if err != nil {
+		return nil, []inputProblem{{Path: filePath, Reason: fmt.Sprintf("could not be read: %v", err), Fatal: true}}
+	}
+
+	var problems []inputProblem
+	if len(book.Pages) == 0 {
+		problems = append(problems, inputProblem{Path: filePath, Reason: "no pages", Fatal: true})
+	}
+	var report verifyReport
+	verifyPages(&report, book.Pages, false)
+	for _, f := range report.Findings {
+		if f.Severity == severityError {
+			problems = append(problems, inputProblem{Path: filePath, Reason: fmt.Sprintf("%s: %s", f.Subject, f.Message)})
+		}
+	}
+	switch inputFormat(filePath) {
+	case "pdf", "epub":
+		// Metadata lives in the document itself
+	default:
+		if book.InfoSource == metaSourceNone {
+			problems = append(problems, inputProblem{Path: filePath, Reason: "no ComicInfo.xml or other metadata"})
+		}
+	}
+	return book, problems
+}
+
+// applyOnErrorPolicy decides which inputs are merged. With abort, any problem stops the merge (ok is false). With skip,
+// inputs with problems are left out. With warn, only inputs with fatal problems are left out.
+// It returns the paths of the inputs left out, in input order.
+func applyOnErrorPolicy(inputs []string, problems []inputProblem, policy string) (kept []string, skipped []string, ok bool) {
+	if policy == onErrorAbort && len(problems) > 0 {
+		return nil, nil, false
+	}
+	drop := make(map[string]bool)
+	for _, p := range problems {
+		if p.Fatal || policy == onErrorSkip {
+			drop[p.Path] = true
+		}
+	}
+	for _, input := range inputs {
+		if drop[input] {
+			skipped = append(skipped, input)
+		} else {
+			kept = append(kept, input)
+		}
+	}
+	return kept, skipped, true
+}
+
+// printInputProblems prints the problems found in the inputs, one per line
+func printInputProblems(w io.Writer, problems []inputProblem) {
+	for _, p := range problems {
+		fmt.Fprintf(w, "  %s: %s\n", p.Path, p.Reason)
+	}
+}
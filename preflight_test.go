@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOnErrorPolicy(t *testing.T) {
+	for _, value := range []string{onErrorAbort, onErrorSkip, onErrorWarn} {
+		if policy, err := parseOnErrorPolicy(value); err != nil || policy != value {
+			t.Errorf("Expected %q to be valid, got %q, %v", value, policy, err)
+		}
+	}
+	if _, err := parseOnErrorPolicy("ignore"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	dir := t.TempDir()
+	page, _ := testPNGPage(t, "page.png", 10, 20).ReadAll()
+	writeCBZ := func(name string, files map[string][]byte) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, entry := range []string{"1.png", "2.png", "ComicInfo.xml"} {
+			if data, ok := files[entry]; ok {
+				w, _ := zw.Create(entry)
+				w.Write(data)
+			}
+		}
+		zw.Close()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	comicInfo := []byte("<ComicInfo><Series>S</Series></ComicInfo>")
+	notAZip := filepath.Join(dir, "broken.cbz")
+	os.WriteFile(notAZip, []byte("not a zip"), 0o644)
+
+	testCases := []struct {
+		description string
+		path        string
+		readable    bool
+		reasons     []string
+		fatal       bool
+	}{
+		{"valid", writeCBZ("valid.cbz", map[string][]byte{"1.png": page, "ComicInfo.xml": comicInfo}), true, nil, false},
+		{"unreadable", notAZip, false, []string{"could not be read"}, true},
+		{"no ComicInfo", writeCBZ("noinfo.cbz", map[string][]byte{"1.png": page}), true, []string{"no ComicInfo.xml"}, false},
+		{"no pages", writeCBZ("empty.cbz", map[string][]byte{"ComicInfo.xml": comicInfo}), true, []string{"no pages"}, true},
+		{"broken page", writeCBZ("badpage.cbz", map[string][]byte{"1.png": page, "2.png": []byte("garbage"), "ComicInfo.xml": comicInfo}), true, []string{"page 2 (2.png): not a valid image"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			book, problems := validateInput(tc.path)
+			if book != nil {
+				defer book.Close()
+			}
+			if (book != nil) != tc.readable {
+				t.Errorf("Expected readable to be %v", tc.readable)
+			}
+			if len(problems) != len(tc.reasons) {
+				t.Fatalf("Expected %d problems, got %+v", len(tc.reasons), problems)
+			}
+			for i, p := range problems {
+				if !strings.HasPrefix(p.Reason, tc.reasons[i]) || p.Fatal != tc.fatal || p.Path != tc.path {
+					t.Errorf("Expected problem %q (fatal %v), got %+v", tc.reasons[i], tc.fatal, p)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyOnErrorPolicy(t *testing.T) {
+	inputs := []string{"a.cbz", "b.cbz", "c.cbz", "d.cbz"}
+	problems := []inputProblem{
+		{Path: "b.cbz", Reason: "could not be read", Fatal: true},
+		{Path: "c.cbz", Reason: "no ComicInfo.xml"},
+	}
+
+	testCases := []struct {
+		policy  string
+		kept    []string
+		skipped []string
+		ok      bool
+	}{
+		{onErrorAbort, nil, nil, false},
+		{onErrorSkip, []string{"a.cbz", "d.cbz"}, []string{"b.cbz", "c.cbz"}, true},
+		{onErrorWarn, []string{"a.cbz", "c.cbz", "d.cbz"}, []string{"b.cbz"}, true},
+	}
+
+	for _, tc := range testCases {
+		kept, skipped, ok := applyOnErrorPolicy(inputs, problems, tc.policy)
+		if !reflect.DeepEqual(kept, tc.kept) || !reflect.DeepEqual(skipped, tc.skipped) || ok != tc.ok {
+			t.Errorf("Expected %s to keep %v and skip %v (ok %v), got %v, %v (ok %v)", tc.policy, tc.kept, tc.skipped, tc.ok, kept, skipped, ok)
+		}
+	}
+
+	if kept, skipped, ok := applyOnErrorPolicy(inputs, nil, onErrorAbort); !ok || len(kept) != 4 || skipped != nil {
+		t.Errorf("Expected abort to keep all inputs without problems, got %v, %v", kept, skipped)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mozillazg/go-unidecode"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Filename sanitization profiles
+const (
+	profileASCII       = "ascii"
+	profileUnicode     = "unicode"
+	profileWindowsSafe = "windows-safe"
+	profilePosixMin    = "posix-min"
+)
+
+// maxFilenameBytes is the file name length limit of most filesystems
+const maxFilenameBytes = 255
+
+// posixIllegalRegex matches the only characters POSIX forbids in a file name
+var posixIllegalRegex = regexp.MustCompile("[/\x00]+")
+
+// windowsReservedRegex matches device names Windows reserves, with or without an extension
+var windowsReservedRegex = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9¹²³]|lpt[0-9¹²³])(\..*)?$`)
+
+// sanitizeProfile is a way of turning metadata into a file name.
+// Sanitize is used for the whole name by default, and by the `sanitize` template helper.
+// Finalize is always applied to the rendered name, and must keep whatever Sanitize produces intact.
+type sanitizeProfile struct {
+	Name     string
+	Sanitize func(string) string
+	Finalize func(string) string
+}
+
+var sanitizeProfiles = map[string]sanitizeProfile{
+	profileASCII:       {profileASCII, sanitizeFilenameASCII, finalizeFilenameASCII},
+	profileUnicode:     {profileUnicode, sanitizeFilenameUnicode, sanitizeFilenameUnicode},
+	profileWindowsSafe: {profileWindowsSafe, sanitizeFilenameWindows, sanitizeFilenameWindows},
+	profilePosixMin:    {profilePosixMin, sanitizeFilenamePosix, sanitizeFilenamePosix},
+}
+
+// getSanitizeProfile looks up a profile by name
+func getSanitizeProfile(name string) (sanitizeProfile, error) {
+	profile, ok := sanitizeProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return sanitizeProfile{}, fmt.Errorf("unknown sanitize profile %q, expected %s, %s, %s or %s",
+			name, profileASCII, profileUnicode, profileWindowsSafe, profilePosixMin)
+	}
+	return profile, nil
+}
+
+// sanitizeFilenameUnicode keeps all scripts, normalizes to NFC and replaces only characters
+// that are illegal in file names on common filesystems
+func sanitizeFilenameUnicode(name string) string {
+	name = norm.NFC.String(name)
+	name = illegalPathRegex.ReplaceAllString(name, "_")
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == ".." {
+		return "untitled"
+	}
+	return name
+}
+
+// finalizeFilenameASCII transliterates to ASCII and replaces illegal characters, keeping spaces and dots, so
+// templates that don't sanitize their fields still give ASCII names
+func finalizeFilenameASCII(name string) string {
+	return sanitizeFilenameUnicode(unidecode.Unidecode(name))
+}
+
+// sanitizeFilenameWindows is sanitizeFilenameUnicode that also avoids reserved device names,
+// trailing dots and spaces, and names longer than maxFilenameBytes
+func sanitizeFilenameWindows(name string) string {
+	name = sanitizeFilenameUnicode(name)
+	name = truncateUTF8(name, maxFilenameBytes)
+	name = strings.TrimRight(name, ". ")
+	if windowsReservedRegex.MatchString(name) {
+		name = "_" + name
+	}
+
+	if name == "" {
+		return "untitled"
+	}
+	return truncateUTF8(name, maxFilenameBytes)
+}
+
+// sanitizeFilenamePosix only replaces "/" and NUL, which no POSIX filesystem allows
+func sanitizeFilenamePosix(name string) string {
+	name = posixIllegalRegex.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		return "untitled"
+	}
+	return name
+}
+
+// truncateUTF8 cuts s to at most maxBytes bytes without splitting a multi-byte character
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
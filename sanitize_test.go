@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetSanitizeProfile(t *testing.T) {
+	for _, name := range []string{"ascii", "unicode", "windows-safe", "posix-min", " Unicode "} {
+		profile, err := getSanitizeProfile(name)
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %v", name, err)
+		} else if profile.Name != strings.ToLower(strings.TrimSpace(name)) {
+			t.Errorf("Expected profile '%s', got '%s'", name, profile.Name)
+		}
+	}
+	if _, err := getSanitizeProfile("utf8"); err == nil {
+		t.Errorf("Expected an error for an unknown profile")
+	}
+}
+
+func TestSanitizeFilenameUnicode(t *testing.T) {
+	testCases := []struct {
+		name        string
+		expected    string
+		description string
+	}{
+		{"Vol.01 Ch.0001 - あなたはどうですか?", "Vol.01 Ch.0001 - あなたはどうですか_", "Scripts, dots and spaces are kept"},
+		{"Ёлка: Часть 1", "Ёлка_ Часть 1", "Cyrillic is kept, colon is replaced"},
+		{"e\u0301te\u0301", "\u00e9t\u00e9", "Decomposed characters are normalized to NFC"},
+		{"a/b\\c<d>e|f*g\"h", "a_b_c_d_e_f_g_h", "Filesystem-illegal characters are replaced"},
+		{"tab\there", "tab_here", "Control characters are replaced"},
+		{"  padded  ", "padded", "Surrounding spaces are trimmed"},
+		{"", "untitled", "Empty name"},
+		{"..", "untitled", "Parent directory name"},
+	}
+
+	for _, tc := range testCases {
+		result := sanitizeFilenameUnicode(tc.name)
+		if result != tc.expected {
+			t.Errorf("Test '%s': Expected '%s', got '%s'", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestSanitizeFilenameWindows(t *testing.T) {
+	testCases := []struct {
+		name        string
+		expected    string
+		description string
+	}{
+		{"Series Ch.1-2", "Series Ch.1-2", "Regular name is kept"},
+		{"CON", "_CON", "Reserved device name"},
+		{"con.txt", "_con.txt", "Reserved device name with extension"},
+		{"LPT9", "_LPT9", "Numbered reserved device name"},
+		{"CONSOLE", "CONSOLE", "Longer names are not reserved"},
+		{"Series...", "Series", "Trailing dots are removed"},
+		{"Series. . ", "Series", "Trailing dots and spaces are removed"},
+		{"...", "untitled", "Only dots"},
+		{strings.Repeat("a", 300), strings.Repeat("a", 255), "Long names are truncated to 255 bytes"},
+		{strings.Repeat("あ", 100), strings.Repeat("あ", 85), "Truncation doesn't split characters"},
+	}
+
+	for _, tc := range testCases {
+		result := sanitizeFilenameWindows(tc.name)
+		if result != tc.expected {
+			t.Errorf("Test '%s': Expected '%s', got '%s'", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestSanitizeFilenamePosix(t *testing.T) {
+	testCases := []struct {
+		name        string
+		expected    string
+		description string
+	}{
+		{"a:b?c*d", "a:b?c*d", "Windows-illegal characters are kept"},
+		{"a/b//c", "a_b_c", "Slashes are replaced"},
+		{"a\x00b", "a_b", "NUL is replaced"},
+		{" .hidden. ", " .hidden. ", "Dots and spaces are kept"},
+		{".", "untitled", "Current directory name"},
+		{"", "untitled", "Empty name"},
+	}
+
+	for _, tc := range testCases {
+		result := sanitizeFilenamePosix(tc.name)
+		if result != tc.expected {
+			t.Errorf("Test '%s': Expected '%s', got '%s'", tc.description, tc.expected, result)
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	testCases := []struct {
+		s        string
+		maxBytes int
+		expected string
+	}{
+		{"abc", 5, "abc"},
+		{"abcdef", 3, "abc"},
+		{"aあ", 3, "a"},
+		{"aあ", 4, "aあ"},
+		{"", 0, ""},
+	}
+
+	for _, tc := range testCases {
+		result := truncateUTF8(tc.s, tc.maxBytes)
+		if result != tc.expected {
+			t.Errorf("Expected truncateUTF8(%q, %d) to be %q, got %q", tc.s, tc.maxBytes, tc.expected, result)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"path"
+	"strings"
+)
+
+// What to do with double-page spreads, landscape pages
+const (
+	spreadsSplit  = "split"  // cut into two pages, in reading order
+	spreadsRotate = "rotate" // turn to portrait, the first page to read on top
+	spreadsKeep   = "keep"   // leave the image, mark it DoublePage
+)
+
+// parseSpreadsPolicy checks the --spreads flag value, empty leaves spreads alone
+func parseSpreadsPolicy(value string) (string, error) {
+	switch value {
+	case "", spreadsSplit, spreadsRotate, spreadsKeep:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid spreads policy %q, expected %s, %s or %s", value, spreadsSplit, spreadsRotate, spreadsKeep)
+}
+
+// splitSpread cuts a spread into its halves, the one read first first: the left half, or the right one for
+// right-to-left books
+func splitSpread(img image.Image, rightToLeft bool) (image.Image, image.Image) {
+	b := img.Bounds()
+	middle := b.Min.X + b.Dx()/2
+	left := cropImage(img, image.Rect(b.Min.X, b.Min.Y, middle, b.Max.Y))
+	right := cropImage(img, image.Rect(middle, b.Min.Y, b.Max.X, b.Max.Y))
+	if rightToLeft {
+		return right, left
+	}
+	return left, right
+}
+
+// rotateSpread turns a spread to portrait with the half read first on top: clockwise, or counterclockwise for
+// right-to-left books
+func rotateSpread(img image.Image, rightToLeft bool) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			if rightToLeft {
+				dst.Set(y, w-1-x, c)
+			} else {
+				dst.Set(h-1-y, x, c)
+			}
+		}
+	}
+	return dst
+}
+
+// spreadPageData applies the policy to a page if it's a spread, and returns the pages it becomes and whether it
+// was a spread
+func spreadPageData(page Page, data []byte, policy string, rightToLeft bool) ([]Page, bool, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return []Page{page}, false, err
+	}
+	if config.Width <= config.Height {
+		return []Page{page}, false, nil
+	}
+	if policy == spreadsKeep {
+		page.DoublePage = true
+		return []Page{page}, true, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return []Page{page}, true, err
+	}
+	if policy == spreadsRotate {
+		encoded, name, err := encodePage(rotateSpread(img, rightToLeft), page.Name)
+		if err != nil {
+			return []Page{page}, true, err
+		}
+		rotated := page.withData(name, encoded)
+		rotated.DoublePage = false
+		return []Page{rotated}, true, nil
+	}
+
+	var pages []Page
+	first, second := splitSpread(img, rightToLeft)
+	for i, half := range []image.Image{first, second} {
+		ext := path.Ext(page.Name)
+		encoded, name, err := encodePage(half, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(page.Name, ext), i+1, ext))
+		if err != nil {
+			return []Page{page}, true, err
+		}
+		half := page.withData(name, encoded)
+		half.DoublePage = false
+		// A cover type stays with the half read first
+		if i > 0 {
+			half.Type = ""
+		}
+		pages = append(pages, half)
+	}
+	return pages, true, nil
+}
+
+// transformSpreads applies the policy to every landscape page, splitting and rotating in the reading direction of
+// the book's Manga setting. It returns the spreads, described as they were before, and the errors of pages that
+// couldn't be read, which are left as they are.
+func (b *Book) transformSpreads(policy string) ([]string, []error) {
+	rightToLeft := b.Info.Manga == mangaRightToLeft
+	results := make([][]Page, len(b.Pages))
+	isSpread := make([]bool, len(b.Pages))
+	errs := make([]error, len(b.Pages))
+	processPages(b.Pages, func(i int, data []byte, err error) {
+		if err != nil {
+			results[i], errs[i] = []Page{b.Pages[i]}, err
+			return
+		}
+		results[i], isSpread[i], errs[i] = spreadPageData(b.Pages[i], data, policy, rightToLeft)
+	})
+
+	var spreads []string
+	var failed []error
+	var pages []Page
+	for i := range b.Pages {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", b.describePage(i), errs[i]))
+		} else if isSpread[i] {
+			spreads = append(spreads, b.describePage(i))
+		}
+		pages = append(pages, results[i]...)
+	}
+	b.Pages = pages
+	b.renumberChapters()
+	return spreads, failed
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+// testSpreadImage draws a spread with a red left half and a blue right half
+func testSpreadImage(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+func testSpreadPage(t *testing.T, name string, width int, height int) Page {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testSpreadImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+	return newBytesPage(name, buf.Bytes())
+}
+
+// pageColor decodes a page and returns the color at x, y
+func pageColor(t *testing.T, page Page, x int, y int) color.RGBA {
+	data, err := page.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return color.RGBAModel.Convert(img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y)).(color.RGBA)
+}
+
+func TestParseSpreadsPolicy(t *testing.T) {
+	for _, value := range []string{"", spreadsSplit, spreadsRotate, spreadsKeep} {
+		if result, err := parseSpreadsPolicy(value); err != nil || result != value {
+			t.Errorf("Expected %q to be valid, got %q, %v", value, result, err)
+		}
+	}
+	if _, err := parseSpreadsPolicy("crop"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestSplitSpread(t *testing.T) {
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	testCases := []struct {
+		description   string
+		rightToLeft   bool
+		expectedFirst color.RGBA
+	}{
+		{"left to right", false, red},
+		{"right to left", true, blue},
+	}
+
+	for _, tc := range testCases {
+		first, second := splitSpread(testSpreadImage(80, 50), tc.rightToLeft)
+		if first.Bounds().Dx() != 40 || second.Bounds().Dx() != 40 || first.Bounds().Dy() != 50 {
+			t.Errorf("%s: expected two 40x50 halves, got %v and %v", tc.description, first.Bounds(), second.Bounds())
+		}
+		b := first.Bounds()
+		if c := color.RGBAModel.Convert(first.At(b.Min.X, b.Min.Y)); c != tc.expectedFirst {
+			t.Errorf("%s: expected the first half to be %v, got %v", tc.description, tc.expectedFirst, c)
+		}
+	}
+}
+
+func TestRotateSpread(t *testing.T) {
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	testCases := []struct {
+		description string
+		rightToLeft bool
+		expectedTop color.RGBA
+	}{
+		{"clockwise, left half on top", false, red},
+		{"counterclockwise, right half on top", true, blue},
+	}
+
+	for _, tc := range testCases {
+		img := testSpreadImage(80, 50)
+		img.SetRGBA(0, 0, color.RGBA{0, 255, 0, 255})
+		rotated := rotateSpread(img, tc.rightToLeft)
+		if rotated.Bounds().Dx() != 50 || rotated.Bounds().Dy() != 80 {
+			t.Errorf("%s: expected 50x80, got %v", tc.description, rotated.Bounds())
+		}
+		if c := rotated.RGBAAt(25, 10); c != tc.expectedTop {
+			t.Errorf("%s: expected %v on top, got %v", tc.description, tc.expectedTop, c)
+		}
+		// The top left corner of the spread goes to the top right clockwise, the bottom left counterclockwise
+		corner := image.Pt(49, 0)
+		if tc.rightToLeft {
+			corner = image.Pt(0, 79)
+		}
+		if c := rotated.RGBAAt(corner.X, corner.Y); c.G != 255 {
+			t.Errorf("%s: expected the corner at %v, got %v there", tc.description, corner, c)
+		}
+	}
+}
+
+func TestTransformSpreads(t *testing.T) {
+	testCases := []struct {
+		policy             string
+		manga              string
+		expectedNames      []string
+		expectedFirstPages []int
+	}{
+		{spreadsKeep, "", []string{"page.png", "spread.png", "page.png", "page.png"}, []int{0, 2}},
+		{spreadsRotate, "", []string{"page.png", "spread.png", "page.png", "page.png"}, []int{0, 2}},
+		{spreadsSplit, "", []string{"page.png", "spread-1.png", "spread-2.png", "page.png", "page.png"}, []int{0, 3}},
+		{spreadsSplit, mangaRightToLeft, []string{"page.png", "spread-1.png", "spread-2.png", "page.png", "page.png"}, []int{0, 3}},
+	}
+
+	for _, tc := range testCases {
+		book := testBook(t, ComicInfo{Manga: tc.manga}, 2, 2)
+		book.Pages[1] = testSpreadPage(t, "spread.png", 80, 50)
+		book.Pages[1].Type = "Story"
+
+		spreads, errs := book.transformSpreads(tc.policy)
+		if len(errs) != 0 || len(spreads) != 1 || spreads[0] != "page 2 (spread.png)" {
+			t.Errorf("%s: expected page 2 to be the spread, got %v, %v", tc.policy, spreads, errs)
+		}
+		if len(book.Pages) != len(tc.expectedNames) {
+			t.Fatalf("%s: expected %d pages, got %d", tc.policy, len(tc.expectedNames), len(book.Pages))
+		}
+		for i, name := range tc.expectedNames {
+			if book.Pages[i].Name != name {
+				t.Errorf("%s: expected page %d to be %s, got %s", tc.policy, i, name, book.Pages[i].Name)
+			}
+			if book.Pages[i].DoublePage != (tc.policy == spreadsKeep && i == 1) {
+				t.Errorf("%s: expected only a kept spread to be a double page, page %d is %v", tc.policy, i, book.Pages[i].DoublePage)
+			}
+		}
+		for i, chapter := range book.Chapters {
+			if chapter.FirstPage != tc.expectedFirstPages[i] {
+				t.Errorf("%s: expected chapter %d to start at page %d, got %d", tc.policy, i, tc.expectedFirstPages[i], chapter.FirstPage)
+			}
+		}
+
+		switch tc.policy {
+		case spreadsRotate:
+			if width, height := pageSize(t, book.Pages[1]); width != 50 || height != 80 {
+				t.Errorf("%s: expected a 50x80 page, got %dx%d", tc.policy, width, height)
+			}
+		case spreadsSplit:
+			expected := color.RGBA{255, 0, 0, 255}
+			if tc.manga == mangaRightToLeft {
+				expected = color.RGBA{0, 0, 255, 255}
+			}
+			if c := pageColor(t, book.Pages[1], 0, 0); c != expected {
+				t.Errorf("%s %s: expected the first half to be %v, got %v", tc.policy, tc.manga, expected, c)
+			}
+			if book.Pages[1].Type != "Story" || book.Pages[2].Type != "" {
+				t.Errorf("%s: expected the page type on the first half only, got %q and %q", tc.policy, book.Pages[1].Type, book.Pages[2].Type)
+			}
+		}
+	}
+}
+
+func TestDoublePageRoundTrip(t *testing.T) {
+	book := testBook(t, ComicInfo{Series: "Series"}, 3)
+	book.Pages[1] = testSpreadPage(t, "spread.png", 80, 50)
+	book.transformSpreads(spreadsKeep)
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	if err := writeBook(book, path, formatCBZ); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := readBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+	for i, expected := range []bool{false, true, false} {
+		if result.Pages[i].DoublePage != expected {
+			t.Errorf("Expected page %d DoublePage to be %v, got %v", i, expected, result.Pages[i].DoublePage)
+		}
+	}
+	if report := verifyFile(path, false); len(report.Findings) > 0 {
+		t.Errorf("Expected no findings, got %+v", report.Findings)
+	}
+}
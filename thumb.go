@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// thumbIndexName is the index in the thumbnail directory that maps files to their hash and thumbnail
+const thumbIndexName = "thumbs.json"
+
+// thumbExtension is the extension of the thumbnails, which are JPEGs
+const thumbExtension = ".jpg"
+
+// thumbOptions are the settings of a thumbnail run
+type thumbOptions struct {
+	Width   int // 0 leaves the width free
+	Height  int // 0 leaves the height free
+	Workers int
+	Force   bool // regenerate thumbnails that are up to date
+}
+
+// thumbIndex is thumbs.json, by absolute path of the file. It lets unchanged files be skipped without hashing them
+// again, and lets a catalog find the thumbnail of a file.
+type thumbIndex struct {
+	Files map[string]thumbEntry `json:"files"`
+}
+
+// thumbEntry is a file in the index: its size and modification time when it was hashed, and the thumbnail made from it
+type thumbEntry struct {
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	SHA256    string    `json:"sha256"`
+	Thumbnail string    `json:"thumbnail"` // name in the thumbnail directory
+	Width     int       `json:"width"`     // the box the thumbnail was fitted in
+	Height    int       `json:"height"`
+}
+
+// thumbResult is the outcome for one file
+type thumbResult struct {
+	Path      string
+	Thumbnail string // path of the thumbnail
+	Generated bool   // false if it was up to date
+	Err       error
+}
+
+// readThumbIndex reads thumbs.json from the thumbnail directory, an empty index if there's none yet
+func readThumbIndex(dir string) (thumbIndex, error) {
+	index := thumbIndex{Files: make(map[string]thumbEntry)}
+	data, err := os.ReadFile(filepath.Join(dir, thumbIndexName))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, fmt.Errorf("%s: %w, delete it to start over", thumbIndexName, err)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]thumbEntry)
+	}
+	return index, nil
+}
+
+// writeThumbIndex writes thumbs.json into the thumbnail directory
+func writeThumbIndex(dir string, index thumbIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, thumbIndexName), append(data, '\n'))
+}
+
+// writeFileAtomic writes a file through a temporary file and a rename, so readers, and other workers writing the
+// same file, never see it half written
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex SHA-256 of a file's contents
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// thumbnailImage decodes a page and encodes it fitted in a width×height box, as a JPEG
+func thumbnailImage(page Page, width int, height int) ([]byte, error) {
+	data, err := page.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return encodeJPEG(scaleToFit(img, width, height))
+}
+
+// generateThumbnails writes a thumbnail of the cover of every file into dir, named by the SHA-256 of the file, and
+// records them in thumbs.json. Files whose size and modification time are unchanged since the index last saw them,
+// and whose thumbnail exists with the same settings, are skipped. Files are processed in parallel.
+func generateThumbnails(files []string, dir string, opts thumbOptions) ([]thumbResult, error) {
+	index, err := readThumbIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]thumbResult, len(files))
+	entries := make([]thumbEntry, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], entries[i] = thumbFile(files[i], dir, opts, index.Files)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, result := range results {
+		if result.Err == nil {
+			abs, _ := filepath.Abs(files[i])
+			index.Files[abs] = entries[i]
+		}
+	}
+	return results, writeThumbIndex(dir, index)
+}
+
+// thumbFile makes the thumbnail of one file, unless the index shows it is up to date. The index is only read.
+func thumbFile(path string, dir string, opts thumbOptions, index map[string]thumbEntry) (thumbResult, thumbEntry) {
+	result := thumbResult{Path: path}
+	stat, err := os.Stat(path)
+	if err != nil {
+		result.Err = err
+		return result, thumbEntry{}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		result.Err = err
+		return result, thumbEntry{}
+	}
+
+	entry := thumbEntry{Size: stat.Size(), Modified: stat.ModTime().UTC(), Width: opts.Width, Height: opts.Height}
+	previous, ok := index[abs]
+	unchanged := ok && previous.Size == entry.Size && previous.Modified.Equal(entry.Modified)
+	if unchanged {
+		entry.SHA256 = previous.SHA256
+	} else if entry.SHA256, err = fileSHA256(path); err != nil {
+		result.Err = err
+		return result, thumbEntry{}
+	}
+	entry.Thumbnail = entry.SHA256 + thumbExtension
+	result.Thumbnail = filepath.Join(dir, entry.Thumbnail)
+
+	if unchanged && !opts.Force && previous == entry {
+		if _, err := os.Stat(result.Thumbnail); err == nil {
+			return result, entry
+		}
+	}
+
+	book, err := readBook(path)
+	if err != nil {
+		result.Err = err
+		return result, thumbEntry{}
+	}
+	defer book.Close()
+	cover, err := book.frontCover()
+	if err != nil {
+		result.Err = err
+		return result, thumbEntry{}
+	}
+	data, err := thumbnailImage(cover, opts.Width, opts.Height)
+	if err != nil {
+		result.Err = fmt.Errorf("cover %s: %w", cover.Name, err)
+		return result, thumbEntry{}
+	}
+	if err := writeFileAtomic(result.Thumbnail, data); err != nil {
+		result.Err = err
+		return result, thumbEntry{}
+	}
+	result.Generated = true
+	return result, entry
+}
+
+// collectBookFiles expands directories into the supported files under them, files are taken as they are
+func collectBookFiles(inputs []string) ([]string, error) {
+	var files []string
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, input)
+			continue
+		}
+		err = filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && isBookInput(info.Name()) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func cmdThumb(args []string) {
+	thumbFlags := flag.NewFlagSet("thumb", flag.ExitOnError)
+	output := thumbFlags.String("o", "thumbnails", "The directory to write thumbnails and thumbs.json into")
+	width := thumbFlags.Int("width", 300, "Scale covers to fit this width; 0 leaves the width free")
+	height := thumbFlags.Int("height", 450, "Scale covers to fit this height; 0 leaves the height free")
+	workers := thumbFlags.Int("j", runtime.NumCPU(), "Number of files to process in parallel")
+	force := thumbFlags.Bool("force", false, "Regenerate thumbnails even if they are up to date")
+	runSilent := thumbFlags.Bool("s", false, "Silent mode, only print errors")
+	runVerbose := thumbFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	thumbFlags.Parse(args)
+
+	if thumbFlags.NArg() == 0 {
+		fmt.Printf("cbztools thumb v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools thumb [flags] <file or directory...>")
+		fmt.Println("Flags:")
+		thumbFlags.PrintDefaults()
+		os.Exit(1)
+	}
+	if *width < 0 || *height < 0 || (*width == 0 && *height == 0) {
+		fmt.Printf("Invalid size %dx%d, set a width, a height or both\n", *width, *height)
+		os.Exit(1)
+	}
+	if *workers < 1 {
+		fmt.Printf("Invalid number of workers %d\n", *workers)
+		os.Exit(1)
+	}
+
+	files, err := collectBookFiles(thumbFlags.Args())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Println("No supported files found")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*output, 0o755); err != nil {
+		fmt.Printf("Could not create %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+
+	opts := thumbOptions{Width: *width, Height: *height, Workers: *workers, Force: *force}
+	results, err := generateThumbnails(files, *output, opts)
+	if err != nil {
+		fmt.Printf("Could not update %s: %v\n", filepath.Join(*output, thumbIndexName), err)
+		if results == nil {
+			os.Exit(1)
+		}
+	}
+
+	generated, upToDate, failed := 0, 0, 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("Could not make a thumbnail of %s: %v\n", result.Path, result.Err)
+			failed++
+		case result.Generated:
+			generated++
+			printIfVerbose(fmt.Sprintf("%s -> %s", result.Path, result.Thumbnail), runVerbose)
+		default:
+			upToDate++
+			printIfVerbose(fmt.Sprintf("%s is up to date", result.Path), runVerbose)
+		}
+	}
+	printIfNotSilent(fmt.Sprintf("Generated %d thumbnails, %d up to date, %d failed", generated, upToDate, failed), runSilent, runVerbose)
+	if failed > 0 || err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFrontCover(t *testing.T) {
+	book := testBook(t, ComicInfo{}, 2, 2)
+	if cover, _ := book.frontCover(); cover.Name != book.Pages[0].Name {
+		t.Errorf("Expected the first page without a FrontCover page, got %s", cover.Name)
+	}
+	book.Pages[2].Name = "cover.png"
+	book.Pages[2].Type = comicPageFrontCover
+	if cover, _ := book.frontCover(); cover.Name != "cover.png" {
+		t.Errorf("Expected the page marked FrontCover, got %s", cover.Name)
+	}
+	if _, err := (&Book{}).frontCover(); err == nil {
+		t.Error("Expected an error for a book without pages")
+	}
+}
+
+func TestGenerateThumbnails(t *testing.T) {
+	library := filepath.Join(t.TempDir(), "library")
+	os.MkdirAll(filepath.Join(library, "Series"), 0o755)
+	first := filepath.Join(library, "Series", "v01.cbz")
+	second := filepath.Join(library, "v02.cbz")
+	book := testBook(t, ComicInfo{Series: "Series"}, 2)
+	book.Pages[1] = testPNGPage(t, "cover.png", 600, 900)
+	book.Pages[1].Type = comicPageFrontCover
+	if err := writeBook(book, first, formatCBZ); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBook(testBook(t, ComicInfo{}, 1), second, formatCBZ); err != nil {
+		t.Fatal(err)
+	}
+	thumbs := filepath.Join(t.TempDir(), "thumbs")
+	os.MkdirAll(thumbs, 0o755)
+
+	files, err := collectBookFiles([]string{library})
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 files in the library, got %v, %v", files, err)
+	}
+	run := func(description string, opts thumbOptions, expectedGenerated int) []thumbResult {
+		results, err := generateThumbnails(files, thumbs, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", description, err)
+		}
+		generated := 0
+		for _, result := range results {
+			if result.Err != nil {
+				t.Fatalf("%s: %s: %v", description, result.Path, result.Err)
+			}
+			if result.Generated {
+				generated++
+			}
+		}
+		if generated != expectedGenerated {
+			t.Errorf("%s: expected %d thumbnails generated, got %d", description, expectedGenerated, generated)
+		}
+		return results
+	}
+
+	opts := thumbOptions{Width: 100, Height: 100, Workers: 2}
+	results := run("first run", opts, 2)
+	hash, _ := fileSHA256(first)
+	if results[0].Path != first || results[0].Thumbnail != filepath.Join(thumbs, hash+thumbExtension) {
+		t.Errorf("Expected the thumbnail of %s named by its hash, got %+v", first, results[0])
+	}
+	data, _ := os.ReadFile(results[0].Thumbnail)
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" || config.Width != 66 || config.Height != 100 {
+		t.Errorf("Expected a 66x100 JPEG of the FrontCover page, got a %dx%d %s, %v", config.Width, config.Height, format, err)
+	}
+	index, err := readThumbIndex(thumbs)
+	abs, _ := filepath.Abs(first)
+	if err != nil || index.Files[abs].SHA256 != hash {
+		t.Errorf("Expected %s in the index with hash %s, got %+v, %v", abs, hash, index.Files[abs], err)
+	}
+
+	run("unchanged", opts, 0)
+	run("forced", thumbOptions{Width: 100, Height: 100, Workers: 2, Force: true}, 2)
+	run("new size", thumbOptions{Width: 50, Height: 100, Workers: 2}, 2)
+
+	// A deleted thumbnail is made again, and a changed file gets a new one
+	os.Remove(results[1].Thumbnail)
+	if err := writeBook(testBook(t, ComicInfo{}, 3), first, formatCBZ); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(first, later, later)
+	results = run("changed", thumbOptions{Width: 50, Height: 100, Workers: 2}, 2)
+	if results[0].Thumbnail == filepath.Join(thumbs, hash+thumbExtension) {
+		t.Errorf("Expected a new thumbnail for the changed file, got %s", results[0].Thumbnail)
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Severities of verify findings. Errors make the command fail, warnings don't.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// verifyFinding is a problem found in a file
+type verifyFinding struct {
+	Severity string
+	Subject  string // entry, page or element the finding is about
+	Message  string
+}
+
+// verifyReport holds the findings for one file
+type verifyReport struct {
+	Path     string
+	Findings []verifyFinding
+}
+
+func (r *verifyReport) add(severity string, subject string, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, verifyFinding{Severity: severity, Subject: subject, Message: fmt.Sprintf(format, args...)})
+}
+
+// count returns the number of findings with the severity
+func (r verifyReport) count(severity string) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// comicInfoValueType checks the text of a simple ComicInfo element, returning a description of the problem or ""
+type comicInfoValueType func(value string) string
+
+func xsdInt(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 32); err != nil {
+		return fmt.Sprintf("%q is not an integer", value)
+	}
+	return ""
+}
+
+func xsdString(string) string {
+	return ""
+}
+
+func xsdEnum(values ...string) comicInfoValueType {
+	return func(value string) string {
+		for _, v := range values {
+			if value == v {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%q is not one of %s", value, strings.Join(values, ", "))
+	}
+}
+
+// xsdRating is the Rating type of CommunityRating, a decimal from 0 to 5
+func xsdRating(value string) string {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || f > 5 {
+		return fmt.Sprintf("%q is not a rating from 0 to 5", value)
+	}
+	return ""
+}
+
+// comicInfoElement is an element of the ComicInfo schema
+type comicInfoElement struct {
+	Name  string
+	Check comicInfoValueType // nil for complex elements
+}
+
+// comicInfoSchema lists the ComicInfo v2.0 elements in schema order, with the v2.1 additions in place
+var comicInfoSchema = []comicInfoElement{
+	{"Title", xsdString},
+	{"Series", xsdString},
+	{"Number", xsdString},
+	{"Count", xsdInt},
+	{"Volume", xsdInt},
+	{"AlternateSeries", xsdString},
+	{"AlternateNumber", xsdString},
+	{"AlternateCount", xsdInt},
+	{"Summary", xsdString},
+	{"Notes", xsdString},
+	{"Year", xsdInt},
+	{"Month", xsdInt},
+	{"Day", xsdInt},
+	{"Writer", xsdString},
+	{"Penciller", xsdString},
+	{"Inker", xsdString},
+	{"Colorist", xsdString},
+	{"Letterer", xsdString},
+	{"CoverArtist", xsdString},
+	{"Editor", xsdString},
+	{"Translator", xsdString}, // v2.1
+	{"Publisher", xsdString},
+	{"Imprint", xsdString},
+	{"Genre", xsdString},
+	{"Tags", xsdString}, // v2.1
+	{"Web", xsdString},
+	{"PageCount", xsdInt},
+	{"LanguageISO", xsdString},
+	{"Format", xsdString},
+	{"BlackAndWhite", xsdEnum("Unknown", "No", "Yes")},
+	{"Manga", xsdEnum("Unknown", "No", "Yes", mangaRightToLeft)},
+	{"Characters", xsdString},
+	{"Teams", xsdString},
+	{"Locations", xsdString},
+	{"ScanInformation", xsdString},
+	{"StoryArc", xsdString},
+	{"StoryArcNumber", xsdString}, // v2.1
+	{"SeriesGroup", xsdString},
+	{"AgeRating", xsdEnum("Unknown", "Adults Only 18+", "Early Childhood", "Everyone", "Everyone 10+", "G", "Kids to Adults",
+		"M", "MA15+", "Mature 17+", "PG", "R18+", "Rating Pending", "Teen", "X18+")},
+	{"Pages", nil},
+	{"CommunityRating", xsdRating},
+	{"MainCharacterOrTeam", xsdString},
+	{"Review", xsdString},
+	{"GTIN", xsdString}, // v2.1
+}
+
+// comicPageTypes are the values of the Type attribute of a Page
+var comicPageTypes = xsdEnum("FrontCover", "InnerCover", "Roundup", "Story", "Advertisement", "Editorial", "Letters",
+	"Preview", "BackCover", "Other", "Deleted")
+
+// comicPageAttributes maps the attributes of a Page to their types
+var comicPageAttributes = map[string]comicInfoValueType{
+	"Image":       xsdInt,
+	"Type":        comicPageTypes,
+	"DoublePage":  xsdEnum("true", "false", "1", "0"),
+	"ImageSize":   xsdInt,
+	"Key":         xsdString,
+	"Bookmark":    xsdString,
+	"ImageWidth":  xsdInt,
+	"ImageHeight": xsdInt,
+}
+
+// verifyComicInfo checks ComicInfo.xml against the rules of the v2.0 and v2.1 schemas: known elements in schema order,
+// each at most once, values of the right type and enumerations. PageCount and Pages are checked against the page count.
+func verifyComicInfo(report *verifyReport, data []byte, pageCount int) {
+	const subject = "ComicInfo.xml"
+	order := make(map[string]int)
+	for i, element := range comicInfoSchema {
+		order[element.Name] = i
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root *xml.StartElement
+	seen := make(map[string]bool)
+	last := -1
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.add(severityError, subject, "not well-formed XML: %v", err)
+			return
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root == nil {
+			root = &start
+			if start.Name.Local != "ComicInfo" {
+				report.add(severityError, subject, "root element is <%s>, not <ComicInfo>", start.Name.Local)
+				return
+			}
+			continue
+		}
+
+		name := start.Name.Local
+		index, known := order[name]
+		if !known {
+			report.add(severityWarning, subject, "<%s> is not a ComicInfo element", name)
+			d.Skip()
+			continue
+		}
+		if seen[name] {
+			report.add(severityError, subject, "<%s> appears more than once", name)
+		}
+		seen[name] = true
+		if index < last {
+			report.add(severityWarning, subject, "<%s> is out of schema order", name)
+		}
+		if index > last {
+			last = index
+		}
+
+		if name == "Pages" {
+			verifyComicPages(report, d, pageCount)
+			continue
+		}
+		var value string
+		if err := d.DecodeElement(&value, &start); err != nil {
+			report.add(severityError, subject, "not well-formed XML: %v", err)
+			return
+		}
+		value = strings.TrimSpace(value)
+		if problem := comicInfoSchema[index].Check(value); problem != "" {
+			report.add(severityError, subject, "<%s>: %s", name, problem)
+			continue
+		}
+
+		if name == "PageCount" {
+			if n, _ := strconv.Atoi(value); n != 0 && n != pageCount {
+				report.add(severityError, subject, "PageCount is %d, but there are %d pages", n, pageCount)
+			}
+		}
+		if name == "Month" {
+			if n, _ := strconv.Atoi(value); n != -1 && (n < 1 || n > 12) {
+				report.add(severityWarning, subject, "<Month> %d is not a month", n)
+			}
+		}
+		if name == "Day" {
+			if n, _ := strconv.Atoi(value); n != -1 && (n < 1 || n > 31) {
+				report.add(severityWarning, subject, "<Day> %d is not a day of the month", n)
+			}
+		}
+	}
+	if root == nil {
+		report.add(severityError, subject, "empty document")
+	}
+}
+
+// verifyComicPages checks the <Page> elements inside <Pages>, the decoder is positioned right after <Pages>
+func verifyComicPages(report *verifyReport, d *xml.Decoder, pageCount int) {
+	const subject = "ComicInfo.xml"
+	pages := 0
+	for {
+		token, err := d.Token()
+		if err != nil {
+			report.add(severityError, subject, "<Pages>: %v", err)
+			return
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			if pages != pageCount {
+				report.add(severityWarning, subject, "<Pages> describes %d pages, but there are %d", pages, pageCount)
+			}
+			return
+		case xml.StartElement:
+			if t.Name.Local != "Page" {
+				report.add(severityWarning, subject, "<%s> is not allowed in <Pages>", t.Name.Local)
+				d.Skip()
+				continue
+			}
+			pages++
+			hasImage := false
+			for _, a := range t.Attr {
+				check, known := comicPageAttributes[a.Name.Local]
+				if !known {
+					report.add(severityWarning, subject, "<Page> attribute %s is not in the schema", a.Name.Local)
+					continue
+				}
+				if problem := check(a.Value); problem != "" {
+					report.add(severityError, subject, "<Page> attribute %s: %s", a.Name.Local, problem)
+					continue
+				}
+				if a.Name.Local == "Image" {
+					hasImage = true
+					if n, _ := strconv.Atoi(a.Value); n < 0 || n >= pageCount {
+						report.add(severityError, subject, "<Page Image=\"%d\"> refers to a page that doesn't exist", n)
+					}
+				}
+			}
+			if !hasImage {
+				report.add(severityError, subject, "<Page> without the required Image attribute")
+			}
+			d.Skip()
+		}
+	}
+}
+
+// verifyEntries reads every entry of an archive completely, which checks the CRCs of zip, RAR and 7z entries.
+// It returns the content of the ComicInfo.xml closest to the root, nil if there is none.
+func verifyEntries(report *verifyReport, filePath string) []byte {
+	var meta metadataFiles
+	err := walkEntries(filePath, func(name string, open func() (io.ReadCloser, error)) error {
+		rc, err := open()
+		if err != nil {
+			report.add(severityError, name, "%v", err)
+			return nil
+		}
+		defer rc.Close()
+		read := false
+		meta.offer(name, func() ([]byte, error) {
+			var data []byte
+			read = true
+			data, err = io.ReadAll(rc)
+			return data, err
+		})
+		if !read {
+			_, err = io.Copy(io.Discard, rc)
+		}
+		if err != nil {
+			report.add(severityError, name, "%v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		report.add(severityError, path.Base(filePath), "%v", err)
+	}
+	comicInfo, _ := meta.data(metaSourceComicInfo)
+	return comicInfo
+}
+
+// verifyPages decodes the header of every page, or with full the whole image
+func verifyPages(report *verifyReport, pages []Page, full bool) {
+	for i, page := range pages {
+		subject := fmt.Sprintf("page %d (%s)", i+1, page.Name)
+		rc, err := page.Open()
+		if err != nil {
+			report.add(severityError, subject, "%v", err)
+			continue
+		}
+		var format string
+		if full {
+			_, format, err = image.Decode(rc)
+		} else {
+			_, format, err = image.DecodeConfig(rc)
+		}
+		rc.Close()
+		if err != nil {
+			report.add(severityError, subject, "not a valid image: %v", err)
+			continue
+		}
+		if ext := page.Ext(); !(format == "jpeg" && (ext == ".jpg" || ext == ".jpeg")) && "."+format != ext {
+			report.add(severityWarning, subject, "%s image with a %s extension", format, ext)
+		}
+	}
+}
+
+// verifyFile checks one archive, PDF, EPUB or folder
+func verifyFile(filePath string, full bool) verifyReport {
+	report := verifyReport{Path: filePath}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		report.add(severityError, path.Base(filePath), "%v", err)
+		return report
+	}
+	if !stat.IsDir() && !isBookInput(filePath) {
+		report.add(severityError, path.Base(filePath), "unsupported format")
+		return report
+	}
+
+	var comicInfo []byte
+	if _, hasEntries := entryListers[strings.ToLower(path.Ext(filePath))]; hasEntries || stat.IsDir() {
+		comicInfo = verifyEntries(&report, filePath)
+	}
+
+	book, err := readBook(filePath)
+	if err != nil {
+		report.add(severityError, path.Base(filePath), "could not be read: %v", err)
+		return report
+	}
+	defer book.Close()
+	if len(book.Pages) == 0 {
+		report.add(severityError, path.Base(filePath), "no pages")
+	}
+	verifyPages(&report, book.Pages, full)
+
+	switch inputFormat(filePath) {
+	case "pdf", "epub":
+		// Metadata lives in the document itself
+	default:
+		if comicInfo == nil {
+			report.add(severityWarning, "ComicInfo.xml", "missing")
+		} else {
+			verifyComicInfo(&report, comicInfo, len(book.Pages))
+		}
+	}
+	return report
+}
+
+// cmdVerify checks archives for corrupt entries, broken images and invalid ComicInfo.xml
+func cmdVerify(args []string) {
+	verifyFlags := flag.NewFlagSet("verify", flag.ExitOnError)
+	full := verifyFlags.Bool("full", false, "Decode every page completely instead of only its header, slower but also finds truncated images")
+	runSilent := verifyFlags.Bool("s", false, "Only print files with findings")
+	runVerbose := verifyFlags.Bool("v", false, "Verbose output, overrides -s (silent) flag")
+
+	verifyFlags.Parse(args)
+
+	if verifyFlags.NArg() == 0 {
+		fmt.Printf("cbztools verify v%s (%s)\n", Version, GitCommit)
+		fmt.Println("Usage: cbztools verify [flags] <file...>")
+		fmt.Println("Flags:")
+		verifyFlags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, filePath := range verifyFlags.Args() {
+		report := verifyFile(filePath, *full)
+		errors, warnings := report.count(severityError), report.count(severityWarning)
+		if errors > 0 {
+			failed++
+		}
+		if len(report.Findings) == 0 {
+			printIfNotSilent(fmt.Sprintf("%s: OK", filePath), runSilent, runVerbose)
+			continue
+		}
+		fmt.Printf("%s: %d errors, %d warnings\n", filePath, errors, warnings)
+		for _, f := range report.Findings {
+			fmt.Printf("  %s: %s: %s\n", f.Severity, f.Subject, f.Message)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d files have errors\n", failed, verifyFlags.NArg())
+		os.Exit(1)
+	}
+}
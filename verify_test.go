@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyComicInfo(t *testing.T) {
+	testCases := []struct {
+		description string
+		xml         string
+		errors      []string
+		warnings    []string
+	}{
+		{
+			description: "valid v2.1",
+			xml: `<?xml version="1.0"?><ComicInfo><Series>S</Series><Number>1.5</Number><Volume>2</Volume><Translator>T</Translator>
+				<PageCount>2</PageCount><Manga>YesAndRightToLeft</Manga><AgeRating>Teen</AgeRating>
+				<Pages><Page Image="0" Type="FrontCover" DoublePage="false"/><Page Image="1" ImageWidth="800"/></Pages>
+				<CommunityRating>4.5</CommunityRating><GTIN>123</GTIN></ComicInfo>`,
+		},
+		{
+			description: "wrong types and enums",
+			xml:         `<ComicInfo><Volume>two</Volume><Manga>Maybe</Manga><AgeRating>PG-13</AgeRating><CommunityRating>6</CommunityRating></ComicInfo>`,
+			errors:      []string{"<Volume>", "<Manga>", "<AgeRating>", "<CommunityRating>"},
+		},
+		{
+			description: "page count mismatch",
+			xml:         `<ComicInfo><PageCount>3</PageCount></ComicInfo>`,
+			errors:      []string{"PageCount is 3, but there are 2 pages"},
+		},
+		{
+			description: "zero page count is unset",
+			xml:         `<ComicInfo><PageCount>0</PageCount></ComicInfo>`,
+		},
+		{
+			description: "duplicate, unknown and out of order elements",
+			xml:         `<ComicInfo><Series>S</Series><Title>T</Title><Series>S</Series><Rating>5</Rating><Month>13</Month></ComicInfo>`,
+			errors:      []string{"<Series> appears more than once"},
+			warnings:    []string{"<Title> is out of schema order", "<Rating> is not a ComicInfo element", "<Month> 13"},
+		},
+		{
+			description: "bad pages",
+			xml:         `<ComicInfo><Pages><Page Image="5" Type="Cover"/><Page Kind="x"/></Pages></ComicInfo>`,
+			errors:      []string{`<Page Image="5">`, "attribute Type", "without the required Image"},
+			warnings:    []string{"attribute Kind"},
+		},
+		{
+			description: "wrong root",
+			xml:         `<ComicBookInfo/>`,
+			errors:      []string{"root element is <ComicBookInfo>"},
+		},
+		{
+			description: "malformed",
+			xml:         `<ComicInfo><Series>S</ComicInfo>`,
+			errors:      []string{"not well-formed XML"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var report verifyReport
+			verifyComicInfo(&report, []byte(tc.xml), 2)
+			checkFindings(t, report, severityError, tc.errors)
+			checkFindings(t, report, severityWarning, tc.warnings)
+		})
+	}
+}
+
+// Helper function to check that the findings of a severity match the expected messages, in order
+func checkFindings(t *testing.T, report verifyReport, severity string, expected []string) {
+	t.Helper()
+	var messages []string
+	for _, f := range report.Findings {
+		if f.Severity == severity {
+			messages = append(messages, f.Message)
+		}
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %d %ss, got %q", len(expected), severity, messages)
+	}
+	for i, message := range messages {
+		if !strings.Contains(message, expected[i]) {
+			t.Errorf("Expected %s %d to contain %q, got %q", severity, i, expected[i], message)
+		}
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	page, _ := testPNGPage(t, "page.png", 10, 20).ReadAll()
+	writeCBZ := func(t *testing.T, files []string, contents map[string][]byte) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range files {
+			w, _ := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+			w.Write(contents[name])
+		}
+		zw.Close()
+		path := filepath.Join(t.TempDir(), "book.cbz")
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	comicInfo := []byte("<ComicInfo><Series>S</Series><PageCount>2</PageCount></ComicInfo>")
+
+	t.Run("valid", func(t *testing.T) {
+		path := writeCBZ(t, []string{"1.png", "2.png", "ComicInfo.xml"}, map[string][]byte{"1.png": page, "2.png": page, "ComicInfo.xml": comicInfo})
+		if report := verifyFile(path, true); len(report.Findings) != 0 {
+			t.Errorf("Expected no findings, got %+v", report.Findings)
+		}
+	})
+
+	t.Run("corrupt entry", func(t *testing.T) {
+		path := writeCBZ(t, []string{"1.png", "notes.txt"}, map[string][]byte{"1.png": page, "notes.txt": []byte("intact content")})
+		data, _ := os.ReadFile(path)
+		os.WriteFile(path, bytes.Replace(data, []byte("intact"), []byte("broken"), 1), 0o644)
+
+		report := verifyFile(path, false)
+		if len(report.Findings) == 0 || report.Findings[0].Subject != "notes.txt" || report.Findings[0].Severity != severityError {
+			t.Errorf("Expected a checksum error for notes.txt, got %+v", report.Findings)
+		}
+		checkFindings(t, report, severityWarning, []string{"missing"})
+	})
+
+	t.Run("broken image", func(t *testing.T) {
+		path := writeCBZ(t, []string{"1.png", "2.jpg", "ComicInfo.xml"}, map[string][]byte{"1.png": []byte("not an image"), "2.jpg": page, "ComicInfo.xml": comicInfo})
+		report := verifyFile(path, false)
+		checkFindings(t, report, severityError, []string{"not a valid image"})
+		checkFindings(t, report, severityWarning, []string{"png image with a .jpg extension"})
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		os.WriteFile(path, []byte("text"), 0o644)
+		if report := verifyFile(path, false); report.count(severityError) != 1 {
+			t.Errorf("Expected an error for an unsupported file, got %+v", report.Findings)
+		}
+	})
+}